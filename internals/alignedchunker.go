@@ -0,0 +1,160 @@
+package internals
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// The chunking strategies that cut documents at text boundaries.
+const (
+	ChunkWords      = "words"
+	ChunkSentences  = "sentences"
+	ChunkParagraphs = "paragraphs"
+	ChunkLines      = "lines"
+)
+
+// alignedCutter cuts chunks of at most size bytes at the last text boundary of its kind that fits.
+// A chunk that cannot end at such a boundary falls back to the next finer one: paragraphs to
+// sentences, sentences and lines to words, and words to UTF-8 rune boundaries, so that a rune is
+// never split between two chunks. The final chunk of a document ends where the document does.
+type alignedCutter struct {
+	method string
+	size   int
+	lines  int
+}
+
+// newAlignedChunker returns a chunker that cuts r at the text boundaries named by spec.Method.
+func newAlignedChunker(r io.Reader, spec chunkSpec) chunker {
+	a := alignedCutter{method: spec.Method, size: spec.Size, lines: spec.Lines}
+	// One byte of lookahead tells whether a full chunk ends exactly at a word boundary
+	return newWindowChunker(r, spec.Size+1, a.cut)
+}
+
+// cut returns the length of the chunk at the start of window.
+func (a alignedCutter) cut(window []byte, eof bool) int {
+	fits := eof && len(window) <= a.size
+	limit := min(len(window), a.size)
+
+	switch a.method {
+	case ChunkLines:
+		if n := nthLineEnd(window[:limit], a.lines); n > 0 {
+			return n
+		}
+		if fits {
+			return len(window)
+		}
+		if n := lastLineEnd(window[:limit]); n > 0 {
+			return n
+		}
+	case ChunkParagraphs:
+		if fits {
+			return len(window)
+		}
+		if n := lastParagraphEnd(window[:limit]); n > 0 {
+			return n
+		}
+		if n := lastSentenceEnd(window, limit); n > 0 {
+			return n
+		}
+	case ChunkSentences:
+		if fits {
+			return len(window)
+		}
+		if n := lastSentenceEnd(window, limit); n > 0 {
+			return n
+		}
+	}
+	if fits {
+		return len(window)
+	}
+	return lastWordEnd(window, limit)
+}
+
+// isSpace reports whether b is an ASCII whitespace byte.
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+// nthLineEnd returns the offset just after the nth newline in data, or 0 if there are fewer.
+func nthLineEnd(data []byte, n int) int {
+	for i, b := range data {
+		if b == '\n' {
+			n--
+			if n == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// lastLineEnd returns the offset just after the last newline in data, or 0 if there is none.
+func lastLineEnd(data []byte) int {
+	for i := len(data) - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// lastParagraphEnd returns the offset just after the last blank line in data, or 0 if there is
+// none. A blank line is a newline followed by nothing but spaces, tabs or carriage returns and
+// another newline.
+func lastParagraphEnd(data []byte) int {
+	for i := len(data) - 1; i > 0; i-- {
+		if data[i] != '\n' {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if data[j] == '\n' {
+				return i + 1
+			}
+			if data[j] != ' ' && data[j] != '\t' && data[j] != '\r' {
+				break
+			}
+		}
+	}
+	return 0
+}
+
+// lastSentenceEnd returns the offset just after the last sentence terminator (., ! or ?) that is
+// followed by whitespace within the first limit bytes of window, including that whitespace byte,
+// or 0 if there is none.
+func lastSentenceEnd(window []byte, limit int) int {
+	for i := limit - 2; i >= 0; i-- {
+		switch window[i] {
+		case '.', '!', '?':
+			if isSpace(window[i+1]) {
+				return i + 2
+			}
+		}
+	}
+	return 0
+}
+
+// lastWordEnd returns the length of the longest prefix of the first limit bytes of window that
+// ends at a word boundary. If there is none, it returns the longest prefix that does not split a
+// UTF-8 rune.
+func lastWordEnd(window []byte, limit int) int {
+	if limit < len(window) && isSpace(window[limit]) {
+		return limit
+	}
+	for i := limit - 1; i >= 0; i-- {
+		if isSpace(window[i]) {
+			return i + 1
+		}
+	}
+	n := limit
+	for n > 0 && n < len(window) && !utf8.RuneStart(window[n]) {
+		n--
+	}
+	if n == 0 {
+		return limit
+	}
+	return n
+}
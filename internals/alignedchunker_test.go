@@ -0,0 +1,182 @@
+package internals
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestAlignedChunker checks the chunks cut by each text-aligned chunking on small inputs.
+func TestAlignedChunker(t *testing.T) {
+	tests := []struct {
+		name string
+		spec chunkSpec
+		text string
+		want []string
+	}{
+		{
+			"Words",
+			chunkSpec{Method: ChunkWords, Size: 10},
+			"the quick brown fox jumps",
+			[]string{"the quick ", "brown fox ", "jumps"},
+		},
+		{
+			"Word ends at size",
+			chunkSpec{Method: ChunkWords, Size: 9},
+			"the quick brown",
+			[]string{"the quick", " brown"},
+		},
+		{
+			"Long word",
+			chunkSpec{Method: ChunkWords, Size: 4},
+			"abcdefghij",
+			[]string{"abcd", "efgh", "ij"},
+		},
+		{
+			"Runes never split",
+			chunkSpec{Method: ChunkWords, Size: 5},
+			"ééééé",
+			[]string{"éé", "éé", "é"},
+		},
+		{
+			"Sentences",
+			chunkSpec{Method: ChunkSentences, Size: 30},
+			"One two. Three four! Five six? Seven",
+			[]string{"One two. Three four! ", "Five six? Seven"},
+		},
+		{
+			"Sentence falls back to words",
+			chunkSpec{Method: ChunkSentences, Size: 12},
+			"no full stop in sight here",
+			[]string{"no full stop", " in sight ", "here"},
+		},
+		{
+			"Paragraphs",
+			chunkSpec{Method: ChunkParagraphs, Size: 40},
+			"First para.\n\nSecond para.\n \nThird para is longer.",
+			[]string{"First para.\n\nSecond para.\n \n", "Third para is longer."},
+		},
+		{
+			"Paragraph falls back to sentences",
+			chunkSpec{Method: ChunkParagraphs, Size: 20},
+			"One. Two three four five six.",
+			[]string{"One. ", "Two three four five ", "six."},
+		},
+		{
+			"Lines",
+			chunkSpec{Method: ChunkLines, Size: 100, Lines: 2},
+			"a\nb\nc\nd\ne",
+			[]string{"a\nb\n", "c\nd\n", "e"},
+		},
+		{
+			"Long line falls back to words",
+			chunkSpec{Method: ChunkLines, Size: 8, Lines: 1},
+			"one two three\nfour",
+			[]string{"one two ", "three\n", "four"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, chunk := range chunksOf(t, tt.spec, []byte(tt.text)) {
+				got = append(got, string(chunk))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Chunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAlignedChunker_Boundaries checks on a larger multilingual text that every text-aligned
+// chunking covers the input, respects the size cap, and starts every chunk at a word.
+func TestAlignedChunker_Boundaries(t *testing.T) {
+	sentences := []string{" Grüße aus Köln.", " Naïve café owners!\n", " Ünïcödé wörds? ", "\n\n"}
+	var text []byte
+	for i := 0; i < 200; i++ {
+		text = append(text, randomText(40, int64(i))...)
+		text = append(text, sentences[i%len(sentences)]...)
+	}
+
+	for _, method := range []string{ChunkWords, ChunkSentences, ChunkParagraphs, ChunkLines} {
+		t.Run(method, func(t *testing.T) {
+			spec, err := IndexOptions{ChunkSize: 64, Chunking: method, LinesPerChunk: 2}.chunkSpec()
+			if err != nil {
+				t.Fatalf("chunkSpec() failed: %v", err)
+			}
+			chunks := chunksOf(t, spec, text)
+			if !bytes.Equal(bytes.Join(chunks, nil), text) {
+				t.Fatal("Chunks do not reassemble the input")
+			}
+			for i, chunk := range chunks {
+				if len(chunk) > spec.Size {
+					t.Errorf("Chunk %d has length %d, want at most %d", i, len(chunk), spec.Size)
+				}
+				if !utf8.Valid(chunk) {
+					t.Errorf("Chunk %d splits a UTF-8 character: %q", i, chunk)
+				}
+				if i > 0 && !isSpace(chunks[i-1][len(chunks[i-1])-1]) && !isSpace(chunk[0]) {
+					t.Errorf("Chunk %d starts inside a word: %q", i, chunk)
+				}
+			}
+		})
+	}
+}
+
+// TestRunIndex_Aligned verifies that lookup prints the phrase of an aligned chunk from its first
+// word, and that appending to a file updates a word-aligned index the same way a fresh build would.
+func TestRunIndex_Aligned(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	indexFile := filepath.Join(dir, "input.idx")
+	freshFile := filepath.Join(dir, "fresh.idx")
+	data := randomText(8*1024, 4)
+	os.WriteFile(input, data, 0644)
+
+	opts := IndexOptions{ChunkSize: 100, Chunking: ChunkWords}
+	if err := RunIndex([]string{input}, indexFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	indexData, err := loadIndexData(indexFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	var first Fingerprint
+	for simHash, postings := range indexData.Index {
+		for _, p := range postings {
+			if p.Offset == 0 {
+				first = simHash
+			}
+		}
+	}
+	results, err := Lookup(indexFile, fmt.Sprintf("%x", first), QueryOptions{})
+	if err != nil {
+		t.Fatalf("Lookup() failed: %v", err)
+	}
+	wantPhrase := strings.Join(strings.Fields(string(data[:results[0].Length]))[:1], " ")
+	if !strings.HasPrefix(results[0].Phrase, wantPhrase) {
+		t.Errorf("Phrase = %q, want it to start with %q", results[0].Phrase, wantPhrase)
+	}
+
+	future := time.Now().Add(time.Minute)
+	os.WriteFile(input, append(data, randomText(2048, 5)...), 0644)
+	os.Chtimes(input, future, future)
+	if err := RunUpdate(io.Discard, indexFile, nil); err != nil {
+		t.Fatalf("RunUpdate() failed: %v", err)
+	}
+	if err := RunIndex([]string{input}, freshFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	updated, _ := loadIndexData(indexFile)
+	fresh, _ := loadIndexData(freshFile)
+	if !reflect.DeepEqual(sortedPostings(updated.Index), sortedPostings(fresh.Index)) {
+		t.Error("Updated word-aligned index differs from a fresh build")
+	}
+}
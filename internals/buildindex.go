@@ -1,48 +1,249 @@
 package internals
 
 import (
-	"hash/fnv"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 type chunkData struct {
 	data   []byte
 	offset int64
+	read   int
 }
 type resultData struct {
-	simhash uint64
-	offset  int64
+	simhash   Fingerprint
+	signature []uint64
+	offset    int64
+	length    int
+	read      int
 }
 
 // BuildIndex reads the specified file, processes it in chunks, and builds an index based on SimHash values.
 // It uses multiple worker goroutines to compute SimHash values in parallel and a collector goroutine to
-// aggregate the results into the index.
+// aggregate the results into the index. Each successfully indexed file is appended to the document table,
+// together with its size, modification time and SHA-256 digest, so BuildIndex can be called repeatedly to
+// index several files into one corpus.
 //
 // Parameters:
 //
+//	ctx: Stops the build when it is cancelled; the file is then left out of the index.
 //	filename: The path to the file to be indexed.
 //
 // Returns:
 //
-//	error: An error if any occurs during file reading or processing.
+//	error: An error if any occurs during file reading or processing, or ctx.Err() if ctx is cancelled.
 //
 // The function performs the following steps:
 // 1. Opens the specified file.
 // 2. Creates channels for chunk data and result data.
 // 3. Starts worker goroutines to process file chunks and compute SimHash values.
-// 4. Starts a collector goroutine to aggregate the computed SimHash values into the index.
-// 5. Reads the file in chunks and sends the chunks to the worker goroutines via the chunk channel.
-// 6. Waits for all worker goroutines to finish processing.
+// 4. Starts a collector goroutine to aggregate the computed SimHash values into the index and report progress.
+// 5. Reads the file in chunks and sends the chunks to the worker goroutines via the chunk channel,
+// stopping early if reading fails or ctx is cancelled.
+// 6. Closes the chunk channel and waits for all worker goroutines to finish processing.
 // 7. Closes the result channel and waits for the collector goroutine to finish.
-func (fi *FileIndex) BuildIndex(filename string) error {
+// 8. On failure, removes the postings already added for the file; otherwise registers the file and its
+// fingerprint in the document table.
+func (fi *FileIndex) BuildIndex(ctx context.Context, filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return fi.addDocument(ctx, filename, file, info.ModTime())
+}
+
+// BuildIndexFrom indexes the contents of r as a document named name, in the same way as BuildIndex.
+// The document has no modification time, so it is always re-hashed when checked for changes, and
+// queries that read its chunks back expect a file to exist at name.
+//
+// Parameters:
+//
+//	ctx: Stops the build when it is cancelled; the document is then left out of the index.
+//	name: The path recorded for the document in the document table.
+//	r: The contents of the document.
+//	size: The number of bytes of r to index.
+//
+// Returns:
+//
+//	error: An error if any occurs while reading r, or ctx.Err() if ctx is cancelled.
+func (fi *FileIndex) BuildIndexFrom(ctx context.Context, name string, r io.ReaderAt, size int64) error {
+	return fi.addDocument(ctx, name, io.NewSectionReader(r, 0, size), time.Time{})
+}
+
+// addDocument indexes file from its start and appends it to the document table with its fingerprint.
+func (fi *FileIndex) addDocument(ctx context.Context, path string, file io.ReadSeeker, modTime time.Time) error {
+	docID := len(fi.documents)
+	digest := sha256.New()
+	fi.progress.startDocument(path)
+	size, err := fi.indexFrom(ctx, file, docID, 0, digest)
+	if err != nil {
+		return err
+	}
+
+	fi.documents = append(fi.documents, Document{
+		Path:    path,
+		Size:    size,
+		ModTime: modTime,
+		Digest:  hex.EncodeToString(digest.Sum(nil)),
+	})
+	return nil
+}
+
+// IndexData returns the index built so far, with a header for the given layout and chunking and,
+// for the gob layout, the permutation tables used by fuzzy search and the MinHash signatures.
+func (fi *FileIndex) IndexData(layout string) (IndexData, error) {
+	layout, err := parseLayout(layout)
+	if err != nil {
+		return IndexData{}, err
+	}
+	indexData := IndexData{
+		Header:    newIndexHeader(),
+		Documents: fi.documents,
+		ChunkSize: fi.chunkSize,
+		Index:     fi.index.m,
+	}
+	indexData.Header.Layout = layout
+	spec := fi.chunkSpec()
+	indexData.Header.Chunking = spec.Method
+	indexData.Header.MinChunkSize = spec.Min
+	indexData.Header.MaxChunkSize = spec.Max
+	indexData.Header.LinesPerChunk = spec.Lines
+	indexData.Header.Stride = spec.Stride
+	indexData.Header.setFeatures(fi.featureSpec())
+	indexData.Header.setHash(fi.hashing)
+	indexData.Header.setWeighting(fi.idf)
+	indexData.Header.FingerprintBits = fi.fingerprintBits()
+	indexData.Header.setMinHash(fi.minHash)
+	indexData.Tables = newDefaultPermutationIndex(fi.index.m, fi.fingerprintBits())
+	if fi.minHash.enabled() {
+		if layout != layoutGob {
+			return IndexData{}, fmt.Errorf("MinHash signatures can only be stored in the %q layout", layoutGob)
+		}
+		indexData.MinHash = NewMinHashIndex(fi.index.m, fi.signatures, fi.minHash.Perms, fi.minHash.Bands)
+	}
+	return indexData, nil
+}
+
+// Source is a document to be indexed by BuildIndexData. When Reader is nil the document is
+// read from the file at Path; otherwise Size bytes are read from Reader and Path is only
+// recorded as the document's name.
+type Source struct {
+	Path   string
+	Reader io.ReaderAt
+	Size   int64
+}
+
+// BuildIndexData indexes every source, in order, into a single corpus index.
+//
+// Parameters:
+//   - ctx: Stops the build when it is cancelled.
+//   - sources: The documents to index.
+//   - opts: The chunking, features, feature hash, fingerprint width, weighting, similarity and layout of the
+//     index, and the progress callback; the dump settings are ignored. With tf·idf weighting, the sources are read twice, and progress is only
+//     reported while they are hashed.
+//   - numWorkers: The number of goroutines hashing chunks in parallel.
+//
+// Returns:
+//   - IndexData: The built index, ready to be saved or queried.
+//   - error: An error if the options are invalid, ctx is cancelled, or a source cannot be read.
+func BuildIndexData(ctx context.Context, sources []Source, opts IndexOptions, numWorkers int) (IndexData, error) {
+	spec, err := opts.chunkSpec()
+	if err != nil {
+		return IndexData{}, err
+	}
+	features, err := opts.featureSpec()
+	if err != nil {
+		return IndexData{}, err
+	}
+	hashing, err := parseHash(opts.Hash, opts.HashKey)
+	if err != nil {
+		return IndexData{}, err
+	}
+	width, err := parseFingerprintBits(opts.FingerprintBits)
+	if err != nil {
+		return IndexData{}, err
+	}
+	weighting, err := parseWeighting(opts.Weighting)
+	if err != nil {
+		return IndexData{}, err
+	}
+	if _, err := parseLayout(opts.Layout); err != nil {
+		return IndexData{}, err
+	}
+	minHash, err := opts.minHashSpec()
+	if err != nil {
+		return IndexData{}, err
+	}
+
+	fi := NewFileIndex(opts.ChunkSize, numWorkers)
+	fi.chunking = spec
+	fi.features = features
+	fi.hashing = hashing
+	fi.bits = width
+	fi.minHash = minHash
+	if minHash.enabled() {
+		fi.signatures = make(map[Posting][]uint64)
+	}
+	if weighting == WeightingTFIDF {
+		if fi.idf, err = fi.documentFrequencies(ctx, sources); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return IndexData{}, ctxErr
+			}
+			return IndexData{}, fmt.Errorf("error counting document frequencies: %v", err)
+		}
+	}
+	if opts.Progress != nil {
+		var total int64
+		for _, src := range sources {
+			if src.Reader != nil {
+				total += src.Size
+			} else if info, err := os.Stat(src.Path); err == nil {
+				total += info.Size()
+			}
+		}
+		fi.progress = newProgressTracker(opts.Progress, total)
+	}
+
+	for _, src := range sources {
+		var err error
+		if src.Reader != nil {
+			err = fi.BuildIndexFrom(ctx, src.Path, src.Reader, src.Size)
+		} else {
+			err = fi.BuildIndex(ctx, src.Path)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return IndexData{}, ctxErr
+		}
+		if err != nil {
+			return IndexData{}, fmt.Errorf("error building index: %v", err)
+		}
+	}
+	fi.progress.flush()
+	return fi.IndexData(opts.Layout)
+}
+
+// indexFrom hashes the chunks of file starting at byte offset start and adds them to the index
+// as postings of document docID. Every byte read is also written to tee when it is not nil.
+// It returns the offset at which reading stopped, which is the size of the file. If reading fails
+// or ctx is cancelled, the workers are shut down, the postings added for docID from start onwards
+// are removed again, and the error is returned.
+func (fi *FileIndex) indexFrom(ctx context.Context, file io.ReadSeeker, docID int, start int64, tee io.Writer) (int64, error) {
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
 	chunkChannel := make(chan chunkData, 1000)
 	resultChannel := make(chan resultData, 1000)
 
@@ -50,13 +251,22 @@ func (fi *FileIndex) BuildIndex(filename string) error {
 	wg.Add(fi.numWorkers)
 
 	// Start worker goroutines to ensures efficient parallel processing of file chunks for SimHash computatio
+	features, hashing, idf, width, perms := fi.featureSpec(), fi.hashing, fi.idf, fi.fingerprintBits(), fi.minHash.Perms
 	for i := 0; i < fi.numWorkers; i++ {
 		go func() {
 			defer wg.Done()
-			h := fnv.New64a()
+			h := hashing.new()
 			for cd := range chunkChannel {
-				simhash := computeSimHash(cd.data, h)
-				resultChannel <- resultData{simhash, cd.offset}
+				// Drain without hashing once the build is cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+				simhash := features.fingerprint(cd.data, h, idf, width)
+				var signature []uint64
+				if perms > 0 {
+					signature = features.minHash(cd.data, h, perms)
+				}
+				resultChannel <- resultData{simhash, signature, cd.offset, len(cd.data), cd.read}
 			}
 		}()
 
@@ -64,32 +274,58 @@ func (fi *FileIndex) BuildIndex(filename string) error {
 	collectorDone := make(chan struct{})
 	go func() {
 		for rd := range resultChannel {
-			fi.index.m[rd.simhash] = append(fi.index.m[rd.simhash], rd.offset)
+			posting := Posting{DocID: docID, Offset: rd.offset, Length: rd.length}
+			fi.index.m[rd.simhash] = append(fi.index.m[rd.simhash], posting)
+			if rd.signature != nil {
+				fi.signatures[posting] = rd.signature
+			}
+			fi.progress.add(rd.read)
 		}
 		close(collectorDone)
 	}()
 
-	offset := int64(0)
-	buf := make([]byte, fi.chunkSize)
+	offset := start
+	chunks := fi.chunkSpec().newChunker(file)
+	var readErr error
 
+read:
 	for {
-		n, err := file.Read(buf)
+		if err := ctx.Err(); err != nil {
+			readErr = err
+			break
+		}
+		chunk, read, err := chunks.next()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				readErr = err
 			}
-			return err
+			break
+		}
+		// Overlapping chunks repeat bytes already read; only the new ones move the offset
+		offset += int64(read)
+		if tee != nil {
+			tee.Write(chunk[len(chunk)-read:])
 		}
 
-		data := make([]byte, n)
-		copy(data, buf[:n])
-		chunkChannel <- chunkData{data: data, offset: offset}
-		offset += int64(n)
+		data := make([]byte, len(chunk))
+		copy(data, chunk)
+		select {
+		case chunkChannel <- chunkData{data: data, offset: offset - int64(len(chunk)), read: read}:
+		case <-ctx.Done():
+			readErr = ctx.Err()
+			break read
+		}
 	}
+
+	// Always shut the workers and the collector down, so none are left blocked on a channel
 	close(chunkChannel)
 	wg.Wait()
 	close(resultChannel)
 	<-collectorDone
 
-	return nil
+	if readErr != nil {
+		fi.removePostings(docID, start)
+		return 0, readErr
+	}
+	return offset, nil
 }
@@ -1,8 +1,15 @@
 package internals
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestBuildIndex verifies that BuildIndex correctly processes a file and populates the index.
@@ -31,11 +38,11 @@ func TestBuildIndex(t *testing.T) {
 			fileIndex := &FileIndex{
 				numWorkers: 2,  // Use 2 worker goroutines for parallel processing
 				chunkSize:  16, // Read file in 16-byte chunks
-				index:      &Index{m: make(map[uint64][]int64)},
+				index:      &Index{m: make(map[Fingerprint][]Posting)},
 			}
 
 			// Run the BuildIndex function
-			err = fileIndex.BuildIndex(tt.filename)
+			err = fileIndex.BuildIndex(context.Background(), tt.filename)
 			if (err != nil) != tt.expectFail {
 				t.Fatalf("BuildIndex(%s) failed: expected failure = %v, got error = %v", tt.filename, tt.expectFail, err)
 			}
@@ -48,3 +55,74 @@ func TestBuildIndex(t *testing.T) {
 	}
 
 }
+
+// failingReader returns its data and then fails at a given offset.
+type failingReader struct {
+	data   []byte
+	failAt int64
+}
+
+func (r failingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.failAt {
+		return 0, errors.New("disk on fire")
+	}
+	n := copy(p, r.data[off:min(r.failAt, int64(len(r.data)))])
+	return n, nil
+}
+
+// TestBuildIndex_Abort verifies that a failed read or a cancelled context stops the workers,
+// leaves no postings behind, and does not register the document.
+func TestBuildIndex_Abort(t *testing.T) {
+	data := []byte(strings.Repeat("some words to hash ", 200))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		r    io.ReaderAt
+	}{
+		{"Read error", context.Background(), failingReader{data, 1000}},
+		{"Cancelled", cancelled, bytes.NewReader(data)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			fi := NewFileIndex(16, 4)
+			if err := fi.BuildIndexFrom(tt.ctx, "doc", tt.r, int64(len(data))); err == nil {
+				t.Fatal("Expected BuildIndexFrom() to fail, got nil")
+			}
+			if len(fi.index.m) != 0 || len(fi.documents) != 0 {
+				t.Errorf("Aborted build left %d hashes and %d documents", len(fi.index.m), len(fi.documents))
+			}
+			// Workers exit before indexFrom returns; allow the scheduler to finish them
+			for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+				time.Sleep(time.Millisecond)
+			}
+			if after := runtime.NumGoroutine(); after > before {
+				t.Errorf("Goroutines leaked: %d before, %d after", before, after)
+			}
+		})
+	}
+}
+
+// TestBuildIndexData_Progress checks that progress is reported up to the full size of the corpus.
+func TestBuildIndexData_Progress(t *testing.T) {
+	data := strings.Repeat("x", 100)
+	var last Progress
+	calls := 0
+	opts := IndexOptions{ChunkSize: 16, Progress: func(p Progress) { last = p; calls++ }}
+	sources := []Source{
+		{Path: "a", Reader: strings.NewReader(data), Size: 100},
+		{Path: "b", Reader: strings.NewReader(data), Size: 50},
+	}
+	if _, err := BuildIndexData(context.Background(), sources, opts, 2); err != nil {
+		t.Fatalf("BuildIndexData() failed: %v", err)
+	}
+	want := Progress{Document: "b", BytesDone: 150, BytesTotal: 150, Chunks: 7 + 4}
+	if calls == 0 || last.Document != want.Document || last.BytesDone != want.BytesDone ||
+		last.BytesTotal != want.BytesTotal || last.Chunks != want.Chunks {
+		t.Errorf("Last progress = %+v after %d calls, want %+v", last, calls, want)
+	}
+}
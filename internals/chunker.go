@@ -0,0 +1,317 @@
+package internals
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// The strategies for cutting a document into chunks.
+const (
+	ChunkFixed = "fixed"
+	ChunkCDC   = "cdc"
+)
+
+// minCDCChunkSize is the smallest average chunk size content-defined chunking accepts; below it
+// the boundary masks have too few bits to be meaningful.
+const minCDCChunkSize = 64
+
+// chunkSpec describes how documents are cut into chunks. For fixed chunking every chunk is Size
+// bytes long, except the last one of a document. For content-defined chunking Size is the average
+// chunk length, and every chunk but the last one is between Min and Max bytes long. For the
+// text-aligned methods Size is the largest chunk length, and Lines is the number of lines in a
+// ChunkLines chunk. When Stride is set, fixed chunks are overlapping windows of Size bytes that
+// start every Stride bytes.
+type chunkSpec struct {
+	Method string
+	Size   int
+	Min    int
+	Max    int
+	Lines  int
+	Stride int
+}
+
+// chunker splits a stream into chunks.
+type chunker interface {
+	// next returns the next chunk and the number of bytes at its end that no earlier chunk
+	// contained, or io.EOF once the stream is exhausted. Unless chunks overlap, that is the
+	// whole chunk. The returned slice is only valid until the following call.
+	next() ([]byte, int, error)
+}
+
+// chunkSpec validates the chunking settings of opts and fills in their defaults: a content-defined
+// chunk is at least a quarter and at most four times the average size, a line-aligned chunk
+// holds a single line, and fixed chunks do not overlap, unless set otherwise.
+func (opts IndexOptions) chunkSpec() (chunkSpec, error) {
+	spec := chunkSpec{
+		Method: opts.Chunking,
+		Size:   opts.ChunkSize,
+		Min:    opts.MinChunkSize,
+		Max:    opts.MaxChunkSize,
+		Lines:  opts.LinesPerChunk,
+		Stride: opts.Stride,
+	}
+	if spec.Size <= 0 {
+		return spec, fmt.Errorf("invalid chunk size: %d, must be greater than 0", spec.Size)
+	}
+	if spec.Stride < 0 || spec.Stride > spec.Size {
+		return spec, fmt.Errorf("invalid stride: %d, must be between 0, for no overlap, and the chunk size %d", spec.Stride, spec.Size)
+	}
+	if spec.Stride == spec.Size {
+		// Windows that start a whole chunk apart are just fixed chunks
+		spec.Stride = 0
+	}
+	if spec.Stride != 0 && spec.Method != "" && spec.Method != ChunkFixed {
+		return spec, fmt.Errorf("invalid stride: overlapping chunks need %q chunking, not %q", ChunkFixed, spec.Method)
+	}
+	switch spec.Method {
+	case "", ChunkFixed:
+		spec.Method = ChunkFixed
+		spec.Min, spec.Max, spec.Lines = 0, 0, 0
+	case ChunkCDC:
+		if spec.Size < minCDCChunkSize {
+			return spec, fmt.Errorf("invalid chunk size: %d, content-defined chunking needs at least %d", spec.Size, minCDCChunkSize)
+		}
+		if spec.Min == 0 {
+			spec.Min = spec.Size / 4
+		}
+		if spec.Max == 0 {
+			spec.Max = spec.Size * 4
+		}
+		if spec.Min <= 0 || spec.Min > spec.Size || spec.Max < spec.Size {
+			return spec, fmt.Errorf("invalid chunk sizes: need 0 < min (%d) <= average (%d) <= max (%d)", spec.Min, spec.Size, spec.Max)
+		}
+		spec.Lines = 0
+	case ChunkWords, ChunkSentences, ChunkParagraphs:
+		spec.Min, spec.Max, spec.Lines = 0, 0, 0
+	case ChunkLines:
+		spec.Min, spec.Max = 0, 0
+		if spec.Lines == 0 {
+			spec.Lines = 1
+		}
+		if spec.Lines < 0 {
+			return spec, fmt.Errorf("invalid lines per chunk: %d, must be greater than 0", spec.Lines)
+		}
+	default:
+		return spec, fmt.Errorf("unknown chunking %q, must be one of %q, %q, %q, %q, %q or %q", spec.Method,
+			ChunkFixed, ChunkCDC, ChunkWords, ChunkSentences, ChunkParagraphs, ChunkLines)
+	}
+	return spec, nil
+}
+
+// chunkSpec returns the chunking an index was built with. Indexes written before chunking
+// was configurable used fixed chunks.
+func (d IndexData) chunkSpec() chunkSpec {
+	method := d.Header.Chunking
+	if method == "" {
+		method = ChunkFixed
+	}
+	return chunkSpec{
+		Method: method,
+		Size:   d.ChunkSize,
+		Min:    d.Header.MinChunkSize,
+		Max:    d.Header.MaxChunkSize,
+		Lines:  d.Header.LinesPerChunk,
+		Stride: d.Header.Stride,
+	}
+}
+
+// chunkSpec returns how fi cuts documents into chunks, which is fixed chunks of its chunk size
+// unless set otherwise.
+func (fi *FileIndex) chunkSpec() chunkSpec {
+	if fi.chunking.Method == "" {
+		return chunkSpec{Method: ChunkFixed, Size: fi.chunkSize}
+	}
+	return fi.chunking
+}
+
+// newChunker returns a chunker that cuts r according to the spec.
+func (s chunkSpec) newChunker(r io.Reader) chunker {
+	switch {
+	case s.Method == ChunkCDC:
+		return newCDCChunker(r, s)
+	case s.aligned():
+		return newAlignedChunker(r, s)
+	case s.overlapping():
+		return &slidingChunker{r: r, buf: make([]byte, s.Size), stride: s.Stride}
+	}
+	return &fixedChunker{r: r, buf: make([]byte, s.Size)}
+}
+
+// overlapping reports whether consecutive chunks of the spec share bytes.
+func (s chunkSpec) overlapping() bool {
+	return s.Stride > 0 && s.Stride < s.Size
+}
+
+// aligned reports whether the spec cuts chunks at text boundaries, so that every chunk starts
+// at the beginning of a word.
+func (s chunkSpec) aligned() bool {
+	switch s.Method {
+	case ChunkWords, ChunkSentences, ChunkParagraphs, ChunkLines:
+		return true
+	}
+	return false
+}
+
+// fixedChunker returns chunks of exactly the chunk size, except for the last one of the stream.
+// Each chunk is filled completely even when the reader returns fewer bytes per read, so that chunk
+// boundaries depend only on the content and never on how it was read.
+type fixedChunker struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (c *fixedChunker) next() ([]byte, int, error) {
+	n, err := io.ReadFull(c.r, c.buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.buf[:n], n, nil
+}
+
+// slidingChunker returns windows of the chunk size that start every stride bytes. The last
+// window ends at the end of the stream and may be shorter.
+type slidingChunker struct {
+	r       io.Reader
+	buf     []byte
+	n       int
+	stride  int
+	started bool
+	eof     bool
+}
+
+func (c *slidingChunker) next() ([]byte, int, error) {
+	if c.eof {
+		return nil, 0, io.EOF
+	}
+	// Slide the window forward, keeping the bytes it shares with the previous one
+	keep := 0
+	if c.started {
+		keep = copy(c.buf, c.buf[c.stride:c.n])
+	}
+	c.started = true
+	n, err := io.ReadFull(c.r, c.buf[keep:])
+	c.n = keep + n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		if n == 0 {
+			return nil, 0, io.EOF
+		}
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return c.buf[:c.n], n, nil
+}
+
+// gearTable maps every byte to a pseudo-random 64-bit value for the Gear rolling hash. It is
+// generated from a fixed seed, and changing it would move every content-defined boundary, making
+// existing indexes impossible to update or compare.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5445585449445843) // "TEXTIDXC"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// windowChunker buffers a window of the stream and lets cut decide where the chunk at the start
+// of the window ends. The window holds size bytes unless the stream ends first, in which case
+// cut is told that it sees the end of the stream.
+type windowChunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+	err        error
+	cut        func(window []byte, eof bool) int
+}
+
+func newWindowChunker(r io.Reader, size int, cut func(window []byte, eof bool) int) *windowChunker {
+	return &windowChunker{r: r, buf: make([]byte, size), cut: cut}
+}
+
+func (c *windowChunker) next() ([]byte, int, error) {
+	// Refill so that a full window is buffered unless the stream ends first
+	if !c.eof && c.end-c.start < len(c.buf) {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		for c.end < len(c.buf) {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err != nil {
+				c.eof = true
+				if err != io.EOF {
+					c.err = err
+				}
+				break
+			}
+		}
+	}
+	if c.err != nil {
+		return nil, 0, c.err
+	}
+	if c.start == c.end {
+		return nil, 0, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end], c.eof)
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, n, nil
+}
+
+// cdcCutter finds content-defined boundaries in the style of FastCDC: a Gear rolling hash is
+// computed over each byte after the minimum chunk size, and a boundary is declared where its
+// masked bits are all zero. A stricter mask is used before the average size and a looser one
+// after it, which keeps chunk lengths close to the average. Because boundaries depend only on
+// nearby content, inserting text into a document only changes the chunks around the insertion.
+type cdcCutter struct {
+	spec  chunkSpec
+	maskS uint64
+	maskL uint64
+}
+
+func newCDCChunker(r io.Reader, spec chunkSpec) chunker {
+	// Use the high bits of the hash, which depend on the most recent 64 bytes
+	n := bits.Len(uint(spec.Size)) - 1
+	c := &cdcCutter{
+		spec:  spec,
+		maskS: ^uint64(0) << (64 - (n + 1)),
+		maskL: ^uint64(0) << (64 - (n - 1)),
+	}
+	return newWindowChunker(r, spec.Max, c.cut)
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *cdcCutter) cut(data []byte, _ bool) int {
+	n := min(len(data), c.spec.Max)
+	if n <= c.spec.Min {
+		return n
+	}
+	normal := min(c.spec.Size, n)
+
+	var h uint64
+	i := c.spec.Min
+	for ; i < normal; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
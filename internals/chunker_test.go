@@ -0,0 +1,300 @@
+package internals
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// randomText returns n bytes of pseudo-random words from a fixed seed.
+func randomText(n int, seed int64) []byte {
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+	rng := rand.New(rand.NewSource(seed))
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		buf.WriteString(words[rng.Intn(len(words))])
+		buf.WriteByte(' ')
+	}
+	return buf.Bytes()[:n]
+}
+
+// chunksOf cuts data with spec and returns a copy of every chunk.
+func chunksOf(t *testing.T, spec chunkSpec, data []byte) [][]byte {
+	t.Helper()
+	c := spec.newChunker(bytes.NewReader(data))
+	var chunks [][]byte
+	for {
+		chunk, _, err := c.next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("next() failed: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+// TestCDCChunker checks that content-defined chunks cover the input, respect the size bounds,
+// and mostly survive an insertion near the start of the text.
+func TestCDCChunker(t *testing.T) {
+	spec, err := IndexOptions{ChunkSize: 256, Chunking: ChunkCDC}.chunkSpec()
+	if err != nil {
+		t.Fatalf("chunkSpec() failed: %v", err)
+	}
+	data := randomText(64*1024, 1)
+	chunks := chunksOf(t, spec, data)
+
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Fatal("Chunks do not reassemble the input")
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) < spec.Min || len(chunk) > spec.Max {
+			t.Errorf("Chunk %d has length %d, want between %d and %d", i, len(chunk), spec.Min, spec.Max)
+		}
+	}
+	if avg := len(data) / len(chunks); avg < spec.Size/2 || avg > spec.Size*2 {
+		t.Errorf("Average chunk length %d is far from %d", avg, spec.Size)
+	}
+
+	// Insert a word near the start: every chunk but the first few is unchanged
+	edited := append(append(append([]byte(nil), data[:100]...), "inserted "...), data[100:]...)
+	before := make(map[string]bool)
+	for _, chunk := range chunks {
+		before[string(chunk)] = true
+	}
+	editedChunks := chunksOf(t, spec, edited)
+	shared := 0
+	for _, chunk := range editedChunks {
+		if before[string(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(editedChunks)-3 {
+		t.Errorf("Only %d of %d chunks survived an insertion", shared, len(editedChunks))
+	}
+
+	// Fixed chunks, by contrast, are all shifted
+	fixed := chunkSpec{Method: ChunkFixed, Size: 256}
+	if shifted := chunksOf(t, fixed, edited); bytes.Equal(shifted[10], chunksOf(t, fixed, data)[10]) {
+		t.Error("Expected fixed chunks to shift after an insertion")
+	}
+}
+
+// TestIndexOptions_ChunkSpec checks the validation and defaults of the chunking options.
+func TestIndexOptions_ChunkSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    IndexOptions
+		want    chunkSpec
+		wantErr bool
+	}{
+		{"Fixed by default", IndexOptions{ChunkSize: 100}, chunkSpec{ChunkFixed, 100, 0, 0, 0, 0}, false},
+		{"CDC defaults", IndexOptions{ChunkSize: 1024, Chunking: ChunkCDC}, chunkSpec{ChunkCDC, 1024, 256, 4096, 0, 0}, false},
+		{"CDC bounds", IndexOptions{ChunkSize: 1024, Chunking: ChunkCDC, MinChunkSize: 512, MaxChunkSize: 2048}, chunkSpec{ChunkCDC, 1024, 512, 2048, 0, 0}, false},
+		{"CDC too small", IndexOptions{ChunkSize: 16, Chunking: ChunkCDC}, chunkSpec{}, true},
+		{"Min above average", IndexOptions{ChunkSize: 1024, Chunking: ChunkCDC, MinChunkSize: 2048}, chunkSpec{}, true},
+		{"Max below average", IndexOptions{ChunkSize: 1024, Chunking: ChunkCDC, MaxChunkSize: 512}, chunkSpec{}, true},
+		{"Words", IndexOptions{ChunkSize: 100, Chunking: ChunkWords, MinChunkSize: 10, LinesPerChunk: 3}, chunkSpec{ChunkWords, 100, 0, 0, 0, 0}, false},
+		{"Lines default", IndexOptions{ChunkSize: 100, Chunking: ChunkLines}, chunkSpec{ChunkLines, 100, 0, 0, 1, 0}, false},
+		{"Lines", IndexOptions{ChunkSize: 100, Chunking: ChunkLines, LinesPerChunk: 3}, chunkSpec{ChunkLines, 100, 0, 0, 3, 0}, false},
+		{"Negative lines", IndexOptions{ChunkSize: 100, Chunking: ChunkLines, LinesPerChunk: -1}, chunkSpec{}, true},
+		{"Stride", IndexOptions{ChunkSize: 100, Stride: 25}, chunkSpec{ChunkFixed, 100, 0, 0, 0, 25}, false},
+		{"Stride of a chunk", IndexOptions{ChunkSize: 100, Stride: 100}, chunkSpec{ChunkFixed, 100, 0, 0, 0, 0}, false},
+		{"Stride above size", IndexOptions{ChunkSize: 100, Stride: 200}, chunkSpec{}, true},
+		{"Stride with CDC", IndexOptions{ChunkSize: 1024, Chunking: ChunkCDC, Stride: 256}, chunkSpec{}, true},
+		{"Unknown", IndexOptions{ChunkSize: 1024, Chunking: "rabin"}, chunkSpec{}, true},
+		{"No size", IndexOptions{}, chunkSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.chunkSpec()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chunkSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("chunkSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunIndex_CDC verifies that a content-defined index records each chunk's length, and that
+// appending to a file updates it the same way a fresh build would.
+func TestRunIndex_CDC(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	indexFile := filepath.Join(dir, "input.idx")
+	freshFile := filepath.Join(dir, "fresh.idx")
+	data := randomText(16*1024, 2)
+	os.WriteFile(input, data, 0644)
+
+	opts := IndexOptions{ChunkSize: 256, Chunking: ChunkCDC}
+	if err := RunIndex([]string{input}, indexFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	indexData, err := loadIndexData(indexFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	total := 0
+	for _, postings := range indexData.Index {
+		for _, p := range postings {
+			total += p.Length
+		}
+	}
+	if total != len(data) {
+		t.Errorf("Chunk lengths add up to %d, want %d", total, len(data))
+	}
+
+	future := time.Now().Add(time.Minute)
+	os.WriteFile(input, append(data, randomText(4096, 3)...), 0644)
+	os.Chtimes(input, future, future)
+	if err := RunUpdate(io.Discard, indexFile, nil); err != nil {
+		t.Fatalf("RunUpdate() failed: %v", err)
+	}
+	if err := RunIndex([]string{input}, freshFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	updated, _ := loadIndexData(indexFile)
+	fresh, _ := loadIndexData(freshFile)
+	if !reflect.DeepEqual(sortedPostings(updated.Index), sortedPostings(fresh.Index)) {
+		t.Error("Updated content-defined index differs from a fresh build")
+	}
+}
+
+// TestSlidingChunker checks that overlapping windows start every stride bytes, that the last
+// window ends at the end of the input, and that the new bytes of each window add up to the input.
+func TestSlidingChunker(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want []string
+	}{
+		{"Shorter than a window", 12, []string{"abcdefghij"}},
+		{"Ends on a window", 6, []string{"abcdef", "defghi", "ghij"}},
+		{"Partial last window", 4, []string{"abcd", "defg", "ghij"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := chunkSpec{Method: ChunkFixed, Size: tt.size, Stride: 3}.newChunker(strings.NewReader("abcdefghij"))
+			var got []string
+			read := 0
+			for {
+				chunk, n, err := c.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("next() failed: %v", err)
+				}
+				got = append(got, string(chunk))
+				read += n
+			}
+			if !reflect.DeepEqual(got, tt.want) || read != 10 {
+				t.Errorf("Windows = %q reading %d bytes, want %q reading 10", got, read, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunIndex_Stride verifies that overlapping windows are indexed at every stride, that a passage
+// matched by several of them is reported as one region, and that appending to a file updates the
+// index the same way a fresh build would.
+func TestRunIndex_Stride(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	indexFile := filepath.Join(dir, "input.idx")
+	freshFile := filepath.Join(dir, "fresh.idx")
+	data := bytes.Repeat([]byte("abcdefgh"), 64) // Every window of the repeated text looks the same
+	data = append(data, randomText(1000, 6)...)
+	os.WriteFile(input, data, 0644)
+
+	opts := IndexOptions{ChunkSize: 64, Stride: 16}
+	if err := RunIndex([]string{input}, indexFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	indexData, err := loadIndexData(indexFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	windows := 0
+	for _, postings := range indexData.Index {
+		windows += len(postings)
+	}
+	if want := (len(data)-64+15)/16 + 1; windows != want {
+		t.Errorf("Indexed %d windows, want %d", windows, want)
+	}
+
+	// The windows over the repeated text all share a SimHash and collapse into one region
+	repeated := computeSimHash(data[:64], fnv.New64a())
+	results, err := Lookup(indexFile, fmt.Sprintf("%x", repeated), QueryOptions{})
+	if err != nil {
+		t.Fatalf("Lookup() failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Offset != 0 || results[0].End() < 512 {
+		t.Errorf("Lookup() = %+v, want one region from 0 covering the repeated text", results)
+	}
+
+	future := time.Now().Add(time.Minute)
+	os.WriteFile(input, append(data, randomText(500, 7)...), 0644)
+	os.Chtimes(input, future, future)
+	if err := RunUpdate(io.Discard, indexFile, nil); err != nil {
+		t.Fatalf("RunUpdate() failed: %v", err)
+	}
+	if err := RunIndex([]string{input}, freshFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	updated, _ := loadIndexData(indexFile)
+	fresh, _ := loadIndexData(freshFile)
+	if !reflect.DeepEqual(sortedPostings(updated.Index), sortedPostings(fresh.Index)) {
+		t.Error("Updated overlapping index differs from a fresh build")
+	}
+	if updated.Documents[0].Digest != fresh.Documents[0].Digest {
+		t.Error("Updated digest differs from a fresh build")
+	}
+}
+
+// TestFixedChunker verifies that fixed chunks are always full, whatever sizes the reader returns.
+func TestFixedChunker(t *testing.T) {
+	data := randomText(1000, 8)
+	readers := map[string]func() io.Reader{
+		"Whole":    func() io.Reader { return bytes.NewReader(data) },
+		"One byte": func() io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) },
+		"Halves":   func() io.Reader { return iotest.HalfReader(bytes.NewReader(data)) },
+	}
+	for name, reader := range readers {
+		t.Run(name, func(t *testing.T) {
+			c := chunkSpec{Method: ChunkFixed, Size: 64}.newChunker(reader())
+			offset := 0
+			for {
+				chunk, n, err := c.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("next() failed: %v", err)
+				}
+				if want := min(64, len(data)-offset); len(chunk) != want || n != want {
+					t.Fatalf("Chunk at offset %d has length %d, want %d", offset, len(chunk), want)
+				}
+				if !bytes.Equal(chunk, data[offset:offset+len(chunk)]) {
+					t.Fatalf("Chunk at offset %d does not match the input", offset)
+				}
+				offset += len(chunk)
+			}
+			if offset != len(data) {
+				t.Errorf("Chunks cover %d bytes, want %d", offset, len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package internals
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandInputs resolves the input arguments of the index command into a list of files.
+// Each argument may be a file path, a glob pattern, or a directory; directories are walked
+// recursively and every non-empty .txt file beneath them is included. Files are returned
+// in the order they were given, with directory contents in lexical order and duplicates removed.
+//
+// Parameters:
+//   - inputs: The file paths, glob patterns and directories to expand.
+//
+// Returns:
+//   - []string: The files to index.
+//   - error: An error if a pattern is malformed, matches nothing, or a directory cannot be walked.
+func ExpandInputs(inputs []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, input := range inputs {
+		paths := []string{input}
+		if strings.ContainsAny(input, "*?[") {
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %v", input, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", input)
+			}
+			paths = matches
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || !info.IsDir() {
+				// Leave missing or regular files to ValidateInputFile
+				add(path)
+				continue
+			}
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() || strings.ToLower(filepath.Ext(p)) != ".txt" {
+					return nil
+				}
+				if info, err := d.Info(); err == nil && info.Size() > 0 {
+					add(p)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error walking directory %s: %v", path, err)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no input files found")
+	}
+	return files, nil
+}
+
+// The ways lookup and fuzzy can react to a document that changed after it was indexed.
+const (
+	StaleWarn   = "warn"
+	StaleFail   = "fail"
+	StaleIgnore = "ignore"
+)
+
+// documentSet gives read access to the documents of an index, opening each file
+// the first time one of its chunks is read.
+type documentSet struct {
+	docs  []Document
+	files map[int]*os.File
+}
+
+// openDocuments verifies that every document in the table still exists and still matches
+// the fingerprint recorded when it was indexed, then returns a documentSet for reading chunks
+// from them. A changed document is reported as a warning or an error depending on stale.
+func openDocuments(docs []Document, stale string) (*documentSet, error) {
+	switch stale {
+	case StaleWarn, StaleFail, StaleIgnore:
+	default:
+		return nil, fmt.Errorf("invalid stale policy %q, must be %q, %q or %q", stale, StaleWarn, StaleFail, StaleIgnore)
+	}
+
+	for _, doc := range docs {
+		if _, err := os.Stat(doc.Path); os.IsNotExist(err) {
+			return nil, fmt.Errorf("original file %s not found", doc.Path)
+		}
+		if stale == StaleIgnore {
+			continue
+		}
+		changed, err := documentChanged(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error checking original file %s: %v", doc.Path, err)
+		}
+		if !changed {
+			continue
+		}
+		if stale == StaleFail {
+			return nil, fmt.Errorf("original file %s has changed since it was indexed", doc.Path)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s has changed since it was indexed; results may point at the wrong text\n", doc.Path)
+	}
+	return &documentSet{docs: docs, files: make(map[int]*os.File)}, nil
+}
+
+// documentChanged reports whether a document no longer matches its recorded fingerprint.
+// The file is only re-hashed when its size or modification time differ from the recorded
+// ones. Documents indexed before fingerprints were recorded are assumed to be unchanged.
+func documentChanged(doc Document) (bool, error) {
+	if doc.Digest == "" {
+		return false, nil
+	}
+	info, err := os.Stat(doc.Path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != doc.Size {
+		return true, nil
+	}
+	if info.ModTime().Equal(doc.ModTime) {
+		return false, nil
+	}
+	digest, err := fileDigest(doc.Path)
+	if err != nil {
+		return false, err
+	}
+	return digest != doc.Digest, nil
+}
+
+// fileDigest returns the hex-encoded SHA-256 digest of a file's contents.
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// path returns the path of the document a posting refers to.
+func (ds *documentSet) path(p Posting) string {
+	return documentPath(ds.docs, p.DocID)
+}
+
+// readChunk reads the chunk of a posting from its document. The recorded length of the chunk
+// is used when there is one; otherwise up to size bytes are read.
+func (ds *documentSet) readChunk(p Posting, size int) ([]byte, error) {
+	if p.Length > 0 {
+		size = p.Length
+	}
+	if p.DocID < 0 || p.DocID >= len(ds.docs) {
+		return nil, fmt.Errorf("posting refers to unknown document %d", p.DocID)
+	}
+	file, ok := ds.files[p.DocID]
+	if !ok {
+		var err error
+		file, err = os.Open(ds.docs[p.DocID].Path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening original file: %v", err)
+		}
+		ds.files[p.DocID] = file
+	}
+
+	chunk := make([]byte, size)
+	n, err := file.ReadAt(chunk, p.Offset)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading chunk at offset %d: %v", p.Offset, err)
+	}
+	return chunk[:n], nil
+}
+
+// Close closes every document file that was opened.
+func (ds *documentSet) Close() {
+	for _, file := range ds.files {
+		file.Close()
+	}
+}
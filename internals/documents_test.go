@@ -0,0 +1,86 @@
+package internals
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestExpandInputs verifies that files, globs and directories expand to the expected file list.
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		return path
+	}
+	a := write("a.txt", "alpha")
+	b := write("b.txt", "beta")
+	write("notes.csv", "not text")
+	nested := write("sub/deeper/c.txt", "gamma")
+	write("sub/empty.txt", "")
+
+	tests := []struct {
+		name    string
+		inputs  []string
+		want    []string
+		wantErr bool
+	}{
+		{"Single file", []string{a}, []string{a}, false},
+		{"Glob", []string{filepath.Join(dir, "*.txt")}, []string{a, b}, false},
+		{"Directory is recursive", []string{dir}, []string{a, b, nested}, false},
+		{"Duplicates removed", []string{b, dir}, []string{b, a, nested}, false},
+		{"Missing file left for validation", []string{filepath.Join(dir, "missing.txt")}, []string{filepath.Join(dir, "missing.txt")}, false},
+		{"Glob without matches", []string{filepath.Join(dir, "*.md")}, nil, true},
+		{"No inputs", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandInputs(tt.inputs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandInputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBuildIndex_MultipleDocuments verifies that postings record the document each chunk came from.
+func TestBuildIndex_MultipleDocuments(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	if err := os.WriteFile(first, []byte("shared text"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(second, []byte("shared text"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fi := NewFileIndex(64, 2)
+	for _, path := range []string{first, second} {
+		if err := fi.BuildIndex(context.Background(), path); err != nil {
+			t.Fatalf("BuildIndex(%s) failed: %v", path, err)
+		}
+	}
+
+	if len(fi.documents) != 2 || fi.documents[0].Path != first || fi.documents[1].Path != second {
+		t.Fatalf("Unexpected document table: %+v", fi.documents)
+	}
+	postings := fi.index.Lookup(Fingerprint{computeSimHash([]byte("shared text"), fnv.New64a())})
+	want := []Posting{{DocID: 0, Offset: 0, Length: 11}, {DocID: 1, Offset: 0, Length: 11}}
+	if !reflect.DeepEqual(postings, want) {
+		t.Errorf("Lookup() = %+v, want %+v", postings, want)
+	}
+}
@@ -0,0 +1,216 @@
+package internals
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The feature extractors that turn chunk text into SimHash features, by the names the index
+// command accepts for them.
+const (
+	FeaturesWords = "words"
+	FeaturesChars = "chars"
+)
+
+// tokenizerChars is the tokenizer recorded in the header of indexes built from character
+// k-grams. Word shingles are recorded as tokenizerFields, the tokenizer of every earlier index.
+const tokenizerChars = "chars"
+
+// defaultCharShingle is the k-gram size used when chars features are chosen without a size.
+const defaultCharShingle = 3
+
+// featureSpec describes how the text of a chunk is turned into SimHash features. With the
+// tokenizerFields tokenizer, every run of Min to Max consecutive whitespace-separated words is
+// a feature; with tokenizerChars, every run of Min to Max consecutive characters is one, after
+// whitespace has been collapsed to single spaces. Single words are features of their own
+// (Min = Max = 1), as in indexes built before shingles were configurable. The text is passed
+// through the Normalize steps before it is split, and the words through the Words filter after.
+type featureSpec struct {
+	Tokenizer string
+	Min       int
+	Max       int
+	Normalize normalization
+	Words     wordFilter
+}
+
+// defaultFeatures are the features of an index built without shingle options: single words.
+var defaultFeatures = featureSpec{Tokenizer: tokenizerFields, Min: 1, Max: 1}
+
+// parseFeatures parses a feature setting of the form "words", "words:N", "words:N-M", "chars",
+// "chars:K" or "chars:K-L", where the numbers are the range of shingle sizes. An empty setting
+// means single words.
+//
+// Parameters:
+//   - s: The feature setting.
+//
+// Returns:
+//   - featureSpec: The parsed features.
+//   - error: An error if the setting names an unknown extractor or an invalid size range.
+func parseFeatures(s string) (featureSpec, error) {
+	if s == "" {
+		return defaultFeatures, nil
+	}
+	name, sizes, hasSizes := strings.Cut(s, ":")
+
+	var spec featureSpec
+	switch name {
+	case FeaturesWords:
+		spec = featureSpec{Tokenizer: tokenizerFields, Min: 1, Max: 1}
+	case FeaturesChars:
+		spec = featureSpec{Tokenizer: tokenizerChars, Min: defaultCharShingle, Max: defaultCharShingle}
+	default:
+		return spec, fmt.Errorf("unknown features %q, must be %q or %q", name, FeaturesWords, FeaturesChars)
+	}
+	if !hasSizes {
+		return spec, nil
+	}
+
+	low, high, isRange := strings.Cut(sizes, "-")
+	if !isRange {
+		high = low
+	}
+	var err error
+	if spec.Min, err = strconv.Atoi(low); err != nil {
+		return spec, fmt.Errorf("invalid shingle size %q: %v", sizes, err)
+	}
+	if spec.Max, err = strconv.Atoi(high); err != nil {
+		return spec, fmt.Errorf("invalid shingle size %q: %v", sizes, err)
+	}
+	return spec, spec.validate()
+}
+
+// featureSpec parses the feature, normalization and word filter settings of opts.
+func (opts IndexOptions) featureSpec() (featureSpec, error) {
+	spec, err := parseFeatures(opts.Features)
+	if err != nil {
+		return spec, err
+	}
+	if spec.Normalize, err = parseNormalization(opts.Normalize); err != nil {
+		return spec, err
+	}
+	spec.Words, err = parseWordFilter(opts.StopWords, opts.StopWordsFile, opts.Stemmer)
+	return spec, err
+}
+
+// validate returns an error if the spec cannot be used to extract features.
+func (f featureSpec) validate() error {
+	if f.Tokenizer != tokenizerFields && f.Tokenizer != tokenizerChars {
+		return fmt.Errorf("unsupported tokenizer %q", f.Tokenizer)
+	}
+	if f.Min < 1 || f.Max < f.Min {
+		return fmt.Errorf("invalid shingle sizes %d-%d, need 1 <= min <= max", f.Min, f.Max)
+	}
+	return f.Words.validate()
+}
+
+// String formats the tokenizer and shingle sizes of the spec the way parseFeatures accepts them,
+// followed by the normalization steps and word filters, if any.
+func (f featureSpec) String() string {
+	if f.Normalize != 0 || f.Words != (wordFilter{}) {
+		s := featureSpec{Tokenizer: f.Tokenizer, Min: f.Min, Max: f.Max}.String()
+		for _, part := range []string{f.Normalize.String(), f.Words.String()} {
+			if part != "" {
+				s += "+" + part
+			}
+		}
+		return s
+	}
+	name := FeaturesWords
+	if f.Tokenizer == tokenizerChars {
+		name = FeaturesChars
+	}
+	if f.Min == f.Max {
+		return fmt.Sprintf("%s:%d", name, f.Min)
+	}
+	return fmt.Sprintf("%s:%d-%d", name, f.Min, f.Max)
+}
+
+// featureSpec returns the features an index was built with. Indexes written before shingles
+// were configurable have no shingle sizes and used single words, and indexes written before
+// normalization and word filters were configurable hashed every word as it is. Unknown
+// normalization steps are rejected by checkHashing when the header is read.
+func (h IndexHeader) featureSpec() featureSpec {
+	normalize, _ := parseNormalization(h.Normalization)
+	spec := featureSpec{Tokenizer: h.Tokenizer, Min: h.ShingleMin, Max: h.ShingleMax, Normalize: normalize}
+	spec.Words = wordFilter{StopWords: h.StopWords, CustomStopWords: strings.Join(h.CustomStopWords, "\n"), Stemmer: h.Stemmer}
+	if spec.Tokenizer == "" {
+		spec.Tokenizer = tokenizerFields
+	}
+	if spec.Min == 0 && spec.Max == 0 {
+		spec.Min, spec.Max = 1, 1
+	}
+	return spec
+}
+
+// featureSpec returns the features fi extracts from each chunk, which are single words unless
+// set otherwise.
+func (fi *FileIndex) featureSpec() featureSpec {
+	if fi.features.Tokenizer == "" {
+		return defaultFeatures
+	}
+	return fi.features
+}
+
+// setFeatures records spec in the header.
+func (h *IndexHeader) setFeatures(spec featureSpec) {
+	h.Tokenizer = spec.Tokenizer
+	h.ShingleMin, h.ShingleMax = spec.Min, spec.Max
+	h.Normalization = spec.Normalize.String()
+	h.StopWords, h.Stemmer, h.CustomStopWords = spec.Words.StopWords, spec.Words.Stemmer, nil
+	if spec.Words.CustomStopWords != "" {
+		h.CustomStopWords = strings.Split(spec.Words.CustomStopWords, "\n")
+	}
+}
+
+// counts returns how often each feature occurs in data.
+func (f featureSpec) counts(data []byte) map[string]int {
+	words := f.Words.apply(strings.Fields(f.Normalize.apply(string(data))))
+	counts := make(map[string]int)
+	if f.Tokenizer == tokenizerChars {
+		charShingles(strings.Join(words, " "), f.Min, f.Max, counts)
+	} else {
+		wordShingles(words, f.Min, f.Max, counts)
+	}
+	return counts
+}
+
+// wordShingles counts every run of lo to hi consecutive words. Text with fewer than lo words
+// is counted as a single feature, so that short chunks still have a fingerprint.
+func wordShingles(words []string, lo, hi int, counts map[string]int) {
+	if len(words) > 0 && len(words) < lo {
+		counts[strings.Join(words, " ")]++
+		return
+	}
+	for n := lo; n <= hi && n <= len(words); n++ {
+		for i := 0; i+n <= len(words); i++ {
+			if n == 1 {
+				counts[words[i]]++
+			} else {
+				counts[strings.Join(words[i:i+n], " ")]++
+			}
+		}
+	}
+}
+
+// charShingles counts every run of lo to hi consecutive characters of text. Text with fewer
+// than lo characters is counted as a single feature.
+func charShingles(text string, lo, hi int, counts map[string]int) {
+	// starts holds the byte offset of every character, followed by the length of text
+	starts := make([]int, 0, len(text)+1)
+	for i := range text {
+		starts = append(starts, i)
+	}
+	runes := len(starts)
+	starts = append(starts, len(text))
+
+	if runes > 0 && runes < lo {
+		counts[text]++
+		return
+	}
+	for k := lo; k <= hi && k <= runes; k++ {
+		for i := 0; i+k <= runes; i++ {
+			counts[text[starts[i]:starts[i+k]]]++
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package internals
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestParseFeatures checks the accepted feature settings and their defaults.
+func TestParseFeatures(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    featureSpec
+		wantErr bool
+	}{
+		{"", featureSpec{tokenizerFields, 1, 1, 0, wordFilter{}}, false},
+		{"words", featureSpec{tokenizerFields, 1, 1, 0, wordFilter{}}, false},
+		{"words:2", featureSpec{tokenizerFields, 2, 2, 0, wordFilter{}}, false},
+		{"words:1-3", featureSpec{tokenizerFields, 1, 3, 0, wordFilter{}}, false},
+		{"chars", featureSpec{tokenizerChars, 3, 3, 0, wordFilter{}}, false},
+		{"chars:2-4", featureSpec{tokenizerChars, 2, 4, 0, wordFilter{}}, false},
+		{"words:3-1", featureSpec{}, true},
+		{"words:0", featureSpec{}, true},
+		{"chars:x", featureSpec{}, true},
+		{"bytes", featureSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseFeatures(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFeatures(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseFeatures(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFeatureCounts checks the shingles extracted from a short text.
+func TestFeatureCounts(t *testing.T) {
+	tests := []struct {
+		name string
+		spec featureSpec
+		text string
+		want map[string]int
+	}{
+		{"Words", featureSpec{tokenizerFields, 1, 1, 0, wordFilter{}}, "a b a", map[string]int{"a": 2, "b": 1}},
+		{"Word pairs", featureSpec{tokenizerFields, 2, 2, 0, wordFilter{}}, "a b a b", map[string]int{"a b": 2, "b a": 1}},
+		{"Words and pairs", featureSpec{tokenizerFields, 1, 2, 0, wordFilter{}}, "x  y", map[string]int{"x": 1, "y": 1, "x y": 1}},
+		{"Too few words", featureSpec{tokenizerFields, 3, 3, 0, wordFilter{}}, "x y", map[string]int{"x y": 1}},
+		{"Characters", featureSpec{tokenizerChars, 2, 2, 0, wordFilter{}}, "ab\n c", map[string]int{"ab": 1, "b ": 1, " c": 1}},
+		{"Multibyte characters", featureSpec{tokenizerChars, 2, 2, 0, wordFilter{}}, "日本語", map[string]int{"日本": 1, "本語": 1}},
+		{"Empty", featureSpec{tokenizerChars, 2, 2, 0, wordFilter{}}, " ", map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.counts([]byte(tt.text)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("counts(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFeatureSpec_SimHash verifies that single words ignore word order while word shingles do
+// not, and that single-word features hash exactly like computeSimHash.
+func TestFeatureSpec_SimHash(t *testing.T) {
+	h := fnv.New64a()
+	a, b := []byte("the dog bites the man"), []byte("the man bites the dog")
+
+	if defaultFeatures.simHash(a, h) != computeSimHash(a, h) {
+		t.Error("Single-word features hash differently from computeSimHash")
+	}
+	if defaultFeatures.simHash(a, h) != defaultFeatures.simHash(b, h) {
+		t.Error("Expected single words to ignore word order")
+	}
+	pairs := featureSpec{tokenizerFields, 1, 2, 0, wordFilter{}}
+	if pairs.simHash(a, h) == pairs.simHash(b, h) {
+		t.Error("Expected word pairs to tell reordered words apart")
+	}
+}
+
+// TestRunIndex_Features verifies that query text is hashed with the recorded features, so that
+// character k-grams find a slightly changed text without spaces, which single words, seeing the
+// whole text as one word, do not.
+func TestRunIndex_Features(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	text := "吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかぬ。"
+	os.WriteFile(input, []byte(text), 0644)
+	changed := "吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかない。"
+
+	for _, tt := range []struct {
+		features string
+		spec     featureSpec
+		found    int
+	}{
+		{"chars:2-3", featureSpec{tokenizerChars, 2, 3, 0, wordFilter{}}, 1},
+		{"words", featureSpec{tokenizerFields, 1, 1, 0, wordFilter{}}, 0},
+	} {
+		indexFile := filepath.Join(dir, tt.features+".idx")
+		if err := RunIndex([]string{input}, indexFile, IndexOptions{ChunkSize: 4096, Features: tt.features}); err != nil {
+			t.Fatalf("RunIndex() failed: %v", err)
+		}
+		query, err := ResolveQuery(indexFile, "", changed, "")
+		if err != nil {
+			t.Fatalf("ResolveQuery() failed: %v", err)
+		}
+		if want := tt.spec.simHash([]byte(changed), fnv.New64a()); query != strconv.FormatUint(want, 16) {
+			t.Errorf("ResolveQuery() = %s, want %x", query, want)
+		}
+		results, err := Fuzzy(indexFile, query, 12, 0, QueryOptions{})
+		if err != nil {
+			t.Fatalf("Fuzzy() failed: %v", err)
+		}
+		if len(results) != tt.found || tt.found == 1 && (results[0].Offset != 0 || results[0].Length != len(text)) {
+			t.Errorf("Fuzzy() with %s features = %+v, want %d matches of the whole text", tt.features, results, tt.found)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package internals
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The widths a SimHash fingerprint can have. Wider fingerprints tell more chunks apart: with 64
+// bits, unrelated chunks of a corpus of a hundred million chunks collide within a few bits of
+// each other far more often than with 128 or 256.
+const (
+	FingerprintBits64  = 64
+	FingerprintBits128 = 128
+	FingerprintBits256 = 256
+)
+
+// Fingerprint is a SimHash of up to 256 bits. Bit i of the fingerprint is bit i%64 of word i/64,
+// and the words past the width of the index are zero, so the fingerprint of a 64-bit index is
+// Fingerprint{simHash}. Its first word is always the 64-bit SimHash of the same features, which
+// the wider fingerprints extend.
+//
+// Fingerprints are printed and parsed as hexadecimal numbers without leading zeros, so a 64-bit
+// fingerprint looks exactly like the uint64 SimHashes of indexes built before widths were
+// configurable.
+type Fingerprint [4]uint64
+
+// parseFingerprintBits validates a fingerprint width, mapping 0 to FingerprintBits64.
+func parseFingerprintBits(width int) (int, error) {
+	switch width {
+	case 0, FingerprintBits64:
+		return FingerprintBits64, nil
+	case FingerprintBits128, FingerprintBits256:
+		return width, nil
+	}
+	return 0, fmt.Errorf("unsupported fingerprint width %d, must be %d, %d or %d bits",
+		width, FingerprintBits64, FingerprintBits128, FingerprintBits256)
+}
+
+// parseFingerprint parses a fingerprint written in hexadecimal, as printed by String.
+//
+// Parameters:
+//   - s: The hexadecimal fingerprint, with or without leading zeros.
+//   - width: The width of the fingerprints it is compared with, in bits.
+//
+// Returns:
+//   - Fingerprint: The parsed fingerprint.
+//   - error: An error if s is empty, is not hexadecimal, or does not fit in width bits.
+func parseFingerprint(s string, width int) (Fingerprint, error) {
+	var f Fingerprint
+	if s == "" {
+		return f, fmt.Errorf("empty fingerprint")
+	}
+	digits := strings.TrimLeft(s, "0")
+	if len(digits) > width/4 {
+		return f, fmt.Errorf("fingerprint %q does not fit in %d bits", s, width)
+	}
+	for word := 0; digits != ""; word++ {
+		start := max(len(digits)-16, 0)
+		v, err := strconv.ParseUint(digits[start:], 16, 64)
+		if err != nil {
+			return f, fmt.Errorf("fingerprint %q is not hexadecimal", s)
+		}
+		f[word] = v
+		digits = digits[:start]
+	}
+	return f, nil
+}
+
+// String returns the fingerprint in hexadecimal, without leading zeros.
+func (f Fingerprint) String() string {
+	top := len(f) - 1
+	for top > 0 && f[top] == 0 {
+		top--
+	}
+	var b strings.Builder
+	b.WriteString(strconv.FormatUint(f[top], 16))
+	for i := top - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%016x", f[i])
+	}
+	return b.String()
+}
+
+// Format prints the fingerprint in hexadecimal for the %x, %s and %v verbs, so that it is
+// printed like a uint64 SimHash.
+func (f Fingerprint) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'X':
+		fmt.Fprint(s, strings.ToUpper(f.String()))
+	default:
+		fmt.Fprint(s, f.String())
+	}
+}
+
+// less reports whether f is smaller than g, comparing them as numbers.
+func (f Fingerprint) less(g Fingerprint) bool {
+	for i := len(f) - 1; i >= 0; i-- {
+		if f[i] != g[i] {
+			return f[i] < g[i]
+		}
+	}
+	return false
+}
+
+// distance returns the Hamming distance between two fingerprints.
+func (f Fingerprint) distance(g Fingerprint) int {
+	distance := 0
+	for i := range f {
+		distance += hammingdistance(f[i], g[i])
+	}
+	return distance
+}
+
+// bitsAt returns the width bits of the fingerprint starting at bit start, where width is at most 64.
+func (f Fingerprint) bitsAt(start, width int) uint64 {
+	word, shift := start/64, uint(start%64)
+	v := f[word] >> shift
+	if shift != 0 && word+1 < len(f) {
+		v |= f[word+1] << (64 - shift)
+	}
+	if width < 64 {
+		v &= 1<<uint(width) - 1
+	}
+	return v
+}
+
+// appendFingerprint appends the first width bits of f to b, as little-endian words.
+func appendFingerprint(b []byte, f Fingerprint, width int) []byte {
+	for i := 0; i < width/64; i++ {
+		b = binary.LittleEndian.AppendUint64(b, f[i])
+	}
+	return b
+}
+
+// readFingerprint decodes a fingerprint of width bits written by appendFingerprint.
+func readFingerprint(b []byte, width int) Fingerprint {
+	var f Fingerprint
+	for i := 0; i < width/64; i++ {
+		f[i] = binary.LittleEndian.Uint64(b[i*8:])
+	}
+	return f
+}
+
+// featureWord returns word i of the bits a feature with the given 64-bit hash votes for. Word 0
+// is the hash itself, so the first 64 bits of a wide fingerprint are the 64-bit SimHash; the
+// other words are derived from it with the SplitMix64 finalizer, whose outputs for consecutive
+// inputs are independent enough to serve as further random hyperplanes.
+func featureWord(hash uint64, i int) uint64 {
+	if i == 0 {
+		return hash
+	}
+	z := hash + uint64(i)*0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// fingerprintBits returns the width of the fingerprints of an index, which is 64 bits in
+// indexes written before widths were configurable.
+func (h IndexHeader) fingerprintBits() int {
+	if h.FingerprintBits == 0 {
+		return FingerprintBits64
+	}
+	return h.FingerprintBits
+}
+
+// fingerprintBits returns the width of the fingerprints the index is built with.
+func (fi *FileIndex) fingerprintBits() int {
+	if fi.bits == 0 {
+		return FingerprintBits64
+	}
+	return fi.bits
+}
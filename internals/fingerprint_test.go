@@ -0,0 +1,145 @@
+package internals
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestParseFingerprint checks that fingerprints print and parse as hexadecimal numbers of the
+// index's width.
+func TestParseFingerprint(t *testing.T) {
+	tests := []struct {
+		in      string
+		width   int
+		want    Fingerprint
+		wantErr bool
+	}{
+		{"0", 64, Fingerprint{}, false},
+		{"abc", 64, Fingerprint{0xabc}, false},
+		{"ffffffffffffffff", 64, Fingerprint{^uint64(0)}, false},
+		{"0000abc", 64, Fingerprint{0xabc}, false},
+		{"10000000000000000", 64, Fingerprint{}, true},
+		{"10000000000000000", 128, Fingerprint{0, 1}, false},
+		{"123456789abcdef0fedcba9876543210", 128, Fingerprint{0xfedcba9876543210, 0x123456789abcdef0}, false},
+		{"1" + strings.Repeat("0", 63), 256, Fingerprint{0, 0, 0, 1 << 60}, false},
+		{"1" + strings.Repeat("0", 64), 256, Fingerprint{}, true},
+		{"xyz", 64, Fingerprint{}, true},
+		{"", 64, Fingerprint{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.in, tt.width), func(t *testing.T) {
+			got, err := parseFingerprint(tt.in, tt.width)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFingerprint(%q, %d) error = %v, wantErr %v", tt.in, tt.width, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseFingerprint(%q, %d) = %v, want %v", tt.in, tt.width, got, tt.want)
+			}
+			if want := strings.TrimLeft(tt.in, "0"); got.String() != want && !(want == "" && got.String() == "0") {
+				t.Errorf("String() = %s, want %s", got, want)
+			}
+		})
+	}
+
+	// 64-bit fingerprints print exactly like the uint64 SimHashes of older indexes
+	for _, v := range []uint64{0, 0x1, 0xabcdef1234567890} {
+		if got, want := fmt.Sprintf("%x", Fingerprint{v}), strconv.FormatUint(v, 16); got != want {
+			t.Errorf("Sprintf(%%x) = %s, want %s", got, want)
+		}
+	}
+}
+
+// TestFingerprint_Distance checks the Hamming distance and ordering of wide fingerprints.
+func TestFingerprint_Distance(t *testing.T) {
+	a := Fingerprint{0x1, 0x3, 0, 1 << 63}
+	b := Fingerprint{0x0, 0x1, 0xf, 0}
+	if got := a.distance(b); got != 7 {
+		t.Errorf("distance() = %d, want 7", got)
+	}
+	if !b.less(a) || a.less(b) || a.less(a) {
+		t.Error("Expected fingerprints to be ordered by their most significant word first")
+	}
+	if got := (Fingerprint{1 << 60, 0xab}).bitsAt(60, 16); got != 0xab1 {
+		t.Errorf("bitsAt() across words = %x, want ab1", got)
+	}
+}
+
+// TestFeatureSpec_Fingerprint verifies that wide fingerprints extend the 64-bit SimHash, and that
+// their other words are filled in.
+func TestFeatureSpec_Fingerprint(t *testing.T) {
+	h := fnv.New64a()
+	text := []byte("the quick brown fox jumps over the lazy dog")
+	simHash := defaultFeatures.simHash(text, h)
+	for _, width := range []int{FingerprintBits64, FingerprintBits128, FingerprintBits256} {
+		got := defaultFeatures.fingerprint(text, h, nil, width)
+		if got[0] != simHash {
+			t.Errorf("%d-bit fingerprint starts with %x, want %x", width, got[0], simHash)
+		}
+		for w := 1; w < len(got); w++ {
+			if (w < width/64) != (got[w] != 0) {
+				t.Errorf("%d-bit fingerprint has word %d = %x", width, w, got[w])
+			}
+		}
+	}
+}
+
+// TestRunIndex_FingerprintBits verifies that wide fingerprints are used by both layouts, query
+// text, lookups and fuzzy search, and that indexes of different widths are not mixed.
+func TestRunIndex_FingerprintBits(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	text := "the quick brown fox jumps over the lazy dog"
+	os.WriteFile(input, []byte(text), 0644)
+
+	for _, layout := range []string{layoutGob, layoutSorted} {
+		indexFile := filepath.Join(dir, layout+".idx")
+		opts := IndexOptions{ChunkSize: 4096, FingerprintBits: FingerprintBits256, Layout: layout}
+		if err := RunIndex([]string{input}, indexFile, opts); err != nil {
+			t.Fatalf("RunIndex() failed: %v", err)
+		}
+		query, err := ResolveQuery(indexFile, "", text, "")
+		if err != nil {
+			t.Fatalf("ResolveQuery() failed: %v", err)
+		}
+		want := defaultFeatures.fingerprint([]byte(text), fnv.New64a(), nil, FingerprintBits256)
+		if query != want.String() {
+			t.Errorf("ResolveQuery() = %s, want %s", query, want)
+		}
+		if results, err := Lookup(indexFile, query, QueryOptions{}); err != nil || len(results) != 1 || results[0].SimHash != want {
+			t.Errorf("Lookup() = %+v, %v, want the chunk indexed under %s", results, err, want)
+		}
+
+		// A query differing in bits past the first 64 is only near within the full distance
+		far := want
+		far[3] = ^far[3]
+		if results, err := Fuzzy(indexFile, far.String(), 63, 0, QueryOptions{}); err != nil || len(results) != 0 {
+			t.Errorf("Fuzzy() within 63 bits = %+v, %v, want no match", results, err)
+		}
+		if results, err := Fuzzy(indexFile, far.String(), 64, 0, QueryOptions{}); err != nil || len(results) != 1 || results[0].Distance != 64 {
+			t.Errorf("Fuzzy() within 64 bits = %+v, %v, want one match at distance 64", results, err)
+		}
+		if _, err := Fuzzy(indexFile, far.String(), 257, 0, QueryOptions{}); err == nil {
+			t.Error("Expected Fuzzy() to reject a distance wider than the fingerprints, got nil")
+		}
+	}
+
+	narrow := filepath.Join(dir, "narrow.idx")
+	if err := RunIndex([]string{input}, narrow, IndexOptions{ChunkSize: 4096}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunMerge(io.Discard, filepath.Join(dir, "merged.idx"), []string{filepath.Join(dir, "gob.idx"), narrow}); err == nil {
+		t.Error("Expected RunMerge() to reject indexes with different fingerprint widths, got nil")
+	}
+	if _, err := Lookup(narrow, strings.Repeat("f", 32), QueryOptions{}); err == nil {
+		t.Error("Expected Lookup() to reject a SimHash wider than the index, got nil")
+	}
+}
@@ -0,0 +1,416 @@
+package internals
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math/bits"
+)
+
+// The feature hash functions an index can be built with, by the names the index command accepts
+// for them. Every one is implemented here, on strings, so that hashing a feature does not allocate.
+const (
+	HashFNV1a    = "fnv1a"
+	HashXXHash64 = "xxhash64"
+	HashMurmur3  = "murmur3"
+	HashSipHash  = "siphash"
+)
+
+// The hash families recorded in index headers, which name the exact variant of each function.
+const (
+	hashFamilyXXHash64 = "xxhash64"
+	hashFamilyMurmur3  = "murmur3-x64-128"
+	hashFamilySipHash  = "siphash-2-4"
+)
+
+// sipHashKeySize is the size in bytes of a SipHash key.
+const sipHashKeySize = 16
+
+// hashFamilies maps the names the index command accepts to the families recorded in the header.
+var hashFamilies = map[string]string{
+	HashFNV1a:    hashFamilyFNV1a,
+	HashXXHash64: hashFamilyXXHash64,
+	HashMurmur3:  hashFamilyMurmur3,
+	HashSipHash:  hashFamilySipHash,
+}
+
+// hashSpec identifies the function that hashes each feature: its family as recorded in the
+// header and, for SipHash, its 16-byte key. The zero value is FNV-1a, the hash of every index
+// built before the hash was configurable.
+type hashSpec struct {
+	Family string
+	Key    string
+}
+
+// parseHash builds a hash spec from the settings of the index command.
+//
+// Parameters:
+//   - name: HashFNV1a, HashXXHash64, HashMurmur3 or HashSipHash, or a family recorded in a
+//     header; FNV-1a when empty.
+//   - keyHex: For SipHash, the key as 32 hexadecimal digits; a random key is generated when empty.
+//     It must be empty for the other hashes, which are not keyed.
+//
+// Returns:
+//   - hashSpec: The parsed spec.
+//   - error: An error if the hash is unknown or the key is invalid.
+func parseHash(name, keyHex string) (hashSpec, error) {
+	family, err := hashFamily(name)
+	if err != nil {
+		return hashSpec{}, err
+	}
+
+	spec := hashSpec{Family: family}
+	if family != hashFamilySipHash {
+		if keyHex != "" {
+			return spec, fmt.Errorf("hash %q does not take a key", name)
+		}
+		return spec, nil
+	}
+	key := make([]byte, sipHashKeySize)
+	if keyHex == "" {
+		if _, err := rand.Read(key); err != nil {
+			return spec, fmt.Errorf("error generating hash key: %v", err)
+		}
+	} else if decoded, err := hex.DecodeString(keyHex); err != nil || len(decoded) != sipHashKeySize {
+		return spec, fmt.Errorf("invalid hash key %q, must be %d hexadecimal digits", keyHex, 2*sipHashKeySize)
+	} else {
+		key = decoded
+	}
+	spec.Key = string(key)
+	return spec, nil
+}
+
+// hashFamily returns the family recorded in the header for a hash name, which may also be the
+// family itself. FNV-1a is the default when name is empty.
+func hashFamily(name string) (string, error) {
+	if name == "" {
+		name = HashFNV1a
+	}
+	if family, ok := hashFamilies[name]; ok {
+		return family, nil
+	}
+	for _, family := range hashFamilies {
+		if family == name {
+			return family, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash %q, must be %q, %q, %q or %q", name, HashFNV1a, HashXXHash64, HashMurmur3, HashSipHash)
+}
+
+// validate returns an error if the spec names an unknown family or has a key of the wrong size.
+func (s hashSpec) validate() error {
+	switch s.family() {
+	case hashFamilyFNV1a, hashFamilyXXHash64, hashFamilyMurmur3:
+		if s.Key != "" {
+			return fmt.Errorf("hash %s does not take a key", s.Family)
+		}
+	case hashFamilySipHash:
+		if len(s.Key) != sipHashKeySize {
+			return fmt.Errorf("hash %s needs a %d-byte key, got %d bytes", s.Family, sipHashKeySize, len(s.Key))
+		}
+	default:
+		return fmt.Errorf("unsupported hash %q", s.Family)
+	}
+	return nil
+}
+
+// family returns the hash family, which is FNV-1a when it is not set.
+func (s hashSpec) family() string {
+	if s.Family == "" {
+		return hashFamilyFNV1a
+	}
+	return s.Family
+}
+
+// new returns a hash.Hash64 computing the spec's hash. It is not safe for concurrent use, so each
+// goroutine needs its own. featureHash hashes features with it without allocating.
+func (s hashSpec) new() hash.Hash64 {
+	switch s.family() {
+	case hashFamilyXXHash64:
+		return &stringHash{sum: xxHash64}
+	case hashFamilyMurmur3:
+		return &stringHash{sum: murmur3}
+	case hashFamilySipHash:
+		k0 := binary.LittleEndian.Uint64([]byte(s.Key[:8]))
+		k1 := binary.LittleEndian.Uint64([]byte(s.Key[8:]))
+		return &stringHash{sum: func(data string) uint64 { return sipHash24(k0, k1, data) }}
+	}
+	return &stringHash{sum: fnv1a64}
+}
+
+// hashSpec returns the feature hash an index was built with.
+func (h IndexHeader) hashSpec() hashSpec {
+	return hashSpec{Family: h.HashFamily, Key: string(h.HashKey)}
+}
+
+// setHash records spec in the header.
+func (h *IndexHeader) setHash(spec hashSpec) {
+	h.HashFamily, h.HashKey = spec.family(), nil
+	if spec.Key != "" {
+		h.HashKey = []byte(spec.Key)
+	}
+}
+
+// checkQueryHash returns an error if a SimHash computed with the named feature hash cannot be
+// compared with the fingerprints of an index with header h. An empty name accepts every index.
+func (h IndexHeader) checkQueryHash(name string) error {
+	if name == "" {
+		return nil
+	}
+	family, err := hashFamily(name)
+	if err != nil {
+		return err
+	}
+	if built := h.hashSpec().family(); built != family {
+		return fmt.Errorf("query was hashed with %s, but the index was built with %s", family, built)
+	}
+	return nil
+}
+
+// stringHasher is implemented by hashes that can hash a string without converting it to a byte slice.
+type stringHasher interface {
+	hashString(s string) uint64
+}
+
+// featureHash returns the hash of a feature, which decides the bits it votes for. Hashes created by
+// hashSpec.new hash the feature in place; any other hash.Hash64 is reset and written to.
+func featureHash(h hash.Hash64, feature string) uint64 {
+	if sh, ok := h.(stringHasher); ok {
+		return sh.hashString(feature)
+	}
+	h.Reset()
+	h.Write([]byte(feature))
+	return h.Sum64()
+}
+
+// stringHash adapts a function hashing a whole string to hash.Hash64, buffering everything
+// written until Sum64 is called.
+type stringHash struct {
+	buf []byte
+	sum func(string) uint64
+}
+
+func (s *stringHash) hashString(data string) uint64 { return s.sum(data) }
+func (s *stringHash) Write(p []byte) (int, error)   { s.buf = append(s.buf, p...); return len(p), nil }
+func (s *stringHash) Sum64() uint64                 { return s.sum(string(s.buf)) }
+func (s *stringHash) Reset()                        { s.buf = s.buf[:0] }
+func (s *stringHash) Size() int                     { return 8 }
+func (s *stringHash) BlockSize() int                { return 1 }
+
+func (s *stringHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, s.Sum64())
+}
+
+// fnv1a64 returns the 64-bit FNV-1a hash of data, as computed by hash/fnv.New64a.
+func fnv1a64(data string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(data); i++ {
+		h ^= uint64(data[i])
+		h *= prime
+	}
+	return h
+}
+
+// le64 and le32 read a little-endian integer from data at offset i.
+func le64(data string, i int) uint64 {
+	return uint64(data[i]) | uint64(data[i+1])<<8 | uint64(data[i+2])<<16 | uint64(data[i+3])<<24 |
+		uint64(data[i+4])<<32 | uint64(data[i+5])<<40 | uint64(data[i+6])<<48 | uint64(data[i+7])<<56
+}
+
+func le32(data string, i int) uint32 {
+	return uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+}
+
+// The xxHash64 primes.
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 returns the xxHash64 hash of data with seed 0.
+func xxHash64(data string) uint64 {
+	n := len(data)
+	i := 0
+	var h uint64
+	if n >= 32 {
+		v3 := uint64(0)
+		v1 := v3 + xxPrime1 + xxPrime2
+		v2 := v3 + xxPrime2
+		v4 := v3 - xxPrime1
+		for ; i+32 <= n; i += 32 {
+			v1 = xxRound(v1, le64(data, i))
+			v2 = xxRound(v2, le64(data, i+8))
+			v3 = xxRound(v3, le64(data, i+16))
+			v4 = xxRound(v4, le64(data, i+24))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; i+8 <= n; i += 8 {
+		h ^= xxRound(0, le64(data, i))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if i+4 <= n {
+		h ^= uint64(le32(data, i)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		i += 4
+	}
+	for ; i < n; i++ {
+		h ^= uint64(data[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// murmur3 returns the first 64 bits of the MurmurHash3 x64 128-bit hash of data with seed 0.
+func murmur3(data string) uint64 {
+	const (
+		c1 uint64 = 0x87c37b91114253d5
+		c2 uint64 = 0x4cf5ad432745937f
+	)
+	n := len(data)
+	var h1, h2 uint64
+	i := 0
+	for ; i+16 <= n; i += 16 {
+		k1, k2 := le64(data, i), le64(data, i+8)
+
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	var k1, k2 uint64
+	tail := data[i:]
+	for j := len(tail) - 1; j >= 8; j-- {
+		k2 ^= uint64(tail[j]) << (8 * (j - 8))
+	}
+	if len(tail) > 8 {
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+	}
+	for j := min(len(tail), 8) - 1; j >= 0; j-- {
+		k1 ^= uint64(tail[j]) << (8 * j)
+	}
+	if len(tail) > 0 {
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1 = murmurFmix(h1)
+	h2 = murmurFmix(h2)
+	h1 += h2
+	return h1
+}
+
+func murmurFmix(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// sipHash24 returns the SipHash-2-4 hash of data under the key k0, k1, the two little-endian
+// halves of the 16-byte key.
+func sipHash24(k0, k1 uint64, data string) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	n := len(data)
+	i := 0
+	for ; i+8 <= n; i += 8 {
+		m := le64(data, i)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+	last := uint64(n) << 56
+	for j := n - 1; j >= i; j-- {
+		last |= uint64(data[j]) << (8 * (j - i))
+	}
+	v3 ^= last
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= last
+
+	v2 ^= 0xff
+	for r := 0; r < 4; r++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
@@ -0,0 +1,208 @@
+package internals
+
+import (
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestFeatureHashes checks each hash function against published test vectors, and FNV-1a
+// against hash/fnv.
+func TestFeatureHashes(t *testing.T) {
+	sipKey := "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f"
+	sip := hashSpec{Family: hashFamilySipHash, Key: sipKey}.new()
+	tests := []struct {
+		name string
+		sum  func(string) uint64
+		in   string
+		want uint64
+	}{
+		{"xxHash64 empty", xxHash64, "", 0xef46db3751d8e999},
+		{"xxHash64 a", xxHash64, "a", 0xd24ec4f1a98c6e5b},
+		{"xxHash64 abc", xxHash64, "abc", 0x44bc2cf5ad770999},
+		{"xxHash64 long", xxHash64, "Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+		{"Murmur3 empty", murmur3, "", 0},
+		{"Murmur3 hello", murmur3, "hello", 0xcbd8a7b341bd9b02},
+		{"SipHash empty", featureHashFunc(sip), "", 0x726fdb47dd0e0e31},
+		{"SipHash 15 bytes", featureHashFunc(sip), "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e", 0xa129ca6149be45e5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sum(tt.in); got != tt.want {
+				t.Errorf("hash(%q) = %x, want %x", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	std := fnv.New64a()
+	for _, in := range []string{"", "a", "hello world", strings.Repeat("0123456789", 10)} {
+		if got, want := fnv1a64(in), featureHash(std, in); got != want {
+			t.Errorf("fnv1a64(%q) = %x, want %x", in, got, want)
+		}
+	}
+}
+
+// TestParseHash checks the accepted hash settings.
+func TestParseHash(t *testing.T) {
+	tests := []struct {
+		name, key string
+		want      string
+		wantErr   bool
+	}{
+		{"", "", hashFamilyFNV1a, false},
+		{"fnv1a", "", hashFamilyFNV1a, false},
+		{"xxhash64", "", hashFamilyXXHash64, false},
+		{"murmur3", "", hashFamilyMurmur3, false},
+		{"siphash", "", hashFamilySipHash, false},
+		{"siphash", "000102030405060708090a0b0c0d0e0f", hashFamilySipHash, false},
+		{"siphash-2-4", "000102030405060708090a0b0c0d0e0f", hashFamilySipHash, false},
+		{"siphash", "0001", "", true},
+		{"siphash", "zz0102030405060708090a0b0c0d0e0f", "", true},
+		{"xxhash64", "000102030405060708090a0b0c0d0e0f", "", true},
+		{"md5", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.key, func(t *testing.T) {
+			got, err := parseHash(tt.name, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHash(%q, %q) error = %v, wantErr %v", tt.name, tt.key, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Family != tt.want {
+				t.Errorf("parseHash(%q, %q) family = %s, want %s", tt.name, tt.key, got.Family, tt.want)
+			}
+			if err := got.validate(); err != nil {
+				t.Errorf("parseHash(%q, %q) returned an invalid spec: %v", tt.name, tt.key, err)
+			}
+		})
+	}
+
+	a, _ := parseHash(HashSipHash, "")
+	b, _ := parseHash(HashSipHash, "")
+	if a.Key == b.Key {
+		t.Error("Expected random SipHash keys to differ")
+	}
+}
+
+// TestRunIndex_Hash verifies that query text is hashed with the recorded hash and key, and that
+// mismatched hashes and keys are rejected.
+func TestRunIndex_Hash(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	text := "the quick brown fox jumps over the lazy dog"
+	os.WriteFile(input, []byte(text), 0644)
+
+	key := "000102030405060708090a0b0c0d0e0f"
+	indexFile := filepath.Join(dir, "sip.idx")
+	if err := RunIndex([]string{input}, indexFile, IndexOptions{ChunkSize: 4096, Hash: HashSipHash, HashKey: key}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	header, err := readIndexHeader(indexFile)
+	if err != nil {
+		t.Fatalf("readIndexHeader() failed: %v", err)
+	}
+	query, err := ResolveQuery(indexFile, "", text, "")
+	if err != nil {
+		t.Fatalf("ResolveQuery() failed: %v", err)
+	}
+	want := defaultFeatures.simHash([]byte(text), header.hashSpec().new())
+	if query != strconv.FormatUint(want, 16) {
+		t.Errorf("ResolveQuery() = %s, want %x", query, want)
+	}
+	if want == defaultFeatures.simHash([]byte(text), fnv.New64a()) {
+		t.Error("Expected SipHash and FNV-1a to give different fingerprints")
+	}
+	if _, err := Lookup(indexFile, query, QueryOptions{Hash: HashSipHash}); err != nil {
+		t.Errorf("Lookup() with the index's hash failed: %v", err)
+	}
+	if _, err := Lookup(indexFile, query, QueryOptions{Hash: HashFNV1a}); err == nil {
+		t.Error("Expected Lookup() to reject a SimHash computed with another hash, got nil")
+	}
+
+	other := filepath.Join(dir, "other.idx")
+	if err := RunIndex([]string{input}, other, IndexOptions{ChunkSize: 4096, Hash: HashSipHash}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunMerge(io.Discard, filepath.Join(dir, "merged.idx"), []string{indexFile, other}); err == nil {
+		t.Error("Expected RunMerge() to reject indexes with different SipHash keys, got nil")
+	}
+}
+
+// featureHashFunc returns featureHash bound to h.
+func featureHashFunc(h hash.Hash64) func(string) uint64 {
+	return func(s string) uint64 { return featureHash(h, s) }
+}
+
+// benchmarkFeature is a typical word trigram.
+var benchmarkFeature = "the quick brown"
+
+// BenchmarkFeatureHash_StdlibFNV measures hashing a feature through hash/fnv, which copies it
+// into a byte slice.
+func BenchmarkFeatureHash_StdlibFNV(b *testing.B) {
+	h := fnv.New64a()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		featureHash(h, benchmarkFeature)
+	}
+}
+
+// benchmarkFeatureHash measures hashing a feature with the named hash.
+func benchmarkFeatureHash(b *testing.B, name string) {
+	spec, err := parseHash(name, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := spec.new()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		featureHash(h, benchmarkFeature)
+	}
+}
+
+// BenchmarkFeatureHash_FNV1a measures hashing a feature with FNV-1a.
+func BenchmarkFeatureHash_FNV1a(b *testing.B) { benchmarkFeatureHash(b, HashFNV1a) }
+
+// BenchmarkFeatureHash_XXHash64 measures hashing a feature with xxHash64.
+func BenchmarkFeatureHash_XXHash64(b *testing.B) { benchmarkFeatureHash(b, HashXXHash64) }
+
+// BenchmarkFeatureHash_Murmur3 measures hashing a feature with MurmurHash3.
+func BenchmarkFeatureHash_Murmur3(b *testing.B) { benchmarkFeatureHash(b, HashMurmur3) }
+
+// BenchmarkFeatureHash_SipHash measures hashing a feature with SipHash-2-4.
+func BenchmarkFeatureHash_SipHash(b *testing.B) { benchmarkFeatureHash(b, HashSipHash) }
+
+// benchmarkSimHash measures computing the SimHash of a 4 KiB chunk of word trigrams with the
+// named hash.
+func benchmarkSimHash(b *testing.B, name string) {
+	spec, err := parseHash(name, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := spec.new()
+	chunk := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 4096/44))
+	features := featureSpec{tokenizerFields, 3, 3, 0, wordFilter{}}
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		features.simHash(chunk, h)
+	}
+}
+
+// BenchmarkSimHash_FNV1a measures SimHashes with FNV-1a features.
+func BenchmarkSimHash_FNV1a(b *testing.B) { benchmarkSimHash(b, HashFNV1a) }
+
+// BenchmarkSimHash_XXHash64 measures SimHashes with xxHash64 features.
+func BenchmarkSimHash_XXHash64(b *testing.B) { benchmarkSimHash(b, HashXXHash64) }
+
+// BenchmarkSimHash_Murmur3 measures SimHashes with MurmurHash3 features.
+func BenchmarkSimHash_Murmur3(b *testing.B) { benchmarkSimHash(b, HashMurmur3) }
+
+// BenchmarkSimHash_SipHash measures SimHashes with SipHash-2-4 features.
+func BenchmarkSimHash_SipHash(b *testing.B) { benchmarkSimHash(b, HashSipHash) }
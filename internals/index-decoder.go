@@ -5,47 +5,79 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 )
 
+// The orders in which IndexFileDecoder can list the index.
+const (
+	DumpByHash   = "hash"
+	DumpByOffset = "offset"
+)
+
 type entry struct {
-	simhash uint64
-	offsets []int64
+	simhash  Fingerprint
+	postings []Posting
 }
 
-// IndexFileDecoder processes the given IndexData and writes SimHash values and their byte offsets to a file named "simhash.txt".
-// It utilizes a worker pool to handle the processing concurrently.
+// IndexFileDecoder processes the given IndexData and writes SimHash values and their byte offsets to a
+// human-readable file. Entries are written in a deterministic order so that dumps of the same input are
+// identical across runs. In text format it utilizes a worker pool to format the entries concurrently.
 //
 // Parameters:
-//   - indexData: The IndexData containing the file name, chunk size, and index map of SimHash values to byte offsets.
+//   - indexData: The IndexData containing the document table, chunk size, and index map of SimHash values to postings.
+//   - path: The file the dump is written to.
+//   - order: DumpByHash lists each SimHash once, in ascending order, followed by its postings ordered by
+//     document and offset. DumpByOffset lists one entry per chunk, ordered by document and offset.
+//   - format: FormatText (the default when empty) groups postings under their SimHash; FormatJSON,
+//     FormatNDJSON and FormatCSV write one record per chunk, in the same order.
 //
 // Returns:
 //   - error: An error if any occurs during file creation or writing, otherwise nil.
-func IndexFileDecoder(indexData IndexData) error {
-	fmt.Printf("Original file: %s\n", indexData.FileName)
+func IndexFileDecoder(indexData IndexData, path, order, format string) error {
+	entries, err := dumpEntries(indexData.Index, order)
+	if err != nil {
+		return err
+	}
+	format, err = parseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range indexData.Documents {
+		fmt.Printf("Original file: %s\n", doc.Path)
+	}
 	fmt.Printf("Chunk size: %d bytes\n", indexData.ChunkSize)
-	fmt.Println("SimHash values and byte offsets writen to simhash.txt")
+	fmt.Printf("SimHash values and byte offsets written to %s\n", path)
 
-	hashfile, err := os.Create("simhash.txt")
+	hashfile, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("error creating hash file: %w", err)
 	}
 	defer hashfile.Close()
 
-	writer := bufio.NewWriter(hashfile)
-	defer writer.Flush()
-
-	entries := make(chan entry, len(indexData.Index))
-	outputChan := make(chan string, runtime.NumCPU()*2)
-
-	// Send entries to be processed
-	go func() {
-		for simhash, offsets := range indexData.Index {
-			entries <- entry{simhash, offsets}
+	if format != FormatText {
+		writer := bufio.NewWriter(hashfile)
+		if err := writeResults(writer, format, dumpResults(indexData, entries), nil); err != nil {
+			return fmt.Errorf("error writing to hash file: %w", err)
+		}
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("error writing to hash file: %w", err)
+		}
+		if err := hashfile.Close(); err != nil {
+			return fmt.Errorf("error writing to hash file: %w", err)
 		}
-		close(entries)
-	}()
+		return nil
+	}
+
+	// Format entries in parallel, each worker filling its own slots of outputs
+	outputs := make([]string, len(entries))
+	jobs := make(chan int, len(entries))
+	for i := range entries {
+		jobs <- i
+	}
+	close(jobs)
 
 	var wg sync.WaitGroup
 	numWorkers := runtime.NumCPU()
@@ -55,29 +87,82 @@ func IndexFileDecoder(indexData IndexData) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for e := range entries {
+			for j := range jobs {
+				e := entries[j]
 				var buf strings.Builder
 				fmt.Fprintf(&buf, "SimHash: %x\n", e.simhash)
-				for _, offset := range e.offsets {
-					fmt.Fprintf(&buf, "  Byte offset: %d\n", offset)
+				for _, p := range e.postings {
+					fmt.Fprintf(&buf, "  Byte offset: %d (%s)\n", p.Offset, documentPath(indexData.Documents, p.DocID))
 				}
-				outputChan <- buf.String()
+				outputs[j] = buf.String()
 			}
 		}()
 	}
+	wg.Wait()
 
-	// Close output channel when all workers finish
-	go func() {
-		wg.Wait()
-		close(outputChan)
-	}()
-
-	// Write outputs to file
-	for output := range outputChan {
+	// Write outputs to file in order
+	writer := bufio.NewWriter(hashfile)
+	for _, output := range outputs {
 		if _, err := writer.WriteString(output); err != nil {
 			return fmt.Errorf("error writing to hash file: %w", err)
 		}
 	}
-
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing to hash file: %w", err)
+	}
+	if err := hashfile.Close(); err != nil {
+		return fmt.Errorf("error writing to hash file: %w", err)
+	}
 	return nil
 }
+
+// dumpEntries arranges the index into the entries written by IndexFileDecoder, in the given order.
+func dumpEntries(index map[Fingerprint][]Posting, order string) ([]entry, error) {
+	var entries []entry
+	switch order {
+	case "", DumpByHash:
+		entries = make([]entry, 0, len(index))
+		for simhash, postings := range index {
+			entries = append(entries, entry{simhash, dedupPostings(append([]Posting(nil), postings...))})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].simhash.less(entries[j].simhash) })
+	case DumpByOffset:
+		for simhash, postings := range index {
+			for _, p := range postings {
+				entries = append(entries, entry{simhash, []Posting{p}})
+			}
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i].postings[0], entries[j].postings[0]
+			if a.DocID != b.DocID {
+				return a.DocID < b.DocID
+			}
+			if a.Offset != b.Offset {
+				return a.Offset < b.Offset
+			}
+			return entries[i].simhash.less(entries[j].simhash)
+		})
+	default:
+		return nil, fmt.Errorf("invalid dump order %q, must be %q or %q", order, DumpByHash, DumpByOffset)
+	}
+	return entries, nil
+}
+
+// dumpResults flattens dump entries into one result per chunk.
+func dumpResults(indexData IndexData, entries []entry) []Result {
+	var results []Result
+	for _, e := range entries {
+		for _, p := range e.postings {
+			results = append(results, indexData.result(e.simhash, p, 0))
+		}
+	}
+	return results
+}
+
+// documentPath returns the path of document docID, or a placeholder if the ID is out of range.
+func documentPath(docs []Document, docID int) string {
+	if docID < 0 || docID >= len(docs) {
+		return fmt.Sprintf("<unknown document %d>", docID)
+	}
+	return docs[docID].Path
+}
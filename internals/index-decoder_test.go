@@ -0,0 +1,65 @@
+package internals
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestIndexFileDecoder_Deterministic verifies that the dump is complete when RunIndex returns,
+// identical across runs, and ordered as requested.
+func TestIndexFileDecoder_Deterministic(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	content := strings.Repeat("alpha beta gamma delta epsilon zeta eta theta ", 20)
+	os.WriteFile(input, []byte(content), 0644)
+
+	dump := func(name, order string) string {
+		path := filepath.Join(dir, name)
+		opts := IndexOptions{ChunkSize: 32, DumpFile: path, DumpOrder: order}
+		if err := RunIndex([]string{input}, filepath.Join(dir, "index.idx"), opts); err != nil {
+			t.Fatalf("RunIndex() failed: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read dump: %v", err)
+		}
+		return string(data)
+	}
+
+	first := dump("first.txt", DumpByHash)
+	second := dump("second.txt", DumpByHash)
+	if first == "" || first != second {
+		t.Fatalf("Dumps of the same input differ or are empty:\n%s\n---\n%s", first, second)
+	}
+
+	// Every chunk appears exactly once in the offset-ordered dump, in ascending order
+	byOffset := dump("offset.txt", DumpByOffset)
+	lines := strings.Split(strings.TrimSpace(byOffset), "\n")
+	chunks := (len(content) + 31) / 32
+	if len(lines) != 2*chunks {
+		t.Fatalf("Offset dump has %d lines, want %d", len(lines), 2*chunks)
+	}
+	for i := 0; i < chunks; i++ {
+		want := "  Byte offset: " + strconv.Itoa(i*32) + " (" + input + ")"
+		if lines[2*i+1] != want {
+			t.Errorf("Line %d = %q, want %q", 2*i+1, lines[2*i+1], want)
+		}
+	}
+}
+
+// TestIndexFileDecoder_Errors checks invalid orders and unwritable paths.
+func TestIndexFileDecoder_Errors(t *testing.T) {
+	data := IndexData{Index: map[Fingerprint][]Posting{{1}: {{0, 0, 16}}}, Documents: []Document{{Path: "a.txt"}}}
+	if err := IndexFileDecoder(data, filepath.Join(t.TempDir(), "dump.txt"), "random", FormatText); err == nil {
+		t.Error("Expected error for invalid order, got nil")
+	}
+	if err := IndexFileDecoder(data, filepath.Join(t.TempDir(), "missing", "dump.txt"), DumpByHash, FormatText); err == nil {
+		t.Error("Expected error for unwritable path, got nil")
+	}
+	if err := IndexFileDecoder(data, filepath.Join(t.TempDir(), "dump.txt"), DumpByHash, "xml"); err == nil {
+		t.Error("Expected error for invalid format, got nil")
+	}
+}
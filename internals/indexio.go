@@ -0,0 +1,424 @@
+package internals
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An index file has the following layout:
+//
+//	offset  size  content
+//	0       8     magic bytes "TXTINDEX"
+//	8       4     format version, big-endian uint32
+//	12      4     length of the header, big-endian uint32
+//	16      ...   gob-encoded IndexHeader
+//	...     ...   index body in the layout named by the header
+//
+// In the "gob" layout the body is the gob-encoded IndexData, with its Header field left
+// empty. The "sorted" layout is described in sortedindex.go.
+//
+// The header is written before the body so that it can be inspected without decoding
+// the postings. Version 2 files hold 64-bit SimHashes, whose gob body keys the postings
+// by uint64 rather than by Fingerprint. Version 1 files also have no header length; their
+// gob-encoded header is followed directly by a gob body. Files that do not start with the
+// magic bytes are treated as version 0, the original format, which is a bare gob encoding
+// of a single-file index. Older versions are migrated when they are loaded.
+const (
+	indexMagic         = "TXTINDEX"
+	indexFormatVersion = 3
+)
+
+// The on-disk layouts of the index body.
+const (
+	layoutGob    = "gob"
+	layoutSorted = "sorted"
+)
+
+// The hashing parameters this build produces and understands.
+const (
+	hashFamilyFNV1a   = "fnv1a-64"
+	tokenizerFields   = "whitespace"
+	weightingTermFreq = "tf"
+)
+
+// legacyIndexData is the version 0 index layout, written before indexes held a header
+// and more than one document.
+type legacyIndexData struct {
+	FileName  string
+	ChunkSize int
+	Index     map[uint64][]int64
+}
+
+// indexDataV2 is the gob body of version 1 and 2 indexes, written before fingerprints could be
+// wider than 64 bits. Their permutation tables held rotated uint64 SimHashes.
+type indexDataV2 struct {
+	Documents []Document
+	ChunkSize int
+	Index     map[uint64][]Posting
+	Tables    *struct {
+		Blocks int
+		Tables [][]uint64
+	}
+}
+
+// newIndexHeader returns the header for an index built now with the current hashing parameters.
+func newIndexHeader() IndexHeader {
+	return IndexHeader{
+		Version:         indexFormatVersion,
+		HashFamily:      hashFamilyFNV1a,
+		Tokenizer:       tokenizerFields,
+		Weighting:       weightingTermFreq,
+		FingerprintBits: 64,
+		Created:         time.Now().UTC(),
+		Layout:          layoutGob,
+	}
+}
+
+// parseLayout validates a layout name, mapping the empty string to the default gob layout.
+func parseLayout(layout string) (string, error) {
+	switch layout {
+	case "", layoutGob:
+		return layoutGob, nil
+	case layoutSorted:
+		return layoutSorted, nil
+	}
+	return "", fmt.Errorf("unknown index layout %q, must be %q or %q", layout, layoutGob, layoutSorted)
+}
+
+// checkHashing returns an error if the index was built with hashing parameters that this
+// build cannot reproduce, since its fingerprints would not be comparable with query hashes.
+func (h IndexHeader) checkHashing() error {
+	if _, err := parseNormalization(h.Normalization); err != nil {
+		return fmt.Errorf("index was built with normalization %q, which this version does not support", h.Normalization)
+	}
+	if _, err := parseWeighting(h.Weighting); err != nil || h.Weighting == "" {
+		return fmt.Errorf("index was built with weighting %q, which this version does not support", h.Weighting)
+	}
+	if spec := h.minHashSpec(); h.Similarity != "" {
+		if _, err := parseMinHash(h.Similarity, spec.Perms, spec.Bands); err != nil {
+			return fmt.Errorf("index was built for similarity %q with %d permutations in %d bands, which this version does not support",
+				h.Similarity, spec.Perms, spec.Bands)
+		}
+	}
+	if _, err := parseFingerprintBits(h.FingerprintBits); err != nil || h.hashSpec().validate() != nil || h.featureSpec().validate() != nil {
+		return fmt.Errorf("index was built with hash %s, tokenizer %s, weighting %s and %d-bit fingerprints, which this version does not support",
+			h.HashFamily, h.Tokenizer, h.Weighting, h.FingerprintBits)
+	}
+	return nil
+}
+
+// sameHashing returns an error if two indexes were built with different hashing parameters.
+func (h IndexHeader) sameHashing(other IndexHeader) error {
+	if h.HashFamily == other.HashFamily && string(h.HashKey) != string(other.HashKey) {
+		return fmt.Errorf("indexes were hashed with different %s keys", h.HashFamily)
+	}
+	if h.hashSpec() != other.hashSpec() || h.featureSpec() != other.featureSpec() ||
+		h.Weighting != other.Weighting || h.FingerprintBits != other.FingerprintBits {
+		return fmt.Errorf("hashing configuration %s/%s/%s/%d-bit does not match %s/%s/%s/%d-bit",
+			h.HashFamily, h.featureSpec(), h.Weighting, h.FingerprintBits,
+			other.HashFamily, other.featureSpec(), other.Weighting, other.FingerprintBits)
+	}
+	if h.minHashSpec() != other.minHashSpec() {
+		return fmt.Errorf("MinHash signatures %+v do not match %+v", h.minHashSpec(), other.minHashSpec())
+	}
+	return nil
+}
+
+// readHeader reads the magic bytes, format version and header at the start of an index file.
+// It reports legacy as true, without consuming any input, when the file has no magic bytes.
+// For version 1 files the header shares a gob stream with the body, so the decoder used to
+// read it is returned for decoding the body.
+func readHeader(r *bufio.Reader) (header IndexHeader, legacy bool, v1 *gob.Decoder, err error) {
+	prefix, err := r.Peek(len(indexMagic) + 4)
+	if err != nil || !bytes.Equal(prefix[:len(indexMagic)], []byte(indexMagic)) {
+		return header, true, nil, nil
+	}
+
+	version := binary.BigEndian.Uint32(prefix[len(indexMagic):])
+	if version > indexFormatVersion {
+		return header, false, nil, fmt.Errorf("index format version %d is newer than the supported version %d", version, indexFormatVersion)
+	}
+	r.Discard(len(prefix))
+
+	if version == 1 {
+		v1 = gob.NewDecoder(r)
+		if err := v1.Decode(&header); err != nil {
+			return header, false, nil, fmt.Errorf("error decoding index header: %v", err)
+		}
+	} else {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return header, false, nil, fmt.Errorf("error decoding index header: %v", err)
+		}
+		headerBytes := make([]byte, length)
+		if _, err := io.ReadFull(r, headerBytes); err != nil {
+			return header, false, nil, fmt.Errorf("error decoding index header: %v", err)
+		}
+		if err := gob.NewDecoder(bytes.NewReader(headerBytes)).Decode(&header); err != nil {
+			return header, false, nil, fmt.Errorf("error decoding index header: %v", err)
+		}
+	}
+	header.Version = int(version)
+	if header.Layout == "" {
+		header.Layout = layoutGob
+	}
+	return header, false, v1, header.checkHashing()
+}
+
+// readIndexHeader reads the header of an index file without decoding its body. Indexes written
+// before headers existed get the header they are migrated to.
+//
+// Parameters:
+//   - indexFile: The path to the index file.
+//
+// Returns:
+//   - IndexHeader: The header of the index.
+//   - error: An error if the file cannot be opened, was written by a newer version, or was built
+//     with hashing parameters this version does not support.
+func readIndexHeader(indexFile string) (IndexHeader, error) {
+	file, err := os.Open(indexFile)
+	if err != nil {
+		return IndexHeader{}, fmt.Errorf("error opening index file: %v", err)
+	}
+	defer file.Close()
+
+	header, legacy, _, err := readHeader(bufio.NewReader(file))
+	if err != nil {
+		return header, err
+	}
+	if legacy {
+		header = newIndexHeader()
+		header.Version = 0
+		header.Created = time.Time{}
+	}
+	return header, nil
+}
+
+// loadIndexData opens an index file and decodes the IndexData stored in it, migrating
+// indexes written in older format versions. Sorted-layout indexes are read into memory
+// in full, so this is meant for commands that rewrite the index; queries use openIndex.
+//
+// Parameters:
+//   - indexFile: The path to the index file.
+//
+// Returns:
+//   - IndexData: The decoded index.
+//   - error: An error if the file cannot be opened or decoded, was written by a newer version,
+//     or was built with hashing parameters this version does not support.
+func loadIndexData(indexFile string) (IndexData, error) {
+	dataFile, err := os.Open(indexFile)
+	if err != nil {
+		return IndexData{}, fmt.Errorf("error opening index file: %v", err)
+	}
+	defer dataFile.Close()
+
+	return ReadIndexData(dataFile)
+}
+
+// ReadIndexData decodes an index in any supported format version and layout from r, as
+// written by WriteIndexData or saved by the index command.
+//
+// Parameters:
+//   - r: The reader the index is decoded from.
+//
+// Returns:
+//   - IndexData: The decoded index.
+//   - error: An error if the index cannot be decoded, was written by a newer version, or was
+//     built with hashing parameters this version does not support.
+func ReadIndexData(r io.Reader) (IndexData, error) {
+	reader := bufio.NewReader(r)
+	header, legacy, decoder, err := readHeader(reader)
+	if err != nil {
+		return IndexData{}, err
+	}
+	return decodeIndexBody(reader, header, legacy, decoder)
+}
+
+// decodeIndexBody decodes the body of an index whose header readHeader has just read from reader,
+// passing on what readHeader returned.
+func decodeIndexBody(reader *bufio.Reader, header IndexHeader, legacy bool, decoder *gob.Decoder) (IndexData, error) {
+	var indexData IndexData
+	var err error
+	if legacy {
+		indexData, err = decodeLegacyIndex(reader)
+		if err != nil {
+			return indexData, fmt.Errorf("error decoding index data: %v", err)
+		}
+		return indexData, nil
+	}
+
+	switch header.Layout {
+	case layoutGob:
+		if decoder == nil {
+			decoder = gob.NewDecoder(reader)
+		}
+		if header.Version < 3 {
+			indexData, err = decodeIndexV2(decoder)
+		} else {
+			err = decoder.Decode(&indexData)
+		}
+		if err != nil {
+			return indexData, fmt.Errorf("error decoding index data: %v", err)
+		}
+	case layoutSorted:
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return indexData, fmt.Errorf("error reading index file: %v", err)
+		}
+		sorted, err := parseSorted(body, header.fingerprintBits())
+		if err != nil {
+			return indexData, err
+		}
+		indexData.Documents = sorted.meta.Documents
+		indexData.ChunkSize = sorted.meta.ChunkSize
+		indexData.Index = sorted.toMap()
+		indexData.Tables = sorted.permutationIndex()
+		if sorted.docFreqs != nil {
+			header.DocFreqs = sorted.docFreqMap()
+		}
+	default:
+		return indexData, fmt.Errorf("unknown index layout %q", header.Layout)
+	}
+	indexData.Header = header
+	return indexData, nil
+}
+
+// decodeLegacyIndex decodes a version 0 index and converts it to the current layout. Version 0
+// indexes were always built with the FNV-1a whitespace tokenizer, so the migrated header records
+// those parameters; the creation time is unknown and left empty.
+func decodeLegacyIndex(r io.Reader) (IndexData, error) {
+	var legacy legacyIndexData
+	if err := gob.NewDecoder(r).Decode(&legacy); err != nil {
+		return IndexData{}, err
+	}
+
+	header := newIndexHeader()
+	header.Version = 0
+	header.Created = time.Time{}
+
+	index := make(map[Fingerprint][]Posting, len(legacy.Index))
+	for hash, offsets := range legacy.Index {
+		postings := make([]Posting, len(offsets))
+		for i, offset := range offsets {
+			postings[i] = Posting{DocID: 0, Offset: offset}
+		}
+		index[Fingerprint{hash}] = postings
+	}
+
+	return IndexData{
+		Header:    header,
+		Documents: []Document{{Path: legacy.FileName}},
+		ChunkSize: legacy.ChunkSize,
+		Index:     index,
+	}, nil
+}
+
+// decodeIndexV2 decodes the gob body of a version 1 or 2 index, keying its postings by 64-bit
+// fingerprints. The permutation tables are rebuilt with the same number of blocks when the index
+// had them.
+func decodeIndexV2(decoder *gob.Decoder) (IndexData, error) {
+	var v2 indexDataV2
+	if err := decoder.Decode(&v2); err != nil {
+		return IndexData{}, err
+	}
+
+	index := make(map[Fingerprint][]Posting, len(v2.Index))
+	for hash, postings := range v2.Index {
+		index[Fingerprint{hash}] = postings
+	}
+	indexData := IndexData{Documents: v2.Documents, ChunkSize: v2.ChunkSize, Index: index}
+	if v2.Tables != nil && v2.Tables.Blocks > 0 {
+		indexData.Tables = NewPermutationIndex(index, FingerprintBits64, v2.Tables.Blocks)
+	}
+	return indexData, nil
+}
+
+// saveIndexData serializes indexData to outputFile atomically: the index is written to a
+// temporary file in the same directory, which is then renamed over outputFile, so readers
+// never observe a partially written index.
+//
+// Parameters:
+//   - outputFile: The path the index is written to.
+//   - indexData: The index to serialize.
+//
+// Returns:
+//   - error: An error if the index cannot be written.
+func saveIndexData(outputFile string, indexData IndexData) error {
+	tmp, err := os.CreateTemp(filepath.Dir(outputFile), filepath.Base(outputFile)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating index file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := WriteIndexData(tmp, indexData); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing index file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), outputFile); err != nil {
+		return fmt.Errorf("error replacing index file: %v", err)
+	}
+	return nil
+}
+
+// WriteIndexData serializes indexData to w. The index is always written in the current format
+// version, so writing a migrated index upgrades it, and in the layout named by its header.
+//
+// Parameters:
+//   - w: The writer the index is written to.
+//   - indexData: The index to serialize.
+//
+// Returns:
+//   - error: An error if the index cannot be encoded or written.
+func WriteIndexData(w io.Writer, indexData IndexData) error {
+	header := indexData.Header
+	header.Version = indexFormatVersion
+	var err error
+	if header.Layout, err = parseLayout(header.Layout); err != nil {
+		return err
+	}
+	if header.Layout == layoutSorted && indexData.MinHash != nil {
+		return fmt.Errorf("MinHash signatures can only be stored in the %q layout", layoutGob)
+	}
+	indexData.Header = IndexHeader{}
+
+	// The sorted layout keeps the document frequencies in its body, so that opening it does not
+	// decode them
+	docFreqs := header.DocFreqs
+	if header.Layout == layoutSorted {
+		header.DocFreqs = nil
+	}
+
+	var headerBytes bytes.Buffer
+	if err := gob.NewEncoder(&headerBytes).Encode(header); err != nil {
+		return fmt.Errorf("error encoding index header: %v", err)
+	}
+
+	writer := bufio.NewWriter(w)
+	writer.WriteString(indexMagic)
+	binary.Write(writer, binary.BigEndian, uint32(indexFormatVersion))
+	binary.Write(writer, binary.BigEndian, uint32(headerBytes.Len()))
+	writer.Write(headerBytes.Bytes())
+
+	if header.Layout == layoutSorted {
+		err = encodeSorted(writer, indexData, header.fingerprintBits(), docFreqs)
+	} else {
+		err = gob.NewEncoder(writer).Encode(indexData)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding index data: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing index file: %v", err)
+	}
+	return nil
+}
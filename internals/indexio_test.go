@@ -0,0 +1,174 @@
+package internals
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSaveLoadIndexData verifies that an index survives a round trip and starts with the magic header.
+func TestSaveLoadIndexData(t *testing.T) {
+	indexFile := filepath.Join(t.TempDir(), "index.idx")
+	want := IndexData{
+		Header:    newIndexHeader(),
+		Documents: []Document{{Path: "a.txt", Size: 10, Digest: "abc"}},
+		ChunkSize: 16,
+		Index:     map[Fingerprint][]Posting{{0x1234}: {{DocID: 0, Offset: 0}}},
+	}
+	if err := saveIndexData(indexFile, want); err != nil {
+		t.Fatalf("saveIndexData() failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to read index file: %v", err)
+	}
+	if !strings.HasPrefix(string(raw), indexMagic) {
+		t.Errorf("Index file does not start with magic bytes %q", indexMagic)
+	}
+	if v := binary.BigEndian.Uint32(raw[len(indexMagic):]); v != indexFormatVersion {
+		t.Errorf("Stored format version = %d, want %d", v, indexFormatVersion)
+	}
+
+	got, err := loadIndexData(indexFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	if !got.Header.Created.Equal(want.Header.Created) {
+		t.Errorf("Header.Created = %v, want %v", got.Header.Created, want.Header.Created)
+	}
+	got.Header.Created = want.Header.Created
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadIndexData() = %+v, want %+v", got, want)
+	}
+}
+
+// TestLoadIndexData_Legacy verifies that a version 0 index is migrated to the current layout.
+func TestLoadIndexData_Legacy(t *testing.T) {
+	indexFile := filepath.Join(t.TempDir(), "legacy.idx")
+	file, err := os.Create(indexFile)
+	if err != nil {
+		t.Fatalf("Failed to create index file: %v", err)
+	}
+	legacy := legacyIndexData{
+		FileName:  "gb.txt",
+		ChunkSize: 4096,
+		Index:     map[uint64][]int64{0xabc: {0, 8192}},
+	}
+	if err := gob.NewEncoder(file).Encode(legacy); err != nil {
+		t.Fatalf("Failed to encode legacy index: %v", err)
+	}
+	file.Close()
+
+	got, err := loadIndexData(indexFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	if got.Header.Version != 0 || got.Header.HashFamily != hashFamilyFNV1a {
+		t.Errorf("Unexpected migrated header: %+v", got.Header)
+	}
+	if len(got.Documents) != 1 || got.Documents[0].Path != "gb.txt" || got.ChunkSize != 4096 {
+		t.Errorf("Unexpected migrated index: %+v", got)
+	}
+	want := []Posting{{DocID: 0, Offset: 0}, {DocID: 0, Offset: 8192}}
+	if !reflect.DeepEqual(got.Index[Fingerprint{0xabc}], want) {
+		t.Errorf("Migrated postings = %v, want %v", got.Index[Fingerprint{0xabc}], want)
+	}
+}
+
+// TestLoadIndexData_Version2 verifies that a version 2 index, whose postings are keyed by uint64
+// SimHashes, is migrated to 64-bit fingerprints and gets its permutation tables back.
+func TestLoadIndexData_Version2(t *testing.T) {
+	var headerBytes, body bytes.Buffer
+	header := newIndexHeader()
+	header.Version = 2
+	gob.NewEncoder(&headerBytes).Encode(header)
+	v2 := indexDataV2{
+		Documents: []Document{{Path: "a.txt"}},
+		ChunkSize: 16,
+		Index:     map[uint64][]Posting{0xabc: {{DocID: 0, Offset: 16, Length: 16}}},
+	}
+	v2.Tables = &struct {
+		Blocks int
+		Tables [][]uint64
+	}{Blocks: 4, Tables: [][]uint64{{0xabc}, {0xabc}, {0xabc}, {0xabc}}}
+	gob.NewEncoder(&body).Encode(v2)
+
+	data := []byte(indexMagic)
+	data = binary.BigEndian.AppendUint32(data, 2)
+	data = binary.BigEndian.AppendUint32(data, uint32(headerBytes.Len()))
+	data = append(append(data, headerBytes.Bytes()...), body.Bytes()...)
+
+	got, err := ReadIndexData(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadIndexData() failed: %v", err)
+	}
+	if got.Header.Version != 2 || got.Header.fingerprintBits() != FingerprintBits64 {
+		t.Errorf("Unexpected migrated header: %+v", got.Header)
+	}
+	want := []Posting{{DocID: 0, Offset: 16, Length: 16}}
+	if !reflect.DeepEqual(got.Index[Fingerprint{0xabc}], want) {
+		t.Errorf("Migrated postings = %v, want %v", got.Index[Fingerprint{0xabc}], want)
+	}
+	if !got.Tables.Supports(3) || len(got.Tables.Keys) != 1 {
+		t.Errorf("Migrated tables = %+v, want 4 blocks over one key", got.Tables)
+	}
+}
+
+// TestLoadIndexData_Rejected checks that newer formats and foreign hashing parameters are refused.
+func TestLoadIndexData_Rejected(t *testing.T) {
+	dir := t.TempDir()
+
+	newer := filepath.Join(dir, "newer.idx")
+	data := []byte(indexMagic)
+	data = binary.BigEndian.AppendUint32(data, indexFormatVersion+1)
+	os.WriteFile(newer, data, 0644)
+
+	foreign := filepath.Join(dir, "foreign.idx")
+	header := newIndexHeader()
+	header.HashFamily = "md5"
+	saveIndexData(foreign, IndexData{Header: header, ChunkSize: 16})
+
+	width := filepath.Join(dir, "width.idx")
+	header = newIndexHeader()
+	header.FingerprintBits = 100
+	saveIndexData(width, IndexData{Header: header, ChunkSize: 16})
+
+	tests := []struct {
+		name     string
+		file     string
+		errorMsg string
+	}{
+		{"Newer version", newer, "newer than the supported version"},
+		{"Foreign hash family", foreign, "hash md5"},
+		{"Foreign fingerprint width", width, "100-bit fingerprints"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadIndexData(tt.file)
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("loadIndexData() error = %v, want error containing %q", err, tt.errorMsg)
+			}
+		})
+	}
+}
+
+// TestWriteIndexData_Rejected checks that an index the layout cannot hold is refused before anything
+// is written.
+func TestWriteIndexData_Rejected(t *testing.T) {
+	header := newIndexHeader()
+	header.Layout = layoutSorted
+	var buf bytes.Buffer
+	err := WriteIndexData(&buf, IndexData{Header: header, ChunkSize: 16, MinHash: &MinHashIndex{Perms: 4, Bands: 1}})
+	if err == nil {
+		t.Fatal("Expected WriteIndexData() to reject MinHash signatures in the sorted layout, got nil")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("WriteIndexData() wrote %d bytes before failing", buf.Len())
+	}
+}
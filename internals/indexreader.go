@@ -0,0 +1,200 @@
+package internals
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// indexReader answers queries against an index file. Indexes in the gob layout are decoded
+// into memory; indexes in the sorted layout are memory-mapped and searched in place, so
+// opening them does not depend on the size of the index.
+type indexReader struct {
+	IndexData
+	sorted *sortedIndex
+	unmap  func() error
+}
+
+// openIndex opens an index file for querying.
+//
+// Parameters:
+//   - indexFile: The path to the index file.
+//
+// Returns:
+//   - *indexReader: The opened index, which must be closed when no longer needed.
+//   - error: An error if the file cannot be opened or decoded.
+func openIndex(indexFile string) (*indexReader, error) {
+	file, err := os.Open(indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening index file: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header, legacy, decoder, err := readHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if legacy || header.Layout != layoutSorted {
+		indexData, err := decodeIndexBody(reader, header, legacy, decoder)
+		if err != nil {
+			return nil, err
+		}
+		return &indexReader{IndexData: indexData}, nil
+	}
+
+	// The body starts after the bytes consumed by readHeader, less what is still buffered
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %v", err)
+	}
+	bodyStart := pos - int64(reader.Buffered())
+
+	data, unmap, err := mmapFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping index file: %v", err)
+	}
+	sorted, err := parseSorted(data[bodyStart:], header.fingerprintBits())
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+
+	return &indexReader{
+		IndexData: IndexData{
+			Header:    header,
+			Documents: sorted.meta.Documents,
+			ChunkSize: sorted.meta.ChunkSize,
+		},
+		sorted: sorted,
+		unmap:  unmap,
+	}, nil
+}
+
+// lookup returns the postings of an exact SimHash.
+func (r *indexReader) lookup(hash Fingerprint) ([]Posting, bool) {
+	if r.sorted != nil {
+		return r.sorted.lookup(hash)
+	}
+	postings, ok := r.Index[hash]
+	return postings, ok
+}
+
+// nearby returns the chunks whose fingerprint is within maxDistance of simHash, closest first.
+func (r *indexReader) nearby(simHash Fingerprint, maxDistance, topK int) []fuzzyMatch {
+	if r.sorted != nil {
+		return r.sorted.nearby(simHash, maxDistance, topK)
+	}
+	return findNearby(r.Index, r.Tables, simHash, maxDistance, topK)
+}
+
+// idfTable returns the document frequencies of an index built with tf·idf weighting, and nil for
+// other indexes. Those of a sorted-layout index are binary-searched in the mapped body rather than
+// decoded.
+func (r *indexReader) idfTable() *idfTable {
+	t := r.Header.idfTable()
+	if t != nil && r.sorted != nil && r.sorted.docFreqs != nil {
+		t.docFreqs, t.sortedFreqs = nil, r.sorted.docFreqs
+	}
+	return t
+}
+
+// Close releases the memory mapping of a sorted-layout index.
+func (r *indexReader) Close() {
+	if r.unmap != nil {
+		r.unmap()
+	}
+}
+
+// Lookup returns every chunk of an in-memory index indexed under simHash, ordered by document and
+// offset, with overlapping chunks collapsed into regions. The results carry no phrase, since the
+// documents are not read.
+func (d IndexData) Lookup(simHash Fingerprint) []Result {
+	postings := dedupPostings(append([]Posting(nil), d.Index[simHash]...))
+	if d.chunkSpec().overlapping() {
+		postings = collapsePostings(simHash, postings, d.ChunkSize)
+	}
+	results := make([]Result, len(postings))
+	for i, p := range postings {
+		results[i] = d.result(simHash, p, 0)
+	}
+	return results
+}
+
+// Near returns the chunks of an in-memory index whose SimHash is within maxDistance of simHash,
+// closest first, limited to topK results when topK is greater than 0. The permutation tables are
+// used when they cover maxDistance, and overlapping chunks are collapsed into regions. The results
+// carry no phrase, since the documents are not read.
+func (d IndexData) Near(simHash Fingerprint, maxDistance, topK int) []Result {
+	var matches []fuzzyMatch
+	if d.chunkSpec().overlapping() {
+		matches = collapseMatches(findNearby(d.Index, d.Tables, simHash, maxDistance, 0), d.ChunkSize, topK)
+	} else {
+		matches = findNearby(d.Index, d.Tables, simHash, maxDistance, topK)
+	}
+	results := make([]Result, len(matches))
+	for i, m := range matches {
+		results[i] = d.result(m.simhash, m.posting, m.distance)
+	}
+	return results
+}
+
+// Similar returns the chunks of an in-memory index built for SimilarityMinHash whose estimated
+// Jaccard similarity to text is at least minJaccard, most similar first, limited to topK results when
+// topK is greater than 0. Overlapping chunks are collapsed into regions. It returns nil for an index
+// without MinHash signatures. The results carry no phrase, since the documents are not read.
+func (d IndexData) Similar(text []byte, minJaccard float64, topK int) []Result {
+	if d.MinHash == nil {
+		return nil
+	}
+	matches := d.similar(d.Header.textMinHash(text), minJaccard, topK)
+	results := make([]Result, len(matches))
+	for i, m := range matches {
+		results[i] = d.result(m.simhash, m.posting, 0)
+		results[i].Mismatches, results[i].Jaccard = m.distance, jaccard(m.distance, d.MinHash.Perms)
+	}
+	return results
+}
+
+// similar returns the chunks whose signature is similar to signature, with overlapping chunks
+// collapsed into regions before the topK most similar are kept.
+func (d IndexData) similar(signature []uint64, minJaccard float64, topK int) []fuzzyMatch {
+	if d.chunkSpec().overlapping() {
+		return collapseMatches(d.MinHash.similar(signature, minJaccard, 0), d.ChunkSize, topK)
+	}
+	return d.MinHash.similar(signature, minJaccard, topK)
+}
+
+// chunkSignature returns the MinHash signature of the first chunk, by document and offset, indexed
+// under simHash, or nil when no chunk is.
+func (d IndexData) chunkSignature(simHash Fingerprint) []uint64 {
+	keys := d.MinHash.Keys
+	i := sort.Search(len(keys), func(i int) bool { return !keys[i].less(simHash) })
+	if i == len(keys) || keys[i] != simHash {
+		return nil
+	}
+	return d.MinHash.signature(i)
+}
+
+// result describes the chunk of a posting. The length of the chunk is the recorded one or, for
+// indexes without chunk lengths, is derived from the chunk size and, for the last chunk of a
+// document, the document's recorded size.
+func (d IndexData) result(simHash Fingerprint, p Posting, distance int) Result {
+	length := int64(d.ChunkSize)
+	if p.Length > 0 {
+		length = int64(p.Length)
+	} else if p.DocID >= 0 && p.DocID < len(d.Documents) {
+		if size := d.Documents[p.DocID].Size; size > 0 && size-p.Offset < length {
+			length = size - p.Offset
+		}
+	}
+	return Result{
+		File:     documentPath(d.Documents, p.DocID),
+		SimHash:  simHash,
+		Offset:   p.Offset,
+		Distance: distance,
+		Length:   int(length),
+	}
+}
@@ -0,0 +1,300 @@
+package internals
+
+import (
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+)
+
+// The similarity measures an index can be built for, by the names the index command accepts for
+// them. Every index is keyed by SimHash, which approximates the cosine similarity of the feature
+// counts of two chunks; an index built for SimilarityMinHash also stores a MinHash signature of
+// every chunk, which estimates the Jaccard similarity of their sets of features.
+const (
+	SimilaritySimHash = "simhash"
+	SimilarityMinHash = "minhash"
+)
+
+// The default size of MinHash signatures, and the default number of rows in each LSH band. With
+// 128 permutations in 32 bands of 4 rows, chunks with a Jaccard similarity of 0.5 share a band
+// with a probability of 87%, and chunks with a similarity of 0.8 with a probability of over 99.9%.
+const (
+	DefaultMinHashPerms = 128
+	defaultMinHashRows  = 4
+	maxMinHashPerms     = 1024
+)
+
+// minHashSpec is the size of the MinHash signature of every chunk and the number of LSH bands it
+// is split into. The zero value means the index has no signatures.
+type minHashSpec struct {
+	Perms int
+	Bands int
+}
+
+// parseSimilarity validates a similarity name, mapping the empty string to SimilaritySimHash.
+func parseSimilarity(similarity string) (string, error) {
+	switch similarity {
+	case "", SimilaritySimHash:
+		return SimilaritySimHash, nil
+	case SimilarityMinHash:
+		return SimilarityMinHash, nil
+	}
+	return "", fmt.Errorf("unknown similarity %q, must be %q or %q", similarity, SimilaritySimHash, SimilarityMinHash)
+}
+
+// parseMinHash builds a MinHash spec from the settings of the index command.
+//
+// Parameters:
+//   - similarity: SimilaritySimHash or SimilarityMinHash; SimilaritySimHash when empty.
+//   - perms: The number of permutations, which is the length of each signature; DefaultMinHashPerms
+//     when 0. It must be 0 unless similarity is SimilarityMinHash.
+//   - bands: The number of LSH bands the signature is split into, which must divide perms; bands of
+//     4 rows when 0. It must be 0 unless similarity is SimilarityMinHash.
+//
+// Returns:
+//   - minHashSpec: The parsed spec, whose zero value means the index has no signatures.
+//   - error: An error if the similarity is unknown or the sizes are invalid.
+func parseMinHash(similarity string, perms, bands int) (minHashSpec, error) {
+	similarity, err := parseSimilarity(similarity)
+	if err != nil {
+		return minHashSpec{}, err
+	}
+	if similarity != SimilarityMinHash {
+		if perms != 0 || bands != 0 {
+			return minHashSpec{}, fmt.Errorf("MinHash permutations and bands require similarity %q", SimilarityMinHash)
+		}
+		return minHashSpec{}, nil
+	}
+
+	if perms == 0 {
+		perms = DefaultMinHashPerms
+	}
+	if perms < 1 || perms > maxMinHashPerms {
+		return minHashSpec{}, fmt.Errorf("invalid number of MinHash permutations %d, must be between 1 and %d", perms, maxMinHashPerms)
+	}
+	if bands == 0 {
+		bands = max(perms/defaultMinHashRows, 1)
+	}
+	if bands < 1 || bands > perms || perms%bands != 0 {
+		return minHashSpec{}, fmt.Errorf("invalid number of LSH bands %d, must divide the %d MinHash permutations", bands, perms)
+	}
+	return minHashSpec{Perms: perms, Bands: bands}, nil
+}
+
+// minHashSpec validates the similarity settings of the options. MinHash signatures are only
+// stored in the gob layout.
+func (opts IndexOptions) minHashSpec() (minHashSpec, error) {
+	spec, err := parseMinHash(opts.Similarity, opts.MinHashPerms, opts.MinHashBands)
+	if err != nil {
+		return spec, err
+	}
+	if layout, err := parseLayout(opts.Layout); err == nil && layout == layoutSorted && spec.enabled() {
+		return spec, fmt.Errorf("MinHash signatures can only be stored in the %q layout", layoutGob)
+	}
+	return spec, nil
+}
+
+// enabled reports whether the spec describes MinHash signatures.
+func (s minHashSpec) enabled() bool {
+	return s.Perms > 0
+}
+
+// rows returns the number of signature values in each LSH band.
+func (s minHashSpec) rows() int {
+	return s.Perms / s.Bands
+}
+
+// minHashSpec returns the MinHash spec recorded in the header, the zero spec for indexes built
+// for SimHash alone.
+func (h IndexHeader) minHashSpec() minHashSpec {
+	if h.Similarity != SimilarityMinHash {
+		return minHashSpec{}
+	}
+	return minHashSpec{Perms: h.MinHashPerms, Bands: h.MinHashBands}
+}
+
+// setMinHash records the similarity and, for MinHash, the signature size and bands in the header.
+func (h *IndexHeader) setMinHash(spec minHashSpec) {
+	h.Similarity, h.MinHashPerms, h.MinHashBands = SimilaritySimHash, 0, 0
+	if spec.enabled() {
+		h.Similarity, h.MinHashPerms, h.MinHashBands = SimilarityMinHash, spec.Perms, spec.Bands
+	}
+}
+
+// minHash computes the MinHash signature of the set of features the spec extracts from data. Value
+// i of the signature is the smallest hash of any feature under permutation i, where permutation 0
+// is the feature hash itself and the others are derived from it by featureWord. How often a
+// feature occurs does not matter, and a chunk without features has a signature of all ones.
+//
+// Parameters:
+//   - data: The input data as a byte slice.
+//   - h: A hash.Hash64 instance used to compute the hash of each feature.
+//   - perms: The number of permutations, which is the length of the signature.
+//
+// Returns:
+//   - []uint64: The signature of data.
+func (f featureSpec) minHash(data []byte, h hash.Hash64, perms int) []uint64 {
+	signature := make([]uint64, perms)
+	for i := range signature {
+		signature[i] = math.MaxUint64
+	}
+	for feature := range f.counts(data) {
+		hash := featureHash(h, feature)
+		for i := range signature {
+			if v := featureWord(hash, i); v < signature[i] {
+				signature[i] = v
+			}
+		}
+	}
+	return signature
+}
+
+// signatureDistance returns the number of positions at which two signatures of the same length
+// differ. The fraction of positions at which they agree estimates the Jaccard similarity of the
+// feature sets they were computed from.
+func signatureDistance(a, b []uint64) int {
+	distance := 0
+	for i := range a {
+		if a[i] != b[i] {
+			distance++
+		}
+	}
+	return distance
+}
+
+// jaccard returns the Jaccard similarity estimated from two signatures of perms values that differ
+// at distance positions.
+func jaccard(distance, perms int) float64 {
+	return float64(perms-distance) / float64(perms)
+}
+
+// bandKey hashes the values of a signature that make up one LSH band.
+func bandKey(values []uint64) uint64 {
+	key := uint64(0)
+	for _, v := range values {
+		key = featureWord(key^v, 1)
+	}
+	return key
+}
+
+// MinHashIndex holds the MinHash signatures of the chunks of an index built for SimilarityMinHash,
+// with the locality-sensitive hashing bands used to find candidates for a Jaccard search.
+//
+// Chunk i is located by Postings[i], is indexed under the SimHash Keys[i], and has the signature
+// Signatures[i*Perms:(i+1)*Perms]; chunks are ordered by Keys, then by document and offset. Every
+// signature is split into Bands bands of Perms/Bands consecutive values, and Buckets[b] maps the
+// hash of band b to the chunks sharing it. Chunks with a Jaccard similarity s share at least one
+// band with a probability of 1-(1-s^r)^b for r rows and b bands, so only the chunks in the query's
+// buckets need to be compared with it.
+type MinHashIndex struct {
+	Perms      int
+	Bands      int
+	Keys       []Fingerprint
+	Postings   []Posting
+	Signatures []uint64
+	Buckets    []map[uint64][]uint32
+}
+
+// NewMinHashIndex builds the LSH bands for the signature of every chunk in index.
+//
+// Parameters:
+//   - index: The map of SimHash fingerprints to postings.
+//   - signatures: The MinHash signature of every posting in index; postings without one are left out.
+//   - perms: The length of each signature.
+//   - bands: The number of bands each signature is split into, which must divide perms.
+//
+// Returns:
+//   - *MinHashIndex: The populated MinHash index, with chunks ordered by SimHash and then by
+//     document and offset, so that the chunks of a SimHash can be found by binary search.
+func NewMinHashIndex(index map[Fingerprint][]Posting, signatures map[Posting][]uint64, perms, bands int) *MinHashIndex {
+	m := &MinHashIndex{Perms: perms, Bands: bands, Buckets: make([]map[uint64][]uint32, bands)}
+	for hash, postings := range index {
+		for _, p := range postings {
+			if len(signatures[p]) == perms {
+				m.Keys = append(m.Keys, hash)
+				m.Postings = append(m.Postings, p)
+			}
+		}
+	}
+	order := make([]int, len(m.Postings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if ka, kb := m.Keys[order[a]], m.Keys[order[b]]; ka != kb {
+			return ka.less(kb)
+		}
+		pa, pb := m.Postings[order[a]], m.Postings[order[b]]
+		if pa.DocID != pb.DocID {
+			return pa.DocID < pb.DocID
+		}
+		if pa.Offset != pb.Offset {
+			return pa.Offset < pb.Offset
+		}
+		return pa.Length < pb.Length
+	})
+
+	keys, postings := make([]Fingerprint, len(order)), make([]Posting, len(order))
+	m.Signatures = make([]uint64, 0, len(order)*perms)
+	for i, j := range order {
+		keys[i], postings[i] = m.Keys[j], m.Postings[j]
+		m.Signatures = append(m.Signatures, signatures[postings[i]]...)
+	}
+	m.Keys, m.Postings = keys, postings
+
+	rows := perms / bands
+	for b := range m.Buckets {
+		m.Buckets[b] = make(map[uint64][]uint32)
+		for i := range m.Postings {
+			key := bandKey(m.signature(i)[b*rows : (b+1)*rows])
+			m.Buckets[b][key] = append(m.Buckets[b][key], uint32(i))
+		}
+	}
+	return m
+}
+
+// signature returns the signature of chunk i.
+func (m *MinHashIndex) signature(i int) []uint64 {
+	return m.Signatures[i*m.Perms : (i+1)*m.Perms]
+}
+
+// signatureMap returns the signature of every chunk, keyed by its posting.
+func (m *MinHashIndex) signatureMap() map[Posting][]uint64 {
+	signatures := make(map[Posting][]uint64)
+	if m == nil {
+		return signatures
+	}
+	for i, p := range m.Postings {
+		signatures[p] = m.signature(i)
+	}
+	return signatures
+}
+
+// similar returns every chunk that shares an LSH band with signature and whose estimated Jaccard
+// similarity to it is at least minJaccard. The distance of each match is the number of signature
+// values that differ, so sortMatches orders them from the most to the least similar.
+func (m *MinHashIndex) similar(signature []uint64, minJaccard float64, topK int) []fuzzyMatch {
+	rows := m.Perms / m.Bands
+	seen := make(map[uint32]bool)
+	var matches []fuzzyMatch
+	for b, buckets := range m.Buckets {
+		for _, i := range buckets[bandKey(signature[b*rows:(b+1)*rows])] {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			distance := signatureDistance(signature, m.signature(int(i)))
+			if jaccard(distance, m.Perms) >= minJaccard {
+				matches = append(matches, fuzzyMatch{m.Keys[i], distance, m.Postings[i]})
+			}
+		}
+	}
+	return sortMatches(matches, topK)
+}
+
+// textMinHash normalizes and filters the given text and computes its MinHash signature from the
+// features, feature hash and signature size the index was built with.
+func (h IndexHeader) textMinHash(text []byte) []uint64 {
+	return h.featureSpec().minHash(text, h.hashSpec().new(), h.minHashSpec().Perms)
+}
@@ -0,0 +1,238 @@
+package internals
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wordList returns the words prefix+from to prefix+to-1, separated by spaces.
+func wordList(prefix string, from, to int) string {
+	words := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		words = append(words, fmt.Sprintf("%s%d", prefix, i))
+	}
+	return strings.Join(words, " ")
+}
+
+// TestParseMinHash checks the defaults and validation of the similarity settings.
+func TestParseMinHash(t *testing.T) {
+	tests := []struct {
+		similarity  string
+		perms       int
+		bands       int
+		want        minHashSpec
+		expectError bool
+	}{
+		{"", 0, 0, minHashSpec{}, false},
+		{SimilaritySimHash, 0, 0, minHashSpec{}, false},
+		{SimilarityMinHash, 0, 0, minHashSpec{Perms: 128, Bands: 32}, false},
+		{SimilarityMinHash, 64, 0, minHashSpec{Perms: 64, Bands: 16}, false},
+		{SimilarityMinHash, 3, 0, minHashSpec{Perms: 3, Bands: 1}, false},
+		{SimilarityMinHash, 6, 4, minHashSpec{}, true},
+		{SimilarityMinHash, 2, 0, minHashSpec{Perms: 2, Bands: 1}, false},
+		{SimilarityMinHash, 100, 20, minHashSpec{Perms: 100, Bands: 20}, false},
+		{SimilarityMinHash, 100, 30, minHashSpec{}, true},
+		{SimilarityMinHash, -1, 0, minHashSpec{}, true},
+		{SimilarityMinHash, maxMinHashPerms + 1, 0, minHashSpec{}, true},
+		{SimilaritySimHash, 128, 0, minHashSpec{}, true},
+		{"lsh", 0, 0, minHashSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d/%d", tt.similarity, tt.perms, tt.bands), func(t *testing.T) {
+			got, err := parseMinHash(tt.similarity, tt.perms, tt.bands)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("parseMinHash() error = %v, expectError %v", err, tt.expectError)
+			}
+			if got != tt.want {
+				t.Errorf("parseMinHash() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	opts := IndexOptions{Similarity: SimilarityMinHash, Layout: layoutSorted}
+	if _, err := opts.minHashSpec(); err == nil {
+		t.Error("Expected MinHash signatures to be rejected in the sorted layout, got nil")
+	}
+}
+
+// TestFeatureSpec_MinHash checks that signatures only depend on the set of features, and that
+// they estimate the Jaccard similarity of two sets.
+func TestFeatureSpec_MinHash(t *testing.T) {
+	h := fnv.New64a()
+	a := defaultFeatures.minHash([]byte(wordList("w", 0, 100)), h, 256)
+	if len(a) != 256 {
+		t.Fatalf("Signature has %d values, want 256", len(a))
+	}
+
+	shuffled := wordList("w", 50, 100) + " " + wordList("w", 0, 50) + " w7 w7"
+	if d := signatureDistance(a, defaultFeatures.minHash([]byte(shuffled), h, 256)); d != 0 {
+		t.Errorf("Reordered and repeated words change %d signature values, want 0", d)
+	}
+
+	// {w0..w99} and {w25..w124} share 75 of 125 words
+	b := defaultFeatures.minHash([]byte(wordList("w", 25, 125)), h, 256)
+	if got := jaccard(signatureDistance(a, b), 256); math.Abs(got-0.6) > 0.12 {
+		t.Errorf("Estimated Jaccard similarity = %.3f, want about 0.6", got)
+	}
+	c := defaultFeatures.minHash([]byte(wordList("x", 0, 100)), h, 256)
+	if got := jaccard(signatureDistance(a, c), 256); got > 0.05 {
+		t.Errorf("Estimated Jaccard similarity of disjoint sets = %.3f, want about 0", got)
+	}
+
+	// The first value is the smallest feature hash itself
+	if want := featureHash(h, "w0"); defaultFeatures.minHash([]byte("w0"), h, 1)[0] != want {
+		t.Errorf("Signature of a single feature does not hold its hash %x", want)
+	}
+}
+
+// TestMinHashIndex_Similar checks that the LSH bands find the same similar chunks as comparing the
+// query with every signature, and that signatures survive the round trip through a posting map.
+func TestMinHashIndex_Similar(t *testing.T) {
+	h := fnv.New64a()
+	const perms, bands = 64, 16
+	index := make(map[Fingerprint][]Posting)
+	signatures := make(map[Posting][]uint64)
+	for i := 0; i < 200; i++ {
+		text := []byte(wordList("w", i, i+40))
+		p := Posting{DocID: i % 3, Offset: int64(i) * 100, Length: len(text)}
+		hash := Fingerprint{defaultFeatures.simHash(text, h)}
+		index[hash] = append(index[hash], p)
+		signatures[p] = defaultFeatures.minHash(text, h, perms)
+	}
+	m := NewMinHashIndex(index, signatures, perms, bands)
+	if len(m.Postings) != 200 || len(m.Signatures) != 200*perms || len(m.Buckets) != bands {
+		t.Fatalf("MinHash index has %d chunks, %d values and %d bands", len(m.Postings), len(m.Signatures), len(m.Buckets))
+	}
+	for p, signature := range m.signatureMap() {
+		if signatureDistance(signature, signatures[p]) != 0 {
+			t.Fatalf("Signature of %+v changed in the index", p)
+		}
+	}
+
+	query := defaultFeatures.minHash([]byte(wordList("w", 100, 140)), h, perms)
+	got := m.similar(query, 0.7, 0)
+	var want []fuzzyMatch
+	for i, p := range m.Postings {
+		if d := signatureDistance(query, m.signature(i)); jaccard(d, perms) >= 0.7 {
+			want = append(want, fuzzyMatch{m.Keys[i], d, p})
+		}
+	}
+	want = sortMatches(want, 0)
+	if len(got) == 0 || len(got) != len(want) {
+		t.Fatalf("similar() found %d chunks, a full scan %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("similar()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got[0].distance != 0 || got[0].posting.Offset != 10000 {
+		t.Errorf("Most similar chunk = %+v, want the identical chunk at offset 10000", got[0])
+	}
+	if top := m.similar(query, 0.7, 2); len(top) != 2 || top[1] != want[1] {
+		t.Errorf("similar() with topK 2 = %+v, want the first 2 of %+v", top, want)
+	}
+
+	d := IndexData{MinHash: m}
+	for hash, postings := range index {
+		first := postings[0]
+		for _, p := range postings[1:] {
+			if p.DocID < first.DocID || p.DocID == first.DocID && p.Offset < first.Offset {
+				first = p
+			}
+		}
+		if got := d.chunkSignature(hash); signatureDistance(got, signatures[first]) != 0 {
+			t.Errorf("chunkSignature(%v) is not the signature of %+v", hash, first)
+		}
+	}
+	if got := d.chunkSignature(Fingerprint{0}); got != nil {
+		t.Errorf("chunkSignature() of an unindexed SimHash = %v, want nil", got)
+	}
+}
+
+// TestRunIndex_MinHash builds an index with MinHash signatures and checks that Jaccard searches,
+// updates and merges work with it.
+func TestRunIndex_MinHash(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	paragraphs := []string{wordList("alpha", 0, 40), wordList("beta", 0, 40), wordList("gamma", 0, 40)}
+	os.WriteFile(input, []byte(strings.Join(paragraphs, "\n\n")), 0644)
+
+	indexFile := filepath.Join(dir, "minhash.idx")
+	opts := IndexOptions{ChunkSize: 400, Chunking: ChunkParagraphs, Similarity: SimilarityMinHash}
+	if err := RunIndex([]string{input}, indexFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	// The second paragraph with 4 of its 40 words replaced shares 36 of 44 words with it
+	query := wordList("beta", 4, 40) + " " + wordList("delta", 0, 4)
+	results, err := FuzzyJaccard(indexFile, "", query, "", 0.6, 0, QueryOptions{})
+	if err != nil {
+		t.Fatalf("FuzzyJaccard() failed: %v", err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(results[0].Phrase, "beta0") || math.Abs(results[0].Jaccard-36.0/44) > 0.15 {
+		t.Fatalf("FuzzyJaccard() = %+v, want the second paragraph with a similarity of about 0.82", results)
+	}
+	if got := jaccard(results[0].Mismatches, DefaultMinHashPerms); got != results[0].Jaccard || results[0].Distance != 0 {
+		t.Errorf("Jaccard %g does not match %d mismatches, or distance %d is set", results[0].Jaccard, results[0].Mismatches, results[0].Distance)
+	}
+
+	// An indexed chunk can be named by its SimHash
+	byHash, err := FuzzyJaccard(indexFile, results[0].SimHash.String(), "", "", 1, 0, QueryOptions{})
+	if err != nil || len(byHash) != 1 || byHash[0].Offset != results[0].Offset || byHash[0].Jaccard != 1 {
+		t.Errorf("FuzzyJaccard() by SimHash = %+v, %v, want the same chunk with similarity 1", byHash, err)
+	}
+	if _, err := FuzzyJaccard(indexFile, "123", "", "", 0.5, 0, QueryOptions{}); err == nil {
+		t.Error("Expected FuzzyJaccard() to reject a SimHash that is not indexed, got nil")
+	}
+	for _, minJaccard := range []float64{0, -0.5, 1.5} {
+		if _, err := FuzzyJaccard(indexFile, "", query, "", minJaccard, 0, QueryOptions{}); err == nil {
+			t.Errorf("Expected FuzzyJaccard() to reject a similarity of %g, got nil", minJaccard)
+		}
+	}
+
+	// Appended paragraphs get signatures too, and the merged index keeps them
+	file, _ := os.OpenFile(input, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("\n\n" + wordList("delta", 0, 40))
+	file.Close()
+	if err := RunUpdate(io.Discard, indexFile, nil); err != nil {
+		t.Fatalf("RunUpdate() failed: %v", err)
+	}
+	merged := filepath.Join(dir, "merged.idx")
+	if err := RunMerge(io.Discard, merged, []string{indexFile}); err != nil {
+		t.Fatalf("RunMerge() failed: %v", err)
+	}
+	for _, path := range []string{indexFile, merged} {
+		indexData, err := loadIndexData(path)
+		if err != nil {
+			t.Fatalf("loadIndexData() failed: %v", err)
+		}
+		if indexData.MinHash == nil || len(indexData.MinHash.Postings) != 4 {
+			t.Fatalf("%s holds MinHash signatures %+v, want 4 chunks", path, indexData.MinHash)
+		}
+		if got := indexData.Similar([]byte(wordList("delta", 0, 40)), 0.9, 0); len(got) != 1 || got[0].Jaccard != 1 {
+			t.Errorf("Similar() in %s = %+v, want the appended paragraph", path, got)
+		}
+	}
+
+	// Indexes without signatures cannot be searched by Jaccard similarity or merged with ones that have them
+	plain := filepath.Join(dir, "plain.idx")
+	if err := RunIndex([]string{input}, plain, IndexOptions{ChunkSize: 400, Chunking: ChunkParagraphs}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if _, err := FuzzyJaccard(plain, "", query, "", 0.6, 0, QueryOptions{}); err == nil {
+		t.Error("Expected FuzzyJaccard() to reject an index without MinHash signatures, got nil")
+	}
+	if err := RunMerge(io.Discard, filepath.Join(dir, "mixed.idx"), []string{indexFile, plain}); err == nil {
+		t.Error("Expected RunMerge() to reject indexes with and without MinHash signatures, got nil")
+	}
+	opts.Layout = layoutSorted
+	if err := RunIndex([]string{input}, filepath.Join(dir, "sorted.idx"), opts); err == nil {
+		t.Error("Expected RunIndex() to reject MinHash signatures in the sorted layout, got nil")
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package internals
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile reads the whole of file into memory on platforms without mmap support.
+func mmapFile(file *os.File) ([]byte, func() error, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
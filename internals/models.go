@@ -1,40 +1,234 @@
 package internals
 
-// Index holds the mapping from SimHash values to byte offsets.
+import "time"
+
+// Document describes one source file that is part of an indexed corpus, along with
+// the fingerprint it had when it was last indexed: its size in bytes, modification
+// time, and the hex-encoded SHA-256 digest of its contents.
+type Document struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Digest  string
+}
+
+// Posting records where a chunk with a given SimHash occurs: the document it
+// came from, identified by its position in the document table, its byte offset,
+// and its length in bytes. Length is 0 in indexes written before chunk lengths
+// were recorded, in which case the chunk size of the index applies.
+type Posting struct {
+	DocID  int
+	Offset int64
+	Length int
+}
+
+// Index holds the mapping from SimHash fingerprints to postings.
 type Index struct {
-	m map[uint64][]int64
+	m map[Fingerprint][]Posting
 }
 
-// FileIndex represents the indexing structure with configurable chunk size and workers.
+// FileIndex represents the indexing structure with configurable chunking and workers.
+// bits is the width of the fingerprints, 64 when 0; idf is nil unless features are weighted by
+// tf·idf; signatures holds the MinHash signature of every posting when minHash is enabled, and
+// progress is nil unless the build reports its progress.
 type FileIndex struct {
 	chunkSize  int
+	chunking   chunkSpec
+	features   featureSpec
+	hashing    hashSpec
+	bits       int
+	idf        *idfTable
+	minHash    minHashSpec
+	signatures map[Posting][]uint64
 	index      *Index
 	numWorkers int
+	documents  []Document
+	progress   *progressTracker
+}
+
+// IndexHeader describes how an index was produced. It is stored at the start of every
+// index file so that a reader can tell whether it is able to interpret the fingerprints.
+// It contains the following fields:
+//   - Version: the on-disk format version the index was written with.
+//   - HashFamily: the feature hash function applied to each token.
+//   - HashKey: the key of a keyed HashFamily such as SipHash; empty for the other hashes.
+//   - Tokenizer: how chunk text is split into features, "whitespace" for words or "chars" for characters.
+//   - Weighting: how each feature's vote is weighted when the fingerprint is built, by its count
+//     (WeightingTF) or by tf·idf (WeightingTFIDF).
+//   - FingerprintBits: the width of each SimHash fingerprint in bits, FingerprintBits64,
+//     FingerprintBits128 or FingerprintBits256.
+//   - Similarity: the similarity the index was built for, SimilaritySimHash, or SimilarityMinHash
+//     when it also stores a MinHash signature of every chunk; empty in indexes written before
+//     MinHash signatures existed, which were built for SimHash.
+//   - MinHashPerms, MinHashBands: with SimilarityMinHash, the length of each signature and the number
+//     of LSH bands it is split into.
+//   - Created: when the index was first built.
+//   - Layout: how the index body is stored on disk, either "gob" or "sorted".
+//   - Chunking: how documents were cut into chunks, ChunkFixed, ChunkCDC, ChunkWords, ChunkSentences,
+//     ChunkParagraphs or ChunkLines; empty in indexes written before chunking was configurable,
+//     which used fixed chunks.
+//   - MinChunkSize, MaxChunkSize: the bounds on the length of a content-defined chunk.
+//   - LinesPerChunk: the number of lines in each ChunkLines chunk.
+//   - Stride: the distance in bytes between the starts of overlapping fixed chunks; 0 when chunks
+//     do not overlap.
+//   - ShingleMin, ShingleMax: the range of the number of words or characters in each feature; 0 in
+//     indexes written before shingles were configurable, which used single words.
+//   - Normalization: the comma-separated normalization steps applied to the text before it is split
+//     into features; empty when the text was hashed as it is.
+//   - StopWords, CustomStopWords: the comma-separated languages whose built-in stop words, and the
+//     custom stop words, that were removed before features were built; empty when none were.
+//   - Stemmer: the stemmer the remaining words were reduced with; empty when they were not stemmed.
+//   - DocCount, DocFreqs: with WeightingTFIDF, the number of chunks in the corpus and, for the hash
+//     of every feature, the number of chunks containing it; query text is weighted with them too.
+//     The sorted layout stores DocFreqs in its body, so they are empty in the header it writes.
+type IndexHeader struct {
+	Version         int
+	HashFamily      string
+	HashKey         []byte
+	Tokenizer       string
+	Weighting       string
+	FingerprintBits int
+	Similarity      string
+	MinHashPerms    int
+	MinHashBands    int
+	Created         time.Time
+	Layout          string
+	Chunking        string
+	MinChunkSize    int
+	MaxChunkSize    int
+	LinesPerChunk   int
+	Stride          int
+	ShingleMin      int
+	ShingleMax      int
+	Normalization   string
+	StopWords       string
+	CustomStopWords []string
+	Stemmer         string
+	DocCount        int
+	DocFreqs        map[uint64]int
+}
+
+// IndexOptions holds the settings chosen when an index is built.
+//   - ChunkSize: the size of each chunk in bytes; the average size for content-defined chunking,
+//     and the largest size for text-aligned chunking.
+//   - Chunking: how documents are cut into chunks, ChunkFixed (the default when empty), ChunkCDC,
+//     or one of the text-aligned ChunkWords, ChunkSentences, ChunkParagraphs and ChunkLines.
+//   - MinChunkSize, MaxChunkSize: the bounds on the length of a content-defined chunk; a quarter
+//     and four times ChunkSize when 0.
+//   - LinesPerChunk: the number of lines in each ChunkLines chunk; 1 when 0.
+//   - Stride: the distance in bytes between the starts of consecutive fixed chunks, so that chunks
+//     of ChunkSize bytes overlap when it is smaller; ChunkSize when 0.
+//   - Features: the SimHash features of each chunk, FeaturesWords or FeaturesChars, optionally
+//     followed by a shingle size or range such as "words:1-3" or "chars:4"; single words when empty.
+//   - Normalize: the comma-separated normalization steps applied to the text before it is split into
+//     features, from NormalizeNFKC, NormalizeFold, NormalizePunct and NormalizeDigits, or
+//     NormalizeAll; none when empty.
+//   - StopWords: the comma-separated languages, such as "english,german", whose built-in stop words
+//     are removed before features are built; none when empty.
+//   - StopWordsFile: the path to a file of custom stop words to remove; none when empty.
+//   - Stemmer: StemPorter to reduce words to their English stems; no stemming when empty.
+//   - Hash: the function features are hashed with, HashFNV1a (the default when empty),
+//     HashXXHash64, HashMurmur3 or the keyed HashSipHash.
+//   - HashKey: the SipHash key as 32 hexadecimal digits; a random key when empty. The key is
+//     recorded in the index, so that query text is hashed with it too.
+//   - FingerprintBits: the width of each SimHash fingerprint, FingerprintBits64 (the default when 0),
+//     FingerprintBits128 or FingerprintBits256. Wider fingerprints make unrelated chunks less likely
+//     to look alike in large corpora, at the cost of larger indexes.
+//   - Weighting: how features vote on the bits of a SimHash, WeightingTF (the default when empty)
+//     by their counts, or WeightingTFIDF by tf·idf, which makes a first pass over the corpus to
+//     count the chunks each feature occurs in.
+//   - Similarity: SimilaritySimHash (the default when empty), or SimilarityMinHash to also store a
+//     MinHash signature of the set of features of every chunk, for searches by Jaccard similarity.
+//     MinHash signatures require the "gob" layout.
+//   - MinHashPerms: the length of each MinHash signature; DefaultMinHashPerms when 0.
+//   - MinHashBands: the number of LSH bands each signature is split into, which must divide
+//     MinHashPerms; bands of 4 rows when 0.
+//   - Layout: the on-disk layout, "gob" (the default when empty) or "sorted".
+//   - DumpFile: the path of the human-readable dump; no dump is written when empty.
+//   - DumpOrder: the order of the dump, DumpByHash (the default when empty) or DumpByOffset.
+//   - DumpFormat: the format of the dump, FormatText (the default when empty), FormatJSON,
+//     FormatNDJSON or FormatCSV.
+//   - Progress: called periodically while chunks are hashed, and once when the build ends; may be nil.
+type IndexOptions struct {
+	ChunkSize       int
+	Chunking        string
+	MinChunkSize    int
+	MaxChunkSize    int
+	LinesPerChunk   int
+	Stride          int
+	Features        string
+	Normalize       string
+	StopWords       string
+	StopWordsFile   string
+	Stemmer         string
+	Hash            string
+	HashKey         string
+	FingerprintBits int
+	Weighting       string
+	Similarity      string
+	MinHashPerms    int
+	MinHashBands    int
+	Layout          string
+	DumpFile        string
+	DumpOrder       string
+	DumpFormat      string
+	Progress        func(Progress)
 }
 
 // IndexData represents the structure for storing index information.
 // It contains the following fields:
-//   - FileName: the name of the file being indexed.
+//   - Header: the hashing parameters and format version the index was built with.
+//   - Documents: the document table listing every file in the corpus; a posting's DocID
+//     is an index into this slice.
 //   - ChunkSize: the size of each chunk in the file.
-//   - Index: a map where the key is a uint64 representing the chunk identifier,
-//     and the value is a slice of int64 representing the positions within the chunk.
+//   - Index: a map where the key is the SimHash fingerprint of a chunk, as wide as the header says,
+//     and the value is a slice of postings locating each chunk with that fingerprint.
+//   - Tables: the permutation tables used to speed up fuzzy search; nil for indexes built
+//     before the tables were introduced, in which case fuzzy search scans every key.
+//   - MinHash: the MinHash signatures and LSH bands of every chunk; nil unless the index was built
+//     for SimilarityMinHash.
 type IndexData struct {
-	FileName  string
+	Header    IndexHeader
+	Documents []Document
 	ChunkSize int
-	Index     map[uint64][]int64
+	Index     map[Fingerprint][]Posting
+	Tables    *PermutationIndex
+	MinHash   *MinHashIndex
+}
+
+// QueryOptions holds the settings shared by the lookup and fuzzy commands.
+//   - Hash: the feature hash the queried SimHash was computed with, such as HashXXHash64; when
+//     set, indexes built with another hash are rejected, as their fingerprints are not comparable.
+//   - Stale: what to do when a document changed after it was indexed: StaleWarn (the
+//     default when empty), StaleFail or StaleIgnore.
+//   - Format: how results are printed: FormatText (the default when empty), FormatJSON,
+//     FormatNDJSON or FormatCSV.
+type QueryOptions struct {
+	Hash   string
+	Stale  string
+	Format string
+}
+
+// staleOrDefault returns the stale policy, defaulting to StaleWarn.
+func (o QueryOptions) staleOrDefault() string {
+	if o.Stale == "" {
+		return StaleWarn
+	}
+	return o.Stale
 }
 
 // NewIndex creates a new Index instance.
 func NewIndex() *Index {
-	return &Index{m: make(map[uint64][]int64)}
+	return &Index{m: make(map[Fingerprint][]Posting)}
 }
 
-// Lookup retrieves the byte offsets for a given SimHash.
-func (idx *Index) Lookup(hash uint64) []int64 {
+// Lookup retrieves the postings for a given SimHash fingerprint.
+func (idx *Index) Lookup(hash Fingerprint) []Posting {
 	return idx.m[hash]
 }
 
 // NewFileIndex initializes a new FileIndex with specified chunk size and number of workers.
+// Documents are cut into fixed-size chunks.
 func NewFileIndex(chunkSize, numWorkers int) *FileIndex {
 	return &FileIndex{
 		chunkSize:  chunkSize,
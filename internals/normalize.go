@@ -0,0 +1,133 @@
+package internals
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The steps of the normalization pipeline, by the names the index command accepts for them.
+const (
+	NormalizeNFKC   = "nfkc"
+	NormalizeFold   = "fold"
+	NormalizePunct  = "punct"
+	NormalizeDigits = "digits"
+	NormalizeAll    = "all"
+	NormalizeNone   = "none"
+)
+
+// normalization is a set of normalization steps. The steps always run in the order of
+// normalizeSteps, whatever order they were chosen in.
+type normalization uint8
+
+const (
+	normNFKC normalization = 1 << iota
+	normFold
+	normPunct
+	normDigits
+)
+
+// normalizeSteps lists the steps in the order they are applied.
+var normalizeSteps = []struct {
+	name string
+	step normalization
+}{
+	{NormalizeNFKC, normNFKC},
+	{NormalizeFold, normFold},
+	{NormalizePunct, normPunct},
+	{NormalizeDigits, normDigits},
+}
+
+// parseNormalization parses a comma-separated list of normalization steps. "all" selects every
+// step, and an empty list or "none" selects none.
+//
+// Parameters:
+//   - s: The list of steps.
+//
+// Returns:
+//   - normalization: The selected steps.
+//   - error: An error if the list names an unknown step.
+func parseNormalization(s string) (normalization, error) {
+	var n normalization
+	if s == "" || s == NormalizeNone {
+		return n, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == NormalizeAll {
+			for _, st := range normalizeSteps {
+				n |= st.step
+			}
+			continue
+		}
+		found := false
+		for _, st := range normalizeSteps {
+			if st.name == name {
+				n |= st.step
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown normalization %q, must be a comma-separated list of %q, %q, %q and %q, or %q",
+				name, NormalizeNFKC, NormalizeFold, NormalizePunct, NormalizeDigits, NormalizeAll)
+		}
+	}
+	return n, nil
+}
+
+// String lists the steps in the order they are applied, the way parseNormalization accepts them;
+// it is empty when there are none.
+func (n normalization) String() string {
+	var names []string
+	for _, st := range normalizeSteps {
+		if n&st.step != 0 {
+			names = append(names, st.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// apply runs the selected steps over text:
+//   - nfkc: applies Unicode NFKC, so that compatibility characters such as fullwidth letters,
+//     ligatures and circled digits become their plain equivalents, and combining accents are
+//     composed with the letters before them.
+//   - fold: folds case, so that "HELLO" and "hello" are the same.
+//   - punct: removes punctuation, so that "hello," and "hello" are the same.
+//   - digits: replaces every decimal digit, in any script, with 0, so that numbers only count by
+//     their number of digits.
+//
+// Whitespace needs no step of its own, since features are split at any run of it.
+func (n normalization) apply(text string) string {
+	if n == 0 {
+		return text
+	}
+	if n&normNFKC != 0 {
+		text = norm.NFKC.String(text)
+	}
+
+	var b strings.Builder
+	b.Grow(len(text))
+	emit := func(r rune) {
+		if n&normPunct != 0 && unicode.IsPunct(r) {
+			return
+		}
+		if n&normDigits != 0 && unicode.IsDigit(r) {
+			r = '0'
+		}
+		b.WriteRune(r)
+	}
+	for _, r := range text {
+		if n&normFold == 0 {
+			emit(r)
+		} else if r == 'ß' || r == 'ẞ' {
+			// The one common character whose case folding is longer than itself
+			emit('s')
+			emit('s')
+		} else {
+			emit(unicode.ToLower(unicode.ToUpper(r)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package internals
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseNormalization checks the accepted step lists and that the steps are named in the order
+// they are applied.
+func TestParseNormalization(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"none", "", false},
+		{"fold", "fold", false},
+		{"digits, punct,fold", "fold,punct,digits", false},
+		{"all", "nfkc,fold,punct,digits", false},
+		{"compat", "", true},
+		{"fold,case", "", true},
+		{"space", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseNormalization(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNormalization(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("parseNormalization(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNormalization_Apply checks each normalization step on its own and the full pipeline.
+func TestNormalization_Apply(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		in    string
+		want  string
+	}{
+		{"None", "", "Hello,  World!", "Hello,  World!"},
+		{"Fullwidth", "nfkc", "ＨＥＬＬＯ　１２３", "HELLO 123"},
+		{"Ligatures", "nfkc", "ﬁne ﬂour", "fine flour"},
+		{"Numerals", "nfkc", "x² ③ Ⅻ", "x2 3 XII"},
+		{"Combining accent", "nfkc", "cafe\u0301", "caf\u00e9"},
+		{"Accent after fullwidth letter", "nfkc", "ｅ\u0301", "\u00e9"},
+		{"Accent without precomposed letter", "nfkc", "q\u0301", "q\u0301"},
+		{"Hangul jamo", "nfkc", "\u1112\u1161\u11ab", "한"},
+		{"Square era name", "nfkc", "㍻", "平成"},
+		{"Fold", "fold", "HeLLo Straße", "hello strasse"},
+		{"Punctuation", "punct", "hello, world! (really)", "hello world really"},
+		{"Digits", "digits", "call 555-1234", "call 000-0000"},
+		{"All", "all", " ＨＥＬＬＯ, Wörld̈ — 2024! ", " hello wörld̈  0000 "},
+		{"All equal forms", "all", "Hello,  hello!  HELLO", "hello  hello  hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseNormalization(tt.steps)
+			if err != nil {
+				t.Fatalf("parseNormalization(%q) failed: %v", tt.steps, err)
+			}
+			if got := n.apply(tt.in); got != tt.want {
+				t.Errorf("apply(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunIndex_Normalize verifies that query text differing from the indexed text only finds it
+// when the recorded normalization steps remove the difference.
+func TestRunIndex_Normalize(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	text := "The quick brown fox, jumps over the lazy dog."
+	os.WriteFile(input, []byte(text), 0644)
+
+	tests := []struct {
+		steps string
+		query string
+		found bool
+	}{
+		{"", "the quick brown fox, jumps over the lazy dog.", false},
+		{"fold", "the quick brown fox, jumps over the lazy dog.", true},
+		{"fold", "The quick brown fox jumps over the lazy dog", false},
+		{"punct,fold", "the quick brown fox jumps over the lazy dog", true},
+		{"punct,fold", "THE QUICK BROWN ＦＯＸ jumps over the lazy dog", false},
+		{"all", "THE QUICK BROWN ＦＯＸ jumps   over the lazy dog", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.steps+"/"+tt.query, func(t *testing.T) {
+			indexFile := filepath.Join(dir, "input.idx")
+			if err := RunIndex([]string{input}, indexFile, IndexOptions{ChunkSize: 4096, Normalize: tt.steps}); err != nil {
+				t.Fatalf("RunIndex() failed: %v", err)
+			}
+			query, err := ResolveQuery(indexFile, "", tt.query, "")
+			if err != nil {
+				t.Fatalf("ResolveQuery() failed: %v", err)
+			}
+			results, err := Lookup(indexFile, query, QueryOptions{})
+			if (err == nil) != tt.found {
+				t.Fatalf("Lookup() error = %v, want the text found = %v", err, tt.found)
+			}
+			if tt.found && (len(results) != 1 || results[0].Offset != 0 || results[0].Length != len(text)) {
+				t.Errorf("Lookup() = %+v, want the whole text", results)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package internals
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The output formats supported by the lookup, fuzzy and index commands.
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Result is a single chunk reported by a lookup, a fuzzy search or an index dump.
+// It contains the following fields:
+//   - File: the path of the document the chunk came from.
+//   - SimHash: the SimHash fingerprint the chunk is indexed under.
+//   - Offset: the byte offset of the chunk within File.
+//   - Distance: the Hamming distance between SimHash and the query; 0 for lookups, dumps and
+//     Jaccard searches.
+//   - Length: the length of the chunk in bytes.
+//   - Phrase: a short excerpt of the chunk's text; empty in dumps, which do not read the documents.
+//   - Mismatches: in a Jaccard search, the number of MinHash signature values that differ from the
+//     query's; 0 otherwise.
+//   - Jaccard: in a Jaccard search, the Jaccard similarity between the features of the chunk and
+//     those of the query, estimated from their MinHash signatures; 0 otherwise.
+//
+// When overlapping chunks are collapsed into a region, Offset and Length span the whole region,
+// and SimHash, Distance, Mismatches and Jaccard are those of its closest chunk.
+type Result struct {
+	File       string
+	SimHash    Fingerprint
+	Offset     int64
+	Distance   int
+	Length     int
+	Phrase     string
+	Mismatches int
+	Jaccard    float64
+}
+
+// resultRecord is the JSON encoding of a Result. The SimHash is written in hexadecimal, the
+// same way it is printed in text output and accepted by the -h option.
+type resultRecord struct {
+	File     string `json:"file"`
+	SimHash  string `json:"simhash"`
+	Offset   int64  `json:"offset"`
+	End      int64  `json:"end"`
+	Distance int    `json:"distance"`
+	Length   int    `json:"length"`
+	Phrase   string `json:"phrase"`
+}
+
+// jaccardRecord is the JSON encoding of a Result of a Jaccard search, which has the number of
+// mismatched MinHash values and the Jaccard similarity instead of a distance, so that neither is
+// mistaken for a Hamming distance.
+type jaccardRecord struct {
+	File       string  `json:"file"`
+	SimHash    string  `json:"simhash"`
+	Offset     int64   `json:"offset"`
+	End        int64   `json:"end"`
+	Length     int     `json:"length"`
+	Phrase     string  `json:"phrase"`
+	Mismatches int     `json:"mismatches"`
+	Jaccard    float64 `json:"jaccard"`
+}
+
+// csvHeader and jaccardCSVHeader name the columns written by the csv format, in the order of
+// resultRecord and jaccardRecord.
+var (
+	csvHeader        = []string{"file", "simhash", "offset", "end", "distance", "length", "phrase"}
+	jaccardCSVHeader = []string{"file", "simhash", "offset", "end", "length", "phrase", "mismatches", "jaccard"}
+)
+
+// parseFormat validates an output format name, defaulting to FormatText when empty.
+func parseFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatText, nil
+	case FormatText, FormatJSON, FormatNDJSON, FormatCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid format %q, must be %q, %q, %q or %q", format, FormatText, FormatJSON, FormatNDJSON, FormatCSV)
+}
+
+// End returns the byte offset just past the chunk or region within File.
+func (r Result) End() int64 {
+	return r.Offset + int64(r.Length)
+}
+
+func (r Result) record() resultRecord {
+	return resultRecord{
+		File:     r.File,
+		SimHash:  r.SimHash.String(),
+		Offset:   r.Offset,
+		End:      r.End(),
+		Distance: r.Distance,
+		Length:   r.Length,
+		Phrase:   r.Phrase,
+	}
+}
+
+func (r Result) jaccardRecord() jaccardRecord {
+	return jaccardRecord{
+		File:       r.File,
+		SimHash:    r.SimHash.String(),
+		Offset:     r.Offset,
+		End:        r.End(),
+		Length:     r.Length,
+		Phrase:     r.Phrase,
+		Mismatches: r.Mismatches,
+		Jaccard:    r.Jaccard,
+	}
+}
+
+// encoding returns the JSON encoding of r, as the result of a Jaccard search when jaccard is set.
+func (r Result) encoding(jaccard bool) any {
+	if jaccard {
+		return r.jaccardRecord()
+	}
+	return r.record()
+}
+
+// writeResults renders results to w in the given format. The text format is specific to each
+// command, so it is delegated to text; the structured formats share one schema.
+//
+// Parameters:
+//   - w: The writer the results are rendered to.
+//   - format: One of FormatText, FormatJSON, FormatNDJSON or FormatCSV; empty means FormatText.
+//   - results: The results to render.
+//   - text: Renders results in the command's human-readable layout.
+//
+// Returns:
+//   - error: An error if the format is unknown or writing fails, otherwise nil.
+func writeResults(w io.Writer, format string, results []Result, text func(io.Writer, []Result) error) error {
+	return writeRecords(w, format, results, text, false)
+}
+
+// writeJaccardResults renders the results of a Jaccard search to w like writeResults, writing
+// their mismatches and Jaccard similarity instead of their distance.
+func writeJaccardResults(w io.Writer, format string, results []Result, text func(io.Writer, []Result) error) error {
+	return writeRecords(w, format, results, text, true)
+}
+
+// writeRecords renders results to w in the given format, as the results of a Jaccard search when
+// jaccard is set.
+func writeRecords(w io.Writer, format string, results []Result, text func(io.Writer, []Result) error, jaccard bool) error {
+	format, err := parseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatJSON:
+		records := make([]any, len(results))
+		for i, r := range results {
+			records[i] = r.encoding(jaccard)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, r := range results {
+			if err := enc.Encode(r.encoding(jaccard)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if jaccard {
+			cw.Write(jaccardCSVHeader)
+		} else {
+			cw.Write(csvHeader)
+		}
+		for _, r := range results {
+			if jaccard {
+				rec := r.jaccardRecord()
+				cw.Write([]string{rec.File, rec.SimHash, strconv.FormatInt(rec.Offset, 10), strconv.FormatInt(rec.End, 10), strconv.Itoa(rec.Length), rec.Phrase,
+					strconv.Itoa(rec.Mismatches), strconv.FormatFloat(rec.Jaccard, 'f', -1, 64)})
+				continue
+			}
+			rec := r.record()
+			cw.Write([]string{rec.File, rec.SimHash, strconv.FormatInt(rec.Offset, 10), strconv.FormatInt(rec.End, 10), strconv.Itoa(rec.Distance), strconv.Itoa(rec.Length), rec.Phrase})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return text(w, results)
+	}
+}
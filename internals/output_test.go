@@ -0,0 +1,125 @@
+package internals
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestWriteResults checks that each structured format carries every field of a result.
+func TestWriteResults(t *testing.T) {
+	results := []Result{
+		{File: "a.txt", SimHash: Fingerprint{0xabc}, Offset: 16, Distance: 2, Length: 8, Phrase: "hello, world"},
+		{File: "b.txt", SimHash: Fingerprint{0x1}, Offset: 0, Length: 4, Phrase: `say "hi"`},
+	}
+	want := []resultRecord{
+		{"a.txt", "abc", 16, 24, 2, 8, "hello, world"},
+		{"b.txt", "1", 0, 4, 0, 4, `say "hi"`},
+	}
+	noText := func(io.Writer, []Result) error {
+		t.Fatal("text renderer called for a structured format")
+		return nil
+	}
+
+	t.Run(FormatJSON, func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, FormatJSON, results, noText); err != nil {
+			t.Fatalf("writeResults() failed: %v", err)
+		}
+		var got []resultRecord
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("Output is not a JSON array: %v", err)
+		}
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("JSON records = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run(FormatNDJSON, func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, FormatNDJSON, results, noText); err != nil {
+			t.Fatalf("writeResults() failed: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != len(want) {
+			t.Fatalf("NDJSON has %d lines, want %d", len(lines), len(want))
+		}
+		for i, line := range lines {
+			var got resultRecord
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("Line %d is not a JSON object: %v", i, err)
+			}
+			if got != want[i] {
+				t.Errorf("Line %d = %+v, want %+v", i, got, want[i])
+			}
+		}
+	})
+
+	t.Run(FormatCSV, func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, FormatCSV, results, noText); err != nil {
+			t.Fatalf("writeResults() failed: %v", err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("Output is not valid CSV: %v", err)
+		}
+		wantRows := [][]string{
+			csvHeader,
+			{"a.txt", "abc", "16", "24", "2", "8", "hello, world"},
+			{"b.txt", "1", "0", "4", "0", "4", `say "hi"`},
+		}
+		if len(rows) != len(wantRows) {
+			t.Fatalf("CSV has %d rows, want %d", len(rows), len(wantRows))
+		}
+		for i := range rows {
+			if strings.Join(rows[i], "|") != strings.Join(wantRows[i], "|") {
+				t.Errorf("Row %d = %q, want %q", i, rows[i], wantRows[i])
+			}
+		}
+	})
+
+	t.Run("Jaccard", func(t *testing.T) {
+		jaccardResults := []Result{{File: "a.txt", SimHash: Fingerprint{0xabc}, Length: 8, Phrase: "hi", Mismatches: 32, Jaccard: 0.75}}
+		var buf bytes.Buffer
+		if err := writeJaccardResults(&buf, FormatCSV, jaccardResults, noText); err != nil {
+			t.Fatalf("writeJaccardResults() failed: %v", err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		wantRows := [][]string{jaccardCSVHeader, {"a.txt", "abc", "0", "8", "8", "hi", "32", "0.75"}}
+		if err != nil || len(rows) != 2 || strings.Join(rows[0], "|") != strings.Join(wantRows[0], "|") || strings.Join(rows[1], "|") != strings.Join(wantRows[1], "|") {
+			t.Errorf("Jaccard CSV = %q, %v, want %q", rows, err, wantRows)
+		}
+
+		buf.Reset()
+		if err := writeJaccardResults(&buf, FormatNDJSON, jaccardResults, noText); err != nil {
+			t.Fatalf("writeJaccardResults() failed: %v", err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("Output is not a JSON object: %v", err)
+		}
+		if _, ok := got["distance"]; ok || got["mismatches"] != 32.0 || got["jaccard"] != 0.75 {
+			t.Errorf("Jaccard NDJSON = %s, want mismatches and jaccard fields without a distance", buf.String())
+		}
+	})
+
+	t.Run("Empty JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, FormatJSON, nil, noText); err != nil {
+			t.Fatalf("writeResults() failed: %v", err)
+		}
+		if strings.TrimSpace(buf.String()) != "[]" {
+			t.Errorf("Empty JSON output = %q, want []", buf.String())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := writeResults(io.Discard, "xml", results, noText); err == nil {
+			t.Error("Expected error for invalid format, got nil")
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package internals
+
+import (
+	"sort"
+)
+
+// defaultPermutationBlocks is the number of blocks each 64 bits of a SimHash are split into when
+// building the permutation tables. With 4 blocks per 64 bits, any query on a 64-bit index with a
+// Hamming distance of up to 3 is answered from the tables without scanning the whole index, and
+// on a 256-bit index any query with a distance of up to 15.
+const defaultPermutationBlocks = 4
+
+// PermutationIndex is a multi-table index over SimHash fingerprints for sub-linear fuzzy search,
+// following the approach described by Manku, Jain and Das Sarma.
+//
+// The Bits bits of a fingerprint are split into Blocks contiguous blocks. Keys lists every
+// indexed fingerprint in ascending order, and table i lists the positions of the keys ordered by
+// the value of their block i. By the pigeonhole principle, two fingerprints within Hamming
+// distance k < Blocks agree exactly on at least one block, so a query only needs to scan the
+// entries of each table that share the query's block.
+type PermutationIndex struct {
+	Blocks int
+	Bits   int
+	Keys   []Fingerprint
+	Tables [][]uint32
+}
+
+// NewPermutationIndex builds the permutation tables for every fingerprint in index.
+//
+// Parameters:
+//   - index: The map of SimHash fingerprints to postings.
+//   - bits: The width of the fingerprints in bits.
+//   - blocks: The number of blocks to split each fingerprint into, between bits/64 and bits, so
+//     that no block is wider than 64 bits.
+//
+// Returns:
+//   - *PermutationIndex: The populated permutation index.
+func NewPermutationIndex(index map[Fingerprint][]Posting, bits, blocks int) *PermutationIndex {
+	p := &PermutationIndex{
+		Blocks: blocks,
+		Bits:   bits,
+		Keys:   make([]Fingerprint, 0, len(index)),
+		Tables: make([][]uint32, blocks),
+	}
+	for hash := range index {
+		p.Keys = append(p.Keys, hash)
+	}
+	sort.Slice(p.Keys, func(a, b int) bool { return p.Keys[a].less(p.Keys[b]) })
+
+	for i := 0; i < blocks; i++ {
+		start, width := p.block(i)
+		table := make([]uint32, len(p.Keys))
+		for j := range table {
+			table[j] = uint32(j)
+		}
+		// Keys sharing a block stay in ascending order, so the tables are deterministic
+		sort.Slice(table, func(a, b int) bool {
+			ka, kb := p.Keys[table[a]].bitsAt(start, width), p.Keys[table[b]].bitsAt(start, width)
+			if ka != kb {
+				return ka < kb
+			}
+			return table[a] < table[b]
+		})
+		p.Tables[i] = table
+	}
+	return p
+}
+
+// newDefaultPermutationIndex builds the permutation tables saved with an index of fingerprints
+// of the given width, with defaultPermutationBlocks blocks per 64 bits.
+func newDefaultPermutationIndex(index map[Fingerprint][]Posting, bits int) *PermutationIndex {
+	return NewPermutationIndex(index, bits, defaultPermutationBlocks*bits/64)
+}
+
+// block returns the position of the lowest bit of block i and its width.
+func (p *PermutationIndex) block(i int) (start, width int) {
+	return permutationBlock(i, p.Bits, p.Blocks)
+}
+
+// permutationBlock returns the position of the lowest bit of block i of a fingerprint of the
+// given width split into the given number of blocks, and the width of the block.
+func permutationBlock(i, bits, blocks int) (start, width int) {
+	start = i * bits / blocks
+	end := (i + 1) * bits / blocks
+	return start, end - start
+}
+
+// Supports reports whether the tables can answer a query with the given maximum distance.
+func (p *PermutationIndex) Supports(maxDistance int) bool {
+	return p != nil && p.Blocks > 0 && maxDistance < p.Blocks && len(p.Tables) == p.Blocks
+}
+
+// nearbyHashes returns every indexed fingerprint within maxDistance of simHash, together with its
+// distance. It must only be called when Supports(maxDistance) is true.
+func (p *PermutationIndex) nearbyHashes(simHash Fingerprint, maxDistance int) map[Fingerprint]int {
+	found := make(map[Fingerprint]int)
+	for i, table := range p.Tables {
+		start, width := p.block(i)
+		prefix := simHash.bitsAt(start, width)
+
+		// Binary search for the first entry sharing the query's block
+		lo := sort.Search(len(table), func(j int) bool { return p.Keys[table[j]].bitsAt(start, width) >= prefix })
+		for j := lo; j < len(table) && p.Keys[table[j]].bitsAt(start, width) == prefix; j++ {
+			hash := p.Keys[table[j]]
+			if _, seen := found[hash]; seen {
+				continue
+			}
+			if distance := simHash.distance(hash); distance <= maxDistance {
+				found[hash] = distance
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,110 @@
+package internals
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomIndex builds an index of n random fingerprints of the given width with a single posting each.
+func randomIndex(n int, seed int64, width int) map[Fingerprint][]Posting {
+	r := rand.New(rand.NewSource(seed))
+	index := make(map[Fingerprint][]Posting, n)
+	for i := 0; i < n; i++ {
+		var hash Fingerprint
+		for w := 0; w < width/64; w++ {
+			hash[w] = r.Uint64()
+		}
+		index[hash] = []Posting{{Offset: int64(i) * 4096}}
+	}
+	return index
+}
+
+// TestPermutationIndex_MatchesScan verifies that the permutation tables return exactly the
+// same neighbours as a full linear scan for every distance they support.
+func TestPermutationIndex_MatchesScan(t *testing.T) {
+	for _, width := range []int{FingerprintBits64, FingerprintBits256} {
+		index := randomIndex(2000, 1, width)
+
+		// Plant near neighbours of a query at known distances, some across word boundaries
+		query := Fingerprint{0x0123456789abcdef}
+		if width == FingerprintBits256 {
+			query = Fingerprint{0x0123456789abcdef, 0xfedcba9876543210, 0x1, 0x8000000000000000}
+		}
+		near := []Fingerprint{query, query, query, query}
+		near[1][0] ^= 0x1
+		near[2][0] ^= 0x8000000000000001
+		near[3][0] ^= 0x0000100000010001
+		if width == FingerprintBits256 {
+			near[2][0] ^= 0x8000000000000000
+			near[2][1] ^= 0x1
+			near[3][3] ^= 0x10
+		}
+		for i, hash := range near {
+			index[hash] = []Posting{{Offset: int64(i) + 1}}
+		}
+
+		for _, blocks := range []int{width / 64, width/64 + 2, 4 * width / 64, 6 * width / 64} {
+			tables := NewPermutationIndex(index, width, blocks)
+			for d := 0; d < blocks && d <= 8; d++ {
+				want := findNearby(index, nil, query, d, 0)
+				got := findNearby(index, tables, query, d, 0)
+				if len(got) != len(want) {
+					t.Fatalf("width=%d blocks=%d distance=%d: got %d matches, want %d", width, blocks, d, len(got), len(want))
+				}
+				for i := range got {
+					if got[i] != want[i] {
+						t.Errorf("width=%d blocks=%d distance=%d: match %d = %+v, want %+v", width, blocks, d, i, got[i], want[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestPermutationIndex_Supports checks which distances the tables can answer.
+func TestPermutationIndex_Supports(t *testing.T) {
+	var nilTables *PermutationIndex
+	if nilTables.Supports(0) {
+		t.Error("nil tables should not support any distance")
+	}
+
+	tables := NewPermutationIndex(map[Fingerprint][]Posting{{1}: {{}}}, FingerprintBits64, 4)
+	tests := []struct {
+		distance int
+		want     bool
+	}{
+		{0, true},
+		{3, true},
+		{4, false},
+		{64, false},
+	}
+	for _, tt := range tests {
+		if got := tables.Supports(tt.distance); got != tt.want {
+			t.Errorf("Supports(%d) = %v, want %v", tt.distance, got, tt.want)
+		}
+	}
+}
+
+func benchmarkFindNearby(b *testing.B, useTables bool) {
+	index := randomIndex(500000, 2, FingerprintBits64)
+	var tables *PermutationIndex
+	if useTables {
+		tables = newDefaultPermutationIndex(index, FingerprintBits64)
+	}
+	queries := randomIndex(64, 3, FingerprintBits64)
+	keys := make([]Fingerprint, 0, len(queries))
+	for q := range queries {
+		keys = append(keys, q)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findNearby(index, tables, keys[i%len(keys)], 3, 0)
+	}
+}
+
+// BenchmarkFindNearby_Scan measures fuzzy search with a linear scan over every key.
+func BenchmarkFindNearby_Scan(b *testing.B) { benchmarkFindNearby(b, false) }
+
+// BenchmarkFindNearby_Permuted measures fuzzy search using the permutation tables.
+func BenchmarkFindNearby_Permuted(b *testing.B) { benchmarkFindNearby(b, true) }
@@ -0,0 +1,136 @@
+package internals
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum time between two progress reports.
+const progressInterval = 100 * time.Millisecond
+
+// Progress describes how far an index build has got.
+// It contains the following fields:
+//   - Document: the path of the document being indexed.
+//   - BytesDone: the number of bytes hashed so far, across all documents.
+//   - BytesTotal: the number of bytes to hash in total.
+//   - Chunks: the number of chunks hashed so far.
+//   - ChunksPerSec: the average hashing rate since the build started.
+//   - ETA: the estimated time until the build finishes; 0 until a rate is known.
+type Progress struct {
+	Document     string
+	BytesDone    int64
+	BytesTotal   int64
+	Chunks       int64
+	ChunksPerSec float64
+	ETA          time.Duration
+}
+
+// progressTracker accumulates the chunks hashed by a build and reports them to a callback,
+// at most once per progressInterval.
+type progressTracker struct {
+	fn       func(Progress)
+	total    int64
+	start    time.Time
+	last     time.Time
+	done     int64
+	chunks   int64
+	document string
+}
+
+// newProgressTracker returns a tracker reporting to fn, or nil if fn is nil.
+func newProgressTracker(fn func(Progress), total int64) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	now := time.Now()
+	return &progressTracker{fn: fn, total: total, start: now, last: now}
+}
+
+// startDocument records the document whose chunks are reported next.
+func (t *progressTracker) startDocument(path string) {
+	if t != nil {
+		t.document = path
+	}
+}
+
+// add records a hashed chunk of n bytes and reports progress if enough time has passed.
+func (t *progressTracker) add(n int) {
+	if t == nil {
+		return
+	}
+	t.done += int64(n)
+	t.chunks++
+	if now := time.Now(); now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.report(now)
+	}
+}
+
+// flush reports the current progress regardless of when it was last reported.
+func (t *progressTracker) flush() {
+	if t != nil {
+		t.report(time.Now())
+	}
+}
+
+func (t *progressTracker) report(now time.Time) {
+	p := Progress{Document: t.document, BytesDone: t.done, BytesTotal: t.total, Chunks: t.chunks}
+	if elapsed := now.Sub(t.start).Seconds(); elapsed > 0 {
+		p.ChunksPerSec = float64(t.chunks) / elapsed
+		if t.done > 0 && t.total > t.done {
+			p.ETA = time.Duration(float64(t.total-t.done) / (float64(t.done) / elapsed) * float64(time.Second))
+		}
+	}
+	t.fn(p)
+}
+
+// NewProgressBar returns a progress callback that draws a single-line progress bar on w,
+// redrawing it in place with a carriage return and ending the line once every byte is done.
+func NewProgressBar(w io.Writer) func(Progress) {
+	const width = 30
+	var mu sync.Mutex
+	finished := false
+	return func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if finished {
+			return
+		}
+
+		fraction := 1.0
+		if p.BytesTotal > 0 {
+			fraction = min(float64(p.BytesDone)/float64(p.BytesTotal), 1)
+		}
+		filled := int(fraction * width)
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+
+		eta := "--"
+		if p.ETA > 0 {
+			eta = p.ETA.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "\r[%s] %3.0f%% %s/%s %.0f chunks/s ETA %s ", bar, fraction*100,
+			formatBytes(p.BytesDone), formatBytes(p.BytesTotal), p.ChunksPerSec, eta)
+
+		if p.BytesDone >= p.BytesTotal {
+			fmt.Fprintln(w)
+			finished = true
+		}
+	}
+}
+
+// formatBytes formats a byte count with a binary unit, such as 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
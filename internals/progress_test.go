@@ -0,0 +1,41 @@
+package internals
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewProgressBar checks that the bar is redrawn in place and ends its line when complete.
+func TestNewProgressBar(t *testing.T) {
+	var buf bytes.Buffer
+	bar := NewProgressBar(&buf)
+	bar(Progress{BytesDone: 512, BytesTotal: 2048, ChunksPerSec: 10, ETA: 3 * time.Second})
+	bar(Progress{BytesDone: 2048, BytesTotal: 2048})
+	bar(Progress{BytesDone: 2048, BytesTotal: 2048})
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "\r[=======") || !strings.Contains(out, " 25% 512 B/2.0 KiB 10 chunks/s ETA 3s") {
+		t.Errorf("Unexpected partial bar: %q", out)
+	}
+	if !strings.Contains(out, "100%") || strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+		t.Errorf("Bar not completed exactly once: %q", out)
+	}
+}
+
+// TestFormatBytes checks the unit chosen for a range of sizes.
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 << 20:         "5.0 MiB",
+		3<<30 + 512<<20: "3.5 GiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
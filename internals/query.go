@@ -0,0 +1,86 @@
+package internals
+
+import (
+	"fmt"
+	"os"
+)
+
+// TextSimHash normalizes and filters the given query text and computes its SimHash from the
+// features, feature hash, weights and fingerprint width the index was built with, so the result can
+// be looked up in it.
+func (d IndexData) TextSimHash(text []byte) Fingerprint {
+	header := d.Header
+	return header.featureSpec().fingerprint(text, header.hashSpec().new(), header.idfTable(), header.fingerprintBits())
+}
+
+// ResolveQuery turns the query options of the lookup and fuzzy commands into a hexadecimal
+// SimHash string. Exactly one of simHashStr, queryText and queryFile must be set. Query text is
+// hashed with the normalization, word filters, features, feature hash, document frequencies and
+// fingerprint width recorded in the header of indexFile, so that it is comparable with the indexed chunks.
+//
+// Parameters:
+//   - indexFile: The path to the index file that will be queried; when empty, the text is hashed
+//     with the default features.
+//   - simHashStr: A SimHash value in hexadecimal format, returned unchanged.
+//   - queryText: Text whose SimHash should be computed.
+//   - queryFile: The path to a file whose contents should be hashed.
+//
+// Returns:
+//   - string: The SimHash to search for, in hexadecimal format.
+//   - error: An error if no option or more than one option is set, or the query file or the index
+//     header cannot be read.
+func ResolveQuery(indexFile, simHashStr, queryText, queryFile string) (string, error) {
+	text, err := readQueryText(simHashStr, queryText, queryFile)
+	if err != nil {
+		return "", err
+	}
+	if simHashStr != "" {
+		return simHashStr, nil
+	}
+
+	features, hashing, idf, width := defaultFeatures, hashSpec{}, (*idfTable)(nil), FingerprintBits64
+	if indexFile != "" {
+		header, err := readIndexHeader(indexFile)
+		if err != nil {
+			return "", err
+		}
+		features, hashing, idf, width = header.featureSpec(), header.hashSpec(), header.idfTable(), header.fingerprintBits()
+		if idf != nil && header.Layout == layoutSorted {
+			// The document frequencies of a sorted-layout index are stored in its body
+			index, err := openIndex(indexFile)
+			if err != nil {
+				return "", err
+			}
+			defer index.Close()
+			idf = index.idfTable()
+		}
+	}
+	return features.fingerprint(text, hashing.new(), idf, width).String(), nil
+}
+
+// readQueryText checks that exactly one of the query options of the lookup and fuzzy commands is
+// set, and returns the query text, read from queryFile when that is the one set. It returns nil
+// when the query is the SimHash simHashStr.
+func readQueryText(simHashStr, queryText, queryFile string) ([]byte, error) {
+	set := 0
+	for _, opt := range []string{simHashStr, queryText, queryFile} {
+		if opt != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of -h, -q or -qf is required")
+	}
+
+	switch {
+	case queryFile != "":
+		data, err := os.ReadFile(queryFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading query file: %v", err)
+		}
+		return data, nil
+	case queryText != "":
+		return []byte(queryText), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,46 @@
+package internals
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestResolveQuery checks each way of supplying a query and the option validation.
+func TestResolveQuery(t *testing.T) {
+	queryFile := filepath.Join(t.TempDir(), "query.txt")
+	if err := os.WriteFile(queryFile, []byte("hello world"), 0644); err != nil {
+		t.Fatalf("Failed to create query file: %v", err)
+	}
+	textHash := strconv.FormatUint(computeSimHash([]byte("hello world"), fnv.New64a()), 16)
+
+	tests := []struct {
+		name       string
+		simHashStr string
+		queryText  string
+		queryFile  string
+		want       string
+		wantErr    bool
+	}{
+		{"Hex hash", "abc123", "", "", "abc123", false},
+		{"Query text", "", "hello world", "", textHash, false},
+		{"Query file", "", "", queryFile, textHash, false},
+		{"No query", "", "", "", "", true},
+		{"Hash and text", "abc123", "hello world", "", "", true},
+		{"Missing query file", "", "", filepath.Join(t.TempDir(), "missing.txt"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveQuery("", tt.simHashStr, tt.queryText, tt.queryFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package internals
+
+import "sort"
+
+// collapseMatches merges matches whose chunks overlap within the same document into a single
+// match spanning their union, so that a passage covered by several overlapping windows is
+// reported once. The merged match keeps the SimHash and distance of its closest chunk, and its
+// posting holds the start and length of the whole region. Chunks without a recorded length are
+// taken to be chunkSize bytes long.
+//
+// Parameters:
+//   - matches: The matches to collapse, in any order.
+//   - chunkSize: The chunk size of the index the matches came from.
+//   - topK: The maximum number of regions to return; 0 or less returns every region.
+//
+// Returns:
+//   - []fuzzyMatch: One match per region, ordered by sortMatches.
+func collapseMatches(matches []fuzzyMatch, chunkSize, topK int) []fuzzyMatch {
+	sorted := append([]fuzzyMatch(nil), matches...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].posting.DocID != sorted[j].posting.DocID {
+			return sorted[i].posting.DocID < sorted[j].posting.DocID
+		}
+		return sorted[i].posting.Offset < sorted[j].posting.Offset
+	})
+
+	var regions []fuzzyMatch
+	var end int64
+	for _, m := range sorted {
+		length := m.posting.Length
+		if length <= 0 {
+			length = chunkSize
+		}
+		last := len(regions) - 1
+		if last < 0 || regions[last].posting.DocID != m.posting.DocID || m.posting.Offset >= end {
+			end = m.posting.Offset + int64(length)
+			regions = append(regions, fuzzyMatch{m.simhash, m.distance, Posting{m.posting.DocID, m.posting.Offset, length}})
+			continue
+		}
+
+		r := &regions[last]
+		end = max(end, m.posting.Offset+int64(length))
+		r.posting.Length = int(end - r.posting.Offset)
+		if m.distance < r.distance || m.distance == r.distance && m.simhash.less(r.simhash) {
+			r.simhash, r.distance = m.simhash, m.distance
+		}
+	}
+	return sortMatches(regions, topK)
+}
+
+// collapsePostings merges the postings of overlapping chunks indexed under simHash into one
+// posting per region, ordered by document and offset.
+func collapsePostings(simHash Fingerprint, postings []Posting, chunkSize int) []Posting {
+	matches := make([]fuzzyMatch, len(postings))
+	for i, p := range postings {
+		matches[i] = fuzzyMatch{simHash, 0, p}
+	}
+	regions := collapseMatches(matches, chunkSize, 0)
+	collapsed := make([]Posting, len(regions))
+	for i, r := range regions {
+		collapsed[i] = r.posting
+	}
+	return collapsed
+}
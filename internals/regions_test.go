@@ -0,0 +1,55 @@
+package internals
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCollapseMatches verifies that overlapping chunks of the same document merge into one region
+// that keeps the closest match, while disjoint chunks and other documents stay separate.
+func TestCollapseMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		matches []fuzzyMatch
+		topK    int
+		want    []fuzzyMatch
+	}{
+		{
+			"Overlapping windows",
+			[]fuzzyMatch{{Fingerprint{0x3}, 2, Posting{0, 32, 64}}, {Fingerprint{0x1}, 1, Posting{0, 0, 64}}, {Fingerprint{0x7}, 3, Posting{0, 64, 64}}},
+			0,
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 128}}},
+		},
+		{
+			"Touching chunks stay apart",
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 64}}, {Fingerprint{0x0}, 0, Posting{0, 64, 64}}},
+			0,
+			[]fuzzyMatch{{Fingerprint{0x0}, 0, Posting{0, 64, 64}}, {Fingerprint{0x1}, 1, Posting{0, 0, 64}}},
+		},
+		{
+			"Other documents stay apart",
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 64}}, {Fingerprint{0x1}, 1, Posting{1, 32, 64}}},
+			0,
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 64}}, {Fingerprint{0x1}, 1, Posting{1, 32, 64}}},
+		},
+		{
+			"Legacy lengths",
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 0}}, {Fingerprint{0x1}, 1, Posting{0, 16, 0}}},
+			0,
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 48}}},
+		},
+		{
+			"Top regions",
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 64}}, {Fingerprint{0x1}, 1, Posting{0, 32, 64}}, {Fingerprint{0x3}, 2, Posting{0, 200, 64}}},
+			1,
+			[]fuzzyMatch{{Fingerprint{0x1}, 1, Posting{0, 0, 96}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collapseMatches(tt.matches, 32, tt.topK); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("collapseMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,106 +1,348 @@
 package internals
 
 import (
-	"encoding/gob"
 	"fmt"
 	"io"
-	"os"
-	"strconv"
+	"math/bits"
+	"sort"
 	"strings"
 )
 
-// RunFuzzy searches for nearly similar hashes in an index file and displays matching phrases from the original file.
+// fuzzyMatch is a single chunk whose SimHash lies within the requested
+// Hamming distance of the query.
+type fuzzyMatch struct {
+	simhash  Fingerprint
+	distance int
+	posting  Posting
+}
+
+// RunFuzzy searches for nearly similar hashes in an index file and writes the matching chunks to w in
+// the format selected by opts.Format. In text format, a message is printed when nothing is in range.
+//
+// Parameters:
+//   - w: The writer the results are printed to, such as os.Stdout.
+//   - indexFile: The path to the index file containing the precomputed SimHashes and their offsets.
+//   - simHashStr: The SimHash value (in hexadecimal string format) to search for in the index.
+//   - maxDistance: The maximum Hamming distance for a hash to be reported as a match.
+//   - topK: The maximum number of matches to report; 0 or less reports every match.
+//   - opts: The feature hash the SimHash was computed with, how to react to documents that changed after
+//     they were indexed, and how to print the results.
+//
+// Returns:
+//   - error: An error if any occurs during the execution, otherwise nil.
+func RunFuzzy(w io.Writer, indexFile, simHashStr string, maxDistance, topK int, opts QueryOptions) error {
+	if _, err := parseFormat(opts.Format); err != nil {
+		return err
+	}
+	results, err := Fuzzy(indexFile, simHashStr, maxDistance, topK, opts)
+	if err != nil {
+		return err
+	}
+	return writeResults(w, opts.Format, results, func(w io.Writer, results []Result) error {
+		return writeFuzzyText(w, results, maxDistance)
+	})
+}
+
+// Fuzzy searches for nearly similar hashes in an index file and returns the matching chunks.
 //
 // Parameters:
 //   - indexFile: The path to the index file containing the precomputed SimHashes and their offsets.
 //   - simHashStr: The SimHash value (in hexadecimal string format) to search for in the index.
+//   - maxDistance: The maximum Hamming distance for a hash to be reported as a match, at most the
+//     fingerprint width of the index.
+//   - topK: The maximum number of matches to report; 0 or less reports every match.
+//   - opts: The feature hash the SimHash was computed with, if known, and how to react to documents
+//     that changed after they were indexed.
 //
 // Returns:
+//   - []Result: The matches, sorted by ascending distance; empty when nothing is in range.
 //   - error: An error if any occurs during the execution, otherwise nil.
 //
 // The function performs the following steps:
-//  1. Opens the index file and decodes its contents.
-//  2. Checks if the original file specified in the index exists.
-//  3. Parses the provided SimHash string into a uint64 value.
-//  4. Opens the original file and reads chunks at the offsets where nearly similar hashes are found.
-//  5. For each matching chunk, extracts and displays a phrase from the chunk along with the SimHash, byte offset, and original file name.
-//  6. If no nearly similar hashes are found, it prints a message indicating so.
-func RunFuzzy(indexFile, simHashStr string) error {
-	dataFile, err := os.Open(indexFile)
+//  1. Opens the index file and decodes its contents, rejecting it when opts.Hash names another feature
+//     hash than the one it was built with.
+//  2. Checks if the original files listed in the index's document table exist and still match the
+//     fingerprints recorded at build time, warning or failing according to opts.Stale.
+//  3. Parses the provided SimHash string into a fingerprint as wide as those of the index. The hash does not
+//     need to be present in the index; any value of that width, such as one computed from unrelated text,
+//     can be used as the query.
+//  4. Collects every indexed chunk whose hash is within maxDistance of the query, sorted by ascending distance.
+//     The permutation tables stored in the index are used when they cover maxDistance. When the index holds
+//     overlapping chunks, the matches of overlapping chunks are merged into one region per passage, which
+//     keeps the distance of its closest chunk.
+//  5. For the first topK matches, reads the chunk from the document it came from and extracts a phrase
+//     from it, returning it along with the SimHash, distance, byte offset, length and original file name.
+func Fuzzy(indexFile, simHashStr string, maxDistance, topK int, opts QueryOptions) ([]Result, error) {
+	// Open the index file for querying.
+	index, err := openIndex(indexFile)
 	if err != nil {
-		return fmt.Errorf("error opening index file: %v", err)
+		return nil, err
 	}
-	defer dataFile.Close()
-
-	// Decode the index data
-	var indexData IndexData
-	decoder := gob.NewDecoder(dataFile)
-	if err := decoder.Decode(&indexData); err != nil {
-		return fmt.Errorf("error decoding index data: %v", err)
+	defer index.Close()
+	if err := index.Header.checkQueryHash(opts.Hash); err != nil {
+		return nil, err
+	}
+	width := index.Header.fingerprintBits()
+	if maxDistance < 0 || maxDistance > width {
+		return nil, fmt.Errorf("invalid distance: %d, must be between 0 and %d", maxDistance, width)
 	}
 
-	// Check if the original file exists
-	if _, err := os.Stat(indexData.FileName); os.IsNotExist(err) {
-		return fmt.Errorf("original file %s not found", indexData.FileName)
+	// Check if the original files exist and are unchanged
+	docs, err := openDocuments(index.Documents, opts.staleOrDefault())
+	if err != nil {
+		return nil, err
 	}
+	defer docs.Close()
 
 	// Parse the provided SimHash
-	simHash, err := strconv.ParseUint(simHashStr, 16, 64)
+	simHash, err := parseFingerprint(simHashStr, width)
 	if err != nil {
-		return fmt.Errorf("invalid SimHash value: %v", err)
+		return nil, fmt.Errorf("invalid SimHash value: %v", err)
+	}
+
+	var matches []fuzzyMatch
+	if index.chunkSpec().overlapping() {
+		// Collapse overlapping windows before counting the topK regions
+		matches = collapseMatches(index.nearby(simHash, maxDistance, 0), index.ChunkSize, topK)
+	} else {
+		matches = index.nearby(simHash, maxDistance, topK)
+	}
+	return matchResults(docs, matches, index.ChunkSize)
+}
+
+// matchResults reads the chunk of every match from the document it came from and describes it
+// with a phrase extracted from its text, in the order of matches.
+func matchResults(docs *documentSet, matches []fuzzyMatch, chunkSize int) ([]Result, error) {
+	results := make([]Result, 0, len(matches))
+	for _, m := range matches {
+		chunk, err := docs.readChunk(m.posting, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+
+		// Extract a phrase from the chunk
+		words := strings.Fields(string(chunk))
+		phrase := strings.Join(words, " ")
+		if len(phrase) > 50 {
+			phrase = phrase[:50] + "..."
+		}
+
+		results = append(results, Result{
+			File:     docs.path(m.posting),
+			SimHash:  m.simhash,
+			Offset:   m.posting.Offset,
+			Distance: m.distance,
+			Length:   len(chunk),
+			Phrase:   phrase,
+		})
 	}
+	return results, nil
+}
 
-	//Lookup the SimHash in the index to retrieve the byte offsets
-	_, exists := indexData.Index[simHash]
-	if !exists {
-		return fmt.Errorf("SimHash not found in index: Ensure the file was indexed beforelooking up.")
+// RunFuzzyJaccard searches an index built for SimilarityMinHash for chunks whose sets of features
+// are similar to the query's, and writes them to w in the format selected by opts.Format. In text
+// format, a message is printed when no chunk is similar enough.
+//
+// Parameters:
+//   - w: The writer the results are printed to, such as os.Stdout.
+//   - indexFile: The path to the index file containing the MinHash signatures.
+//   - simHashStr, queryText, queryFile: The query, exactly one of which must be set: the SimHash of
+//     an indexed chunk, whose signature is used, text, or the path to a file whose contents are used.
+//   - minJaccard: The smallest estimated Jaccard similarity for a chunk to be reported as a match.
+//   - topK: The maximum number of matches to report; 0 or less reports every match.
+//   - opts: The feature hash a SimHash query was computed with, how to react to documents that
+//     changed after they were indexed, and how to print the results.
+//
+// Returns:
+//   - error: An error if any occurs during the execution, otherwise nil.
+func RunFuzzyJaccard(w io.Writer, indexFile, simHashStr, queryText, queryFile string, minJaccard float64, topK int, opts QueryOptions) error {
+	if _, err := parseFormat(opts.Format); err != nil {
+		return err
+	}
+	results, err := FuzzyJaccard(indexFile, simHashStr, queryText, queryFile, minJaccard, topK, opts)
+	if err != nil {
+		return err
 	}
+	return writeJaccardResults(w, opts.Format, results, func(w io.Writer, results []Result) error {
+		return writeJaccardText(w, results, minJaccard)
+	})
+}
 
-	// Open the original file
-	file, err := os.Open(indexData.FileName)
+// FuzzyJaccard searches an index built for SimilarityMinHash for chunks whose sets of features are
+// similar to the query's, and returns them.
+//
+// Parameters:
+//   - indexFile: The path to the index file containing the MinHash signatures.
+//   - simHashStr, queryText, queryFile: The query, exactly one of which must be set: the SimHash of
+//     an indexed chunk, whose signature is used, text, or the path to a file whose contents are used.
+//   - minJaccard: The smallest estimated Jaccard similarity for a chunk to be reported as a match,
+//     greater than 0 and at most 1.
+//   - topK: The maximum number of matches to report; 0 or less reports every match.
+//   - opts: The feature hash a SimHash query was computed with, if known, and how to react to
+//     documents that changed after they were indexed.
+//
+// Returns:
+//   - []Result: The matches, most similar first, with their estimated Jaccard similarity; empty when
+//     no chunk is similar enough.
+//   - error: An error if any occurs during the execution, otherwise nil.
+//
+// The function performs the following steps:
+//  1. Opens the index file and decodes its contents, rejecting it when it holds no MinHash signatures
+//     or when opts.Hash names another feature hash than the one it was built with.
+//  2. Checks if the original files listed in the index's document table exist and still match the
+//     fingerprints recorded at build time, warning or failing according to opts.Stale.
+//  3. Computes the signature of the query text with the features, feature hash and signature size of
+//     the index or, for a SimHash, takes the signature of the first chunk indexed under it.
+//  4. Compares the signature with every chunk sharing one of its LSH bands, keeping those whose
+//     estimated Jaccard similarity is at least minJaccard. Overlapping chunks are merged into one
+//     region per passage, which keeps the similarity of its most similar chunk.
+//  5. For the first topK matches, reads the chunk from the document it came from and extracts a phrase
+//     from it, returning it along with the SimHash, similarity, byte offset, length and original file name.
+func FuzzyJaccard(indexFile, simHashStr, queryText, queryFile string, minJaccard float64, topK int, opts QueryOptions) ([]Result, error) {
+	if minJaccard <= 0 || minJaccard > 1 {
+		return nil, fmt.Errorf("invalid Jaccard similarity: %g, must be greater than 0 and at most 1", minJaccard)
+	}
+	text, err := readQueryText(simHashStr, queryText, queryFile)
 	if err != nil {
-		return fmt.Errorf("error opening original file: %v", err)
-	}
-	defer file.Close()
-
-	// check instances if close similarity
-	count := 0
-	for hash, offsets := range indexData.Index {
-		distance := hammingdistance(simHash, hash)
-		if distance == 1 {
-			count++
-			for _, offset := range offsets {
-				chunk := make([]byte, indexData.ChunkSize)
-				n, err := file.ReadAt(chunk, offset)
-				if err != nil && err != io.EOF {
-					return fmt.Errorf("error reading chunk at offset %d: %v", offset, err)
-				}
-				chunk = chunk[:n]
-
-				// Extract a phrase from the chunk
-				words := strings.Fields(string(chunk))
-				phrase := strings.Join(words, " ")
-				if len(phrase) > 50 {
-					phrase = phrase[:50] + "..."
-				}
-
-				// Display the result
-				fmt.Printf("Original file: %s\n", indexData.FileName)
-				fmt.Printf("SimHash: %x\n", hash) // Print the SimHash of the matching chunk
-				fmt.Printf("Byte offset: %d\n", offset)
-				fmt.Printf("Phrase: %s\n", phrase)
-				fmt.Println("----------")
-			}
+		return nil, err
+	}
+
+	index, err := openIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	if index.MinHash == nil {
+		return nil, fmt.Errorf("index holds no MinHash signatures; build it with similarity %q", SimilarityMinHash)
+	}
+	if err := index.Header.checkQueryHash(opts.Hash); err != nil {
+		return nil, err
+	}
+
+	// Check if the original files exist and are unchanged
+	docs, err := openDocuments(index.Documents, opts.staleOrDefault())
+	if err != nil {
+		return nil, err
+	}
+	defer docs.Close()
+
+	var signature []uint64
+	if simHashStr == "" {
+		signature = index.Header.textMinHash(text)
+	} else {
+		simHash, err := parseFingerprint(simHashStr, index.Header.fingerprintBits())
+		if err != nil {
+			return nil, fmt.Errorf("invalid SimHash value: %v", err)
+		}
+		if signature = index.chunkSignature(simHash); signature == nil {
+			return nil, fmt.Errorf("SimHash %s is not in the index; search for the text with -q or -qf instead", simHash)
+		}
+	}
+
+	results, err := matchResults(docs, index.similar(signature, minJaccard, topK), index.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		// matchResults reports the mismatched signature values as the distance
+		results[i].Mismatches, results[i].Distance = results[i].Distance, 0
+		results[i].Jaccard = jaccard(results[i].Mismatches, index.MinHash.Perms)
+	}
+	return results, nil
+}
+
+// writeJaccardText prints Jaccard search results in the human-readable layout of the fuzzy command.
+func writeJaccardText(w io.Writer, results []Result, minJaccard float64) error {
+	for _, r := range results {
+		fmt.Fprintf(w, "Original file: %s\n", r.File)
+		fmt.Fprintf(w, "SimHash: %x\n", r.SimHash)
+		fmt.Fprintf(w, "Jaccard: %.4f\n", r.Jaccard)
+		fmt.Fprintf(w, "Byte offset: %d\n", r.Offset)
+		fmt.Fprintf(w, "Byte range: %d-%d\n", r.Offset, r.End())
+		fmt.Fprintf(w, "Phrase: %s\n", r.Phrase)
+		if _, err := fmt.Fprintln(w, "----------"); err != nil {
+			return err
+		}
+	}
+
+	if len(results) == 0 {
+		if _, err := fmt.Fprintf(w, "No chunks with a Jaccard similarity of at least %g\n", minJaccard); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if count == 0 {
-		fmt.Println("No Nearly Similar Hashes found")
+// writeFuzzyText prints fuzzy search results in the human-readable layout of the fuzzy command.
+func writeFuzzyText(w io.Writer, results []Result, maxDistance int) error {
+	for _, r := range results {
+		fmt.Fprintf(w, "Original file: %s\n", r.File)
+		fmt.Fprintf(w, "SimHash: %x\n", r.SimHash) // Print the SimHash of the matching chunk
+		fmt.Fprintf(w, "Distance: %d\n", r.Distance)
+		fmt.Fprintf(w, "Byte offset: %d\n", r.Offset)
+		fmt.Fprintf(w, "Byte range: %d-%d\n", r.Offset, r.End())
+		fmt.Fprintf(w, "Phrase: %s\n", r.Phrase)
+		if _, err := fmt.Fprintln(w, "----------"); err != nil {
+			return err
+		}
 	}
 
+	if len(results) == 0 {
+		if _, err := fmt.Fprintf(w, "No neighbours within distance %d\n", maxDistance); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// findNearby collects every chunk in index whose fingerprint is within maxDistance of simHash.
+// When tables can answer the query, only the candidate buckets of the permutation index are
+// examined; otherwise every key in index is scanned. Matches are ordered by sortMatches so the
+// output is stable across runs.
+func findNearby(index map[Fingerprint][]Posting, tables *PermutationIndex, simHash Fingerprint, maxDistance, topK int) []fuzzyMatch {
+	var matches []fuzzyMatch
+	if tables.Supports(maxDistance) {
+		for hash, distance := range tables.nearbyHashes(simHash, maxDistance) {
+			for _, posting := range index[hash] {
+				matches = append(matches, fuzzyMatch{hash, distance, posting})
+			}
+		}
+	} else {
+		for hash, postings := range index {
+			distance := simHash.distance(hash)
+			if distance > maxDistance {
+				continue
+			}
+			for _, posting := range postings {
+				matches = append(matches, fuzzyMatch{hash, distance, posting})
+			}
+		}
+	}
+
+	return sortMatches(matches, topK)
+}
+
+// sortMatches orders matches by ascending distance, breaking ties by hash, document and then
+// offset, and truncates them to topK entries when topK is greater than 0.
+func sortMatches(matches []fuzzyMatch, topK int) []fuzzyMatch {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		if matches[i].simhash != matches[j].simhash {
+			return matches[i].simhash.less(matches[j].simhash)
+		}
+		if matches[i].posting.DocID != matches[j].posting.DocID {
+			return matches[i].posting.DocID < matches[j].posting.DocID
+		}
+		return matches[i].posting.Offset < matches[j].posting.Offset
+	})
+	if topK > 0 && len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches
+}
+
 // The Hamming distance is the number of positions at which the corresponding bits are different.
 // Parameters:
 //   - a: the first 64-bit unsigned integer
@@ -109,11 +351,5 @@ func RunFuzzy(indexFile, simHashStr string) error {
 // Returns:
 //   - int: the Hamming distance between the two integers
 func hammingdistance(a, b uint64) int {
-	distance := 0
-	for i := 0; i < 64; i++ {
-		if (a & (1 << i)) != (b & (1 << i)) {
-			distance++
-		}
-	}
-	return distance
+	return bits.OnesCount64(a ^ b)
 }
@@ -1,7 +1,7 @@
 package internals
 
 import (
-	"encoding/gob"
+	"io"
 	"os"
 	"testing"
 )
@@ -12,13 +12,13 @@ func TestRunFuzzy_ErrorCases(t *testing.T) {
 	simHash := "123abc" // Valid hex string
 
 	// Test case: Index file does not exist
-	err := RunFuzzy("nonexistent.gob", simHash)
+	err := RunFuzzy(io.Discard, "nonexistent.gob", simHash, 1, 0, QueryOptions{})
 	if err == nil {
 		t.Errorf("Expected error for missing index file, got nil")
 	}
 
 	// Test case: Invalid SimHash format
-	err = RunFuzzy(indexFile, "invalid-hash")
+	err = RunFuzzy(io.Discard, indexFile, "invalid-hash", 1, 0, QueryOptions{})
 	if err == nil {
 		t.Errorf("Expected error for invalid SimHash, got nil")
 	}
@@ -26,20 +26,20 @@ func TestRunFuzzy_ErrorCases(t *testing.T) {
 	// Test case: Corrupted index file (invalid gob data)
 	os.WriteFile(indexFile, []byte("corruptdata"), 0644)
 	defer os.Remove(indexFile)
-	err = RunFuzzy(indexFile, simHash)
+	err = RunFuzzy(io.Discard, indexFile, simHash, 1, 0, QueryOptions{})
 	if err == nil {
 		t.Errorf("Expected error for corrupted gob data, got nil")
 	}
 
 	// Test case: Original file missing
 	createTestIndexFile(indexFile, "missing_file.txt", 16, map[uint64][]int64{0x123abc: {0}})
-	err = RunFuzzy(indexFile, simHash)
+	err = RunFuzzy(io.Discard, indexFile, simHash, 1, 0, QueryOptions{})
 	if err == nil {
 		t.Errorf("Expected error for missing original file, got nil")
 	}
 }
 
-// TestRunFuzzy_Success tests a case where "if distance == 1" is executed
+// TestRunFuzzy_Success tests a query hash that is absent from the index but has a neighbour at distance 1
 func TestRunFuzzy_Success(t *testing.T) {
 	indexFile := "test_index.gob"
 	originalFile := "test_original.txt"
@@ -55,24 +55,119 @@ func TestRunFuzzy_Success(t *testing.T) {
 	createTestIndexFile(indexFile, originalFile, 16, map[uint64][]int64{hashInIndex: {0}})
 	defer os.Remove(indexFile)
 
-	// Run test
-	err := RunFuzzy(indexFile, simHash)
-	if err == nil {
-		t.Error("Expected an error but got <nil>")
+	// Run test: the query hash itself is not a key in the index
+	err := RunFuzzy(io.Discard, indexFile, simHash, 1, 0, QueryOptions{})
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+}
+
+// TestFuzzy_Results checks that Fuzzy returns the matching chunk as data rather than printing it
+func TestFuzzy_Results(t *testing.T) {
+	indexFile := "test_index.gob"
+	originalFile := "test_original.txt"
+
+	os.WriteFile(originalFile, []byte("This is a test file for RunFuzzy."), 0644)
+	defer os.Remove(originalFile)
+
+	createTestIndexFile(indexFile, originalFile, 16, map[uint64][]int64{0x123abd: {16}})
+	defer os.Remove(indexFile)
+
+	results, err := Fuzzy(indexFile, "123abc", 1, 0, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Fuzzy() failed: %v", err)
+	}
+	want := Result{File: originalFile, SimHash: Fingerprint{0x123abd}, Offset: 16, Distance: 1, Length: 16, Phrase: "ile for RunFuzzy"}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("Fuzzy() = %+v, want [%+v]", results, want)
+	}
+
+	for _, format := range []string{FormatText, FormatJSON, FormatNDJSON, FormatCSV} {
+		if err := RunFuzzy(io.Discard, indexFile, "123abc", 1, 0, QueryOptions{Format: format}); err != nil {
+			t.Errorf("RunFuzzy() with format %s failed: %v", format, err)
+		}
+	}
+	if err := RunFuzzy(io.Discard, indexFile, "123abc", 1, 0, QueryOptions{Format: "xml"}); err == nil {
+		t.Error("Expected error for invalid format, got nil")
+	}
+}
+
+// TestRunFuzzy_NoNeighbours tests that a query with no hashes in range is not an error
+func TestRunFuzzy_NoNeighbours(t *testing.T) {
+	indexFile := "test_index.gob"
+	originalFile := "test_original.txt"
+
+	os.WriteFile(originalFile, []byte("This is a test file for RunFuzzy."), 0644)
+	defer os.Remove(originalFile)
+
+	createTestIndexFile(indexFile, originalFile, 16, map[uint64][]int64{0xffffffffffffffff: {0}})
+	defer os.Remove(indexFile)
+
+	if err := RunFuzzy(io.Discard, indexFile, "0", 3, 0, QueryOptions{}); err != nil {
+		t.Errorf("Expected no error but got %v", err)
 	}
 }
 
-// createTestIndexFile creates a valid gob-encoded index file
+// TestRunFuzzy_InvalidDistance tests that out-of-range distance thresholds are rejected
+func TestRunFuzzy_InvalidDistance(t *testing.T) {
+	for _, d := range []int{-1, 65} {
+		if err := RunFuzzy(io.Discard, "nonexistent.gob", "123abc", d, 0, QueryOptions{}); err == nil {
+			t.Errorf("Expected error for distance %d, got nil", d)
+		}
+	}
+}
+
+// TestFindNearby verifies that matches are filtered by distance, sorted and limited to top-k
+func TestFindNearby(t *testing.T) {
+	index := map[Fingerprint][]Posting{
+		{0x0}: {{0, 32, 16}},             // distance 0
+		{0x1}: {{0, 0, 16}, {0, 16, 16}}, // distance 1
+		{0x3}: {{0, 48, 16}},             // distance 2
+		{0xf}: {{0, 64, 16}},             // distance 4
+	}
+
+	tests := []struct {
+		name        string
+		maxDistance int
+		topK        int
+		want        []fuzzyMatch
+	}{
+		{"Exact only", 0, 0, []fuzzyMatch{{Fingerprint{0x0}, 0, Posting{0, 32, 16}}}},
+		{"Within two bits", 2, 0, []fuzzyMatch{{Fingerprint{0x0}, 0, Posting{0, 32, 16}}, {Fingerprint{0x1}, 1, Posting{0, 0, 16}}, {Fingerprint{0x1}, 1, Posting{0, 16, 16}}, {Fingerprint{0x3}, 2, Posting{0, 48, 16}}}},
+		{"Top two", 4, 2, []fuzzyMatch{{Fingerprint{0x0}, 0, Posting{0, 32, 16}}, {Fingerprint{0x1}, 1, Posting{0, 0, 16}}}},
+		{"Everything", 64, 0, []fuzzyMatch{{Fingerprint{0x0}, 0, Posting{0, 32, 16}}, {Fingerprint{0x1}, 1, Posting{0, 0, 16}}, {Fingerprint{0x1}, 1, Posting{0, 16, 16}}, {Fingerprint{0x3}, 2, Posting{0, 48, 16}}, {Fingerprint{0xf}, 4, Posting{0, 64, 16}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, tables := range []*PermutationIndex{nil, newDefaultPermutationIndex(index, FingerprintBits64)} {
+				got := findNearby(index, tables, Fingerprint{}, tt.maxDistance, tt.topK)
+				if len(got) != len(tt.want) {
+					t.Fatalf("findNearby() returned %d matches, want %d: %v", len(got), len(tt.want), got)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Errorf("findNearby()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+// createTestIndexFile creates a valid index file for a single document
 func createTestIndexFile(filename, originalFile string, chunkSize int, index map[uint64][]int64) {
+	postings := make(map[Fingerprint][]Posting, len(index))
+	for hash, offsets := range index {
+		for _, offset := range offsets {
+			postings[Fingerprint{hash}] = append(postings[Fingerprint{hash}], Posting{DocID: 0, Offset: offset})
+		}
+	}
 	data := IndexData{
-		FileName:  originalFile,
+		Header:    newIndexHeader(),
+		Documents: []Document{{Path: originalFile}},
 		ChunkSize: chunkSize,
-		Index:     index,
+		Index:     postings,
 	}
-
-	file, _ := os.Create(filename)
-	defer file.Close()
-
-	encoder := gob.NewEncoder(file)
-	encoder.Encode(data)
+	saveIndexData(filename, data)
 }
@@ -1,69 +1,102 @@
 package internals
 
 import (
-	"encoding/gob"
+	"context"
 	"fmt"
-	"os"
 	"runtime"
 )
 
-// RunIndex processes an input file to build an index and serialize it to an output file.
+// RunIndex processes one or more input files to build a single corpus index and serialize it to an output file.
 // It performs the following steps:
-// 1. Validates the chunk size to ensure it is greater than 0.
-// 2. Validates the input file using the ValidateInputFile function.
-// 3. Builds the index using the NewFileIndex and BuildIndex functions.
-// 4. Prepares the IndexData structure with the file name, chunk size, and index data.
-// 5. Starts a goroutine to process the index data concurrently using the IndexFileDecoder function.
-// 6. Serializes the index data to the specified output file using gob encoding.
+//  1. Validates the options, ensuring the chunk sizes are consistent and the chunking, layout, dump order
+//     and dump format are known.
+//  2. Validates each input file using the ValidateInputFile function.
+//  3. Builds the index using the BuildIndexData function, one document at a time. The IndexData it returns
+//     holds the document table, chunk size, index data, and, for the gob layout, the permutation tables
+//     used by fuzzy search.
+//  4. If opts.DumpFile is set, starts a goroutine that writes the human-readable dump using the
+//     IndexFileDecoder function.
+//  5. Serializes the index data to the specified output file in the requested layout, replacing it atomically.
+//  6. Waits for the dump to finish, so that it is complete when RunIndex returns and any error is reported.
 //
 // Parameters:
-// - inputFile: The path to the input file to be indexed.
-// - chunkSize: The size of each chunk for indexing.
+// - inputFiles: The paths to the input files to be indexed, typically produced by ExpandInputs.
 // - outputFile: The path to the output file where the serialized index data will be saved.
+// - opts: The chunk size and on-disk layout of the index, and where, in which order and in which format to write the dump.
 //
 // Returns:
 // - error: An error if any step fails, otherwise nil.
-func RunIndex(inputFile string, chunkSize int, outputFile string) error {
-	// Ensures chunkSize is valid to prevent infinite loops or excessive resource usage.
-	if chunkSize <= 0 {
-		return fmt.Errorf("invalid chunk size: %d, must be greater than 0", chunkSize)
+func RunIndex(inputFiles []string, outputFile string, opts IndexOptions) error {
+	return RunIndexContext(context.Background(), inputFiles, outputFile, opts)
+}
+
+// RunIndexContext is RunIndex with a context. If ctx is cancelled while the index is being built,
+// the build stops, no index or dump is written, and ctx.Err() is returned.
+func RunIndexContext(ctx context.Context, inputFiles []string, outputFile string, opts IndexOptions) error {
+	// Ensures the chunk sizes are valid to prevent infinite loops or excessive resource usage.
+	if _, err := opts.chunkSpec(); err != nil {
+		return err
 	}
-	// Validate input file
-	if err := ValidateInputFile(inputFile); err != nil {
+	if _, err := opts.featureSpec(); err != nil {
 		return err
 	}
-
-	// Build the index
-	fi := NewFileIndex(chunkSize, runtime.NumCPU())
-	if err := fi.BuildIndex(inputFile); err != nil {
-		return fmt.Errorf("error building index: %v", err)
+	if _, err := parseHash(opts.Hash, opts.HashKey); err != nil {
+		return err
 	}
-
-	// Prepare the IndexData structure
-	indexData := IndexData{
-		FileName:  inputFile,
-		ChunkSize: chunkSize,
-		Index:     fi.index.m,
+	if _, err := parseFingerprintBits(opts.FingerprintBits); err != nil {
+		return err
 	}
-
-	// Start a goroutine to process the index data concurrently
-	go func(data IndexData) {
-		if err := IndexFileDecoder(data); err != nil {
-			fmt.Printf("error in IndexFileDecoder: %v\n", err)
+	if _, err := parseWeighting(opts.Weighting); err != nil {
+		return err
+	}
+	if _, err := parseLayout(opts.Layout); err != nil {
+		return err
+	}
+	if _, err := opts.minHashSpec(); err != nil {
+		return err
+	}
+	if opts.DumpOrder != "" && opts.DumpOrder != DumpByHash && opts.DumpOrder != DumpByOffset {
+		return fmt.Errorf("invalid dump order %q, must be %q or %q", opts.DumpOrder, DumpByHash, DumpByOffset)
+	}
+	if _, err := parseFormat(opts.DumpFormat); err != nil {
+		return err
+	}
+	if len(inputFiles) == 0 {
+		return fmt.Errorf("no input files to index")
+	}
+	// Validate input files
+	for _, inputFile := range inputFiles {
+		if err := ValidateInputFile(inputFile); err != nil {
+			return fmt.Errorf("%s: %v", inputFile, err)
 		}
-	}(indexData)
+	}
 
-	// Serialize the index data to the output file
-	dataFile, err := os.Create(outputFile)
+	// Build the index
+	sources := make([]Source, len(inputFiles))
+	for i, inputFile := range inputFiles {
+		sources[i] = Source{Path: inputFile}
+	}
+	indexData, err := BuildIndexData(ctx, sources, opts, runtime.NumCPU())
 	if err != nil {
-		return fmt.Errorf("error creating index file: %v", err)
+		return err
 	}
-	defer dataFile.Close()
 
-	encoder := gob.NewEncoder(dataFile)
-	if err := encoder.Encode(indexData); err != nil {
-		return fmt.Errorf("error encoding index data: %v", err)
+	// Write the human-readable dump concurrently with the index, if one was requested
+	dumpDone := make(chan error, 1)
+	if opts.DumpFile != "" {
+		go func(data IndexData) {
+			dumpDone <- IndexFileDecoder(data, opts.DumpFile, opts.DumpOrder, opts.DumpFormat)
+		}(indexData)
+	} else {
+		dumpDone <- nil
 	}
 
-	return nil
+	// Serialize the index data to the output file
+	saveErr := saveIndexData(outputFile, indexData)
+
+	// Wait for the dump so it is never truncated by the process exiting
+	if err := <-dumpDone; err != nil {
+		return fmt.Errorf("error writing dump: %v", err)
+	}
+	return saveErr
 }
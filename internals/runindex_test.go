@@ -1,7 +1,9 @@
 package internals
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -32,7 +34,7 @@ func TestRunIndex(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := RunIndex(inputFile, tt.chunkSize, outputFile)
+			err := RunIndex([]string{inputFile}, outputFile, IndexOptions{ChunkSize: tt.chunkSize})
 			if (err != nil) != tt.expectFail {
 				t.Errorf("RunIndex() for %s failed: expected failure = %v, got error = %v", tt.name, tt.expectFail, err)
 			}
@@ -46,3 +48,121 @@ func TestRunIndex(t *testing.T) {
 		})
 	}
 }
+
+// TestRunIndex_Options indexes a document with each set of options, and checks that the options
+// are recorded in the index, that verification re-hashes every chunk with them, and that an
+// invalid variant of them is rejected.
+func TestRunIndex_Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    IndexOptions
+		want    func(d IndexData) bool
+		invalid IndexOptions
+	}{
+		{
+			name:    "CDC",
+			opts:    IndexOptions{ChunkSize: 256, Chunking: ChunkCDC},
+			want:    func(d IndexData) bool { return d.chunkSpec() == chunkSpec{ChunkCDC, 256, 64, 1024, 0, 0} },
+			invalid: IndexOptions{ChunkSize: 256, Chunking: ChunkCDC, MinChunkSize: 512},
+		},
+		{
+			name:    "Aligned",
+			opts:    IndexOptions{ChunkSize: 100, Chunking: ChunkWords},
+			want:    func(d IndexData) bool { return d.chunkSpec() == chunkSpec{ChunkWords, 100, 0, 0, 0, 0} },
+			invalid: IndexOptions{ChunkSize: 100, Chunking: ChunkLines, LinesPerChunk: -1},
+		},
+		{
+			name:    "Stride",
+			opts:    IndexOptions{ChunkSize: 64, Stride: 16},
+			want:    func(d IndexData) bool { return d.chunkSpec() == chunkSpec{ChunkFixed, 64, 0, 0, 0, 16} },
+			invalid: IndexOptions{ChunkSize: 64, Stride: 65},
+		},
+		{
+			name: "Features",
+			opts: IndexOptions{ChunkSize: 4096, Features: "chars:2-3"},
+			want: func(d IndexData) bool {
+				return d.Header.featureSpec() == featureSpec{tokenizerChars, 2, 3, 0, wordFilter{}}
+			},
+			invalid: IndexOptions{ChunkSize: 4096, Features: "chars:0"},
+		},
+		{
+			name:    "Normalize",
+			opts:    IndexOptions{ChunkSize: 4096, Normalize: "punct,fold"},
+			want:    func(d IndexData) bool { return d.Header.Normalization == "fold,punct" },
+			invalid: IndexOptions{ChunkSize: 4096, Normalize: "upper"},
+		},
+		{
+			name:    "StopWords",
+			opts:    IndexOptions{ChunkSize: 4096, StopWords: "english", Stemmer: StemPorter},
+			want:    func(d IndexData) bool { return d.Header.StopWords == "english" && d.Header.Stemmer == StemPorter },
+			invalid: IndexOptions{ChunkSize: 4096, StopWords: "klingon"},
+		},
+		{
+			name:    "TFIDF",
+			opts:    IndexOptions{ChunkSize: 256, Weighting: WeightingTFIDF},
+			want:    func(d IndexData) bool { return d.Header.Weighting == WeightingTFIDF && d.Header.DocCount == 64 },
+			invalid: IndexOptions{ChunkSize: 256, Weighting: "bm25"},
+		},
+		{
+			name: "Hash",
+			opts: IndexOptions{ChunkSize: 4096, Hash: HashSipHash, HashKey: "000102030405060708090a0b0c0d0e0f"},
+			want: func(d IndexData) bool {
+				return d.Header.HashFamily == hashFamilySipHash && bytes.Equal(d.Header.HashKey, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15})
+			},
+			invalid: IndexOptions{ChunkSize: 4096, Hash: HashSipHash, HashKey: "00"},
+		},
+		{
+			name:    "Hash xxhash64",
+			opts:    IndexOptions{ChunkSize: 4096, Hash: HashXXHash64},
+			want:    func(d IndexData) bool { return d.Header.HashFamily == hashFamilyXXHash64 },
+			invalid: IndexOptions{ChunkSize: 4096, Hash: "md5"},
+		},
+		{
+			name:    "FingerprintBits",
+			opts:    IndexOptions{ChunkSize: 4096, FingerprintBits: FingerprintBits256},
+			want:    func(d IndexData) bool { return d.Header.FingerprintBits == FingerprintBits256 },
+			invalid: IndexOptions{ChunkSize: 4096, FingerprintBits: 96},
+		},
+		{
+			name:    "FingerprintBits sorted",
+			opts:    IndexOptions{ChunkSize: 4096, FingerprintBits: FingerprintBits128, Layout: layoutSorted},
+			want:    func(d IndexData) bool { return d.Header.FingerprintBits == FingerprintBits128 },
+			invalid: IndexOptions{ChunkSize: 4096, FingerprintBits: 32, Layout: layoutSorted},
+		},
+		{
+			name: "MinHash",
+			opts: IndexOptions{ChunkSize: 400, Chunking: ChunkParagraphs, Similarity: SimilarityMinHash},
+			want: func(d IndexData) bool {
+				h := d.Header
+				return h.Similarity == SimilarityMinHash && h.MinHashPerms == DefaultMinHashPerms && h.MinHashBands == 32
+			},
+			invalid: IndexOptions{ChunkSize: 400, Chunking: ChunkParagraphs, Similarity: SimilarityMinHash, MinHashBands: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input.txt")
+			indexFile := filepath.Join(dir, "input.idx")
+			os.WriteFile(input, randomText(16*1024, 2), 0644)
+
+			if err := RunIndex([]string{input}, indexFile, tt.opts); err != nil {
+				t.Fatalf("RunIndex() failed: %v", err)
+			}
+			if err := RunVerify(indexFile); err != nil {
+				t.Errorf("RunVerify() failed: %v", err)
+			}
+			indexData, err := loadIndexData(indexFile)
+			if err != nil {
+				t.Fatalf("loadIndexData() failed: %v", err)
+			}
+			if !tt.want(indexData) {
+				t.Errorf("Index records chunking %+v and header %+v", indexData.chunkSpec(), indexData.Header)
+			}
+
+			if err := RunIndex([]string{input}, filepath.Join(dir, "invalid.idx"), tt.invalid); err == nil {
+				t.Errorf("Expected RunIndex() to reject %+v, got nil", tt.invalid)
+			}
+		})
+	}
+}
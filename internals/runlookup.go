@@ -1,84 +1,108 @@
 package internals
 
 import (
-	"encoding/gob"
 	"fmt"
 	"io"
-	"os"
-	"strconv"
 	"strings"
 )
 
-// RunLookup performs a lookup operation on an index file using a provided SimHash string.
-// It opens the index file, decodes the index data, verifies the existence of the original file,
-// parses the SimHash string, and retrieves the byte offsets associated with the SimHash from the index.
-// For each byte offset, it reads a chunk from the original file, extracts a phrase, and prints the
-// original file name, byte offset, and the extracted phrase.
+// RunLookup performs a lookup operation on an index file using a provided SimHash string and writes
+// every chunk indexed under it to w in the format selected by opts.Format.
 //
 // Parameters:
+//   - w: The writer the results are printed to, such as os.Stdout.
 //   - indexFile: The path to the index file.
 //   - simHashStr: The SimHash string to lookup in the index.
+//   - opts: The feature hash the SimHash was computed with, how to react to documents that changed after
+//     they were indexed, and how to print the results.
 //
 // Returns:
 //   - error: An error if any step of the lookup process fails, otherwise nil.
-func RunLookup(indexFile, simHashStr string) error {
-	// Open the index file for reading.
-	dataFile, err := os.Open(indexFile)
+func RunLookup(w io.Writer, indexFile, simHashStr string, opts QueryOptions) error {
+	if _, err := parseFormat(opts.Format); err != nil {
+		return err
+	}
+	results, err := Lookup(indexFile, simHashStr, opts)
 	if err != nil {
-		return fmt.Errorf("error opening index file: %v", err)
+		return err
 	}
-	defer dataFile.Close()
+	return writeResults(w, opts.Format, results, writeLookupText)
+}
 
-	// Decode the index data from the file.
-	var indexData IndexData
-	decoder := gob.NewDecoder(dataFile)
-	if err := decoder.Decode(&indexData); err != nil {
-		return fmt.Errorf("error decoding index data: %v", err)
+// Lookup retrieves every chunk indexed under a SimHash.
+// It opens the index file, decodes the index data, verifies the existence and freshness of the original files,
+// parses the SimHash string, and retrieves the postings associated with the SimHash from the index.
+// For each posting, it reads a chunk from the document it refers to and extracts a phrase from it.
+// When the index holds overlapping chunks, the postings of overlapping chunks are reported as one
+// region spanning all of them.
+//
+// Parameters:
+//   - indexFile: The path to the index file.
+//   - simHashStr: The SimHash string to lookup in the index.
+//   - opts: The feature hash the SimHash was computed with, if known, and how to react to documents
+//     that changed after they were indexed.
+//
+// Returns:
+//   - []Result: One result per posting or region, with the original file name, byte offset, length and phrase.
+//   - error: An error if any step of the lookup process fails, otherwise nil.
+func Lookup(indexFile, simHashStr string, opts QueryOptions) ([]Result, error) {
+	// Open the index file for querying.
+	index, err := openIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	if err := index.Header.checkQueryHash(opts.Hash); err != nil {
+		return nil, err
 	}
 
-	//Verify that the original file referenced in the index still exists.
-	if _, err := os.Stat(indexData.FileName); os.IsNotExist(err) {
-		return fmt.Errorf("original file %s not found", indexData.FileName)
+	//Verify that the original files referenced in the index still exist and are unchanged.
+	docs, err := openDocuments(index.Documents, opts.staleOrDefault())
+	if err != nil {
+		return nil, err
 	}
+	defer docs.Close()
 
-	//Parse the provided SimHash string into a uint64 value.
-	simHash, err := strconv.ParseUint(simHashStr, 16, 64)
+	//Parse the provided SimHash string into a fingerprint as wide as those of the index.
+	simHash, err := parseFingerprint(simHashStr, index.Header.fingerprintBits())
 	if err != nil {
-		return fmt.Errorf("invalid SimHash value: %v", err)
+		return nil, fmt.Errorf("invalid SimHash value: %v", err)
 	}
 
-	//Lookup the SimHash in the index to retrieve the byte offsets
-	offsets, exists := indexData.Index[simHash]
+	//Lookup the SimHash in the index to retrieve the postings
+	postings, exists := index.lookup(simHash)
 	if !exists {
-		return fmt.Errorf("SimHash not found in index: Ensure the file was indexed beforelooking up.")
+		return nil, fmt.Errorf("SimHash not found in index: Ensure the file was indexed beforelooking up.")
 	}
 
-	file, err := os.Open(indexData.FileName)
-	if err != nil {
-		return fmt.Errorf("error opening original file: %v", err)
+	// Report the passage covered by overlapping windows once, as a single region
+	spec := index.chunkSpec()
+	if spec.overlapping() {
+		postings = collapsePostings(simHash, postings, index.ChunkSize)
 	}
-	defer file.Close()
 
-	for _, offset := range offsets {
-		chunk := make([]byte, indexData.ChunkSize)
-		n, err := file.ReadAt(chunk, offset)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading chunk at offset %d: %v", offset, err)
+	aligned := spec.aligned()
+	results := make([]Result, 0, len(postings))
+	for _, posting := range postings {
+		chunk, err := docs.readChunk(posting, index.ChunkSize)
+		if err != nil {
+			return nil, err
 		}
-		chunk = chunk[:n]
 
 		// Convert chunk to string
 		chunkStr := string(chunk)
 
-		// Find first full word by skipping partial words
+		// Find first full word by skipping partial words, unless chunks are cut at word boundaries
 		startIdx := 0
-		for startIdx < len(chunkStr) && (chunkStr[startIdx] != ' ' && chunkStr[startIdx] != '\n') {
-			startIdx++
-		}
-
-		// Skip the space to reach the first full word
-		if startIdx < len(chunkStr) {
-			startIdx++
+		if !aligned {
+			for startIdx < len(chunkStr) && (chunkStr[startIdx] != ' ' && chunkStr[startIdx] != '\n') {
+				startIdx++
+			}
+
+			// Skip the space to reach the first full word
+			if startIdx < len(chunkStr) {
+				startIdx++
+			}
 		}
 
 		// Extract words from the valid start point
@@ -99,11 +123,27 @@ func RunLookup(indexFile, simHashStr string) error {
 			phrase = chunkStr[:end]
 		}
 
-		fmt.Printf("Original file: %s\n", indexData.FileName)
-		fmt.Printf("Byte offset: %d\n", offset)
-		fmt.Printf("Phrase: %s\n", phrase)
-		fmt.Println("----------")
+		results = append(results, Result{
+			File:    docs.path(posting),
+			SimHash: simHash,
+			Offset:  posting.Offset,
+			Length:  len(chunk),
+			Phrase:  phrase,
+		})
+	}
+	return results, nil
+}
 
+// writeLookupText prints lookup results in the human-readable layout of the lookup command.
+func writeLookupText(w io.Writer, results []Result) error {
+	for _, r := range results {
+		fmt.Fprintf(w, "Original file: %s\n", r.File)
+		fmt.Fprintf(w, "Byte offset: %d\n", r.Offset)
+		fmt.Fprintf(w, "Byte range: %d-%d\n", r.Offset, r.End())
+		fmt.Fprintf(w, "Phrase: %s\n", r.Phrase)
+		if _, err := fmt.Fprintln(w, "----------"); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -1,7 +1,7 @@
 package internals
 
 import (
-	"encoding/gob"
+	"io"
 	"os"
 	"testing"
 )
@@ -22,23 +22,18 @@ func TestRunLookup(t *testing.T) {
 
 	// Create a mock index data structure.
 	indexData := IndexData{
-		FileName:  originalFile,
+		Header:    newIndexHeader(),
+		Documents: []Document{{Path: originalFile}},
 		ChunkSize: chunkSize,
-		Index: map[uint64][]int64{
-			0xabcdef1234567890: {0}, // SimHash and byte offset
+		Index: map[Fingerprint][]Posting{
+			{0xabcdef1234567890}: {{DocID: 0, Offset: 0}}, // SimHash and posting
 		},
 	}
 
 	// Write the mock index data to the index file.
-	file, err := os.Create(indexFile)
-	if err != nil {
-		t.Fatalf("Failed to create index file: %v", err)
+	if err := saveIndexData(indexFile, indexData); err != nil {
+		t.Fatalf("Failed to write index file: %v", err)
 	}
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(indexData); err != nil {
-		t.Fatalf("Failed to encode index data: %v", err)
-	}
-	file.Close()               // Close the file before testing
 	defer os.Remove(indexFile) // Clean up
 
 	// Define test cases
@@ -71,7 +66,7 @@ func TestRunLookup(t *testing.T) {
 	// Run tests
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := RunLookup(tt.indexFile, tt.simHash)
+			err := RunLookup(io.Discard, tt.indexFile, tt.simHash, QueryOptions{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("RunLookup() error = %v, wantErr %v", err, tt.wantErr)
 			}
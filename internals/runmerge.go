@@ -0,0 +1,127 @@
+package internals
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// RunMerge combines several index files into a single consolidated index.
+//
+// Parameters:
+//   - w: The writer a summary of the merged index is printed to, such as os.Stdout.
+//   - outputFile: The path the merged index is written to.
+//   - indexFiles: The paths of the index files to merge.
+//
+// Returns:
+//   - error: An error if any index cannot be read, the indexes were built with incompatible settings,
+//     or the merged index cannot be written.
+//
+// The function performs the following steps:
+//  1. Decodes each index and refuses to merge if their chunk sizes or hashing configurations differ,
+//     since their fingerprints cannot be compared, or if they are weighted by tf·idf, since the
+//     document frequencies of the merged corpus differ from those each index was hashed with.
+//  2. Appends each document table to the merged one. A document that appears in more than one index
+//     with the same path and digest is kept once; the same path with a different digest is kept as
+//     a separate document and reported.
+//  3. Rewrites every posting to its document's position in the merged table and unions the postings
+//     of each hash, dropping duplicates.
+//  4. Rebuilds the permutation tables and, for MinHash indexes, the LSH bands over the signatures of
+//     every chunk, and atomically writes the merged index in the layout of the first index.
+func RunMerge(w io.Writer, outputFile string, indexFiles []string) error {
+	if len(indexFiles) == 0 {
+		return fmt.Errorf("no index files to merge")
+	}
+
+	merged := IndexData{Index: make(map[Fingerprint][]Posting)}
+	signatures := make(map[Posting][]uint64)
+	docIDs := make(map[Document]int)
+	paths := make(map[string]bool)
+
+	for i, indexFile := range indexFiles {
+		indexData, err := loadIndexData(indexFile)
+		if err != nil {
+			return fmt.Errorf("%s: %v", indexFile, err)
+		}
+		if indexData.Header.Weighting == WeightingTFIDF {
+			return fmt.Errorf("%s: cannot merge indexes weighted by %s, whose document frequencies only cover their own documents; index the documents together instead", indexFile, WeightingTFIDF)
+		}
+		if i == 0 {
+			merged.Header = indexData.Header
+			merged.Header.Created = time.Now().UTC()
+			merged.ChunkSize = indexData.ChunkSize
+		} else if indexData.chunkSpec() != merged.chunkSpec() {
+			return fmt.Errorf("%s: chunking %+v does not match chunking %+v of %s",
+				indexFile, indexData.chunkSpec(), merged.chunkSpec(), indexFiles[0])
+		} else if err := indexData.Header.sameHashing(merged.Header); err != nil {
+			return fmt.Errorf("%s: %v of %s", indexFile, err, indexFiles[0])
+		}
+
+		// Map this index's document IDs to positions in the merged document table
+		remap := make([]int, len(indexData.Documents))
+		for docID, doc := range indexData.Documents {
+			key := Document{Path: doc.Path, Digest: doc.Digest}
+			if id, ok := docIDs[key]; ok {
+				remap[docID] = id
+				continue
+			}
+			if paths[doc.Path] {
+				fmt.Fprintf(os.Stderr, "Warning: %s in %s differs from an earlier copy, keeping both\n", doc.Path, indexFile)
+			}
+			paths[doc.Path] = true
+			docIDs[key] = len(merged.Documents)
+			remap[docID] = len(merged.Documents)
+			merged.Documents = append(merged.Documents, doc)
+		}
+
+		for hash, postings := range indexData.Index {
+			for _, p := range postings {
+				if p.DocID < 0 || p.DocID >= len(remap) {
+					return fmt.Errorf("%s: posting refers to unknown document %d", indexFile, p.DocID)
+				}
+				p.DocID = remap[p.DocID]
+				merged.Index[hash] = append(merged.Index[hash], p)
+			}
+		}
+		for p, signature := range indexData.MinHash.signatureMap() {
+			if p.DocID >= 0 && p.DocID < len(remap) {
+				p.DocID = remap[p.DocID]
+				signatures[p] = signature
+			}
+		}
+	}
+
+	for hash, postings := range merged.Index {
+		merged.Index[hash] = dedupPostings(postings)
+	}
+	merged.Tables = newDefaultPermutationIndex(merged.Index, merged.Header.fingerprintBits())
+	if spec := merged.Header.minHashSpec(); spec.enabled() {
+		merged.MinHash = NewMinHashIndex(merged.Index, signatures, spec.Perms, spec.Bands)
+	}
+
+	if err := saveIndexData(outputFile, merged); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Merged %d indexes: %d documents, %d SimHash values\n", len(indexFiles), len(merged.Documents), len(merged.Index))
+	return nil
+}
+
+// dedupPostings sorts postings by document and offset and removes duplicates in place.
+func dedupPostings(postings []Posting) []Posting {
+	sort.Slice(postings, func(i, j int) bool {
+		if postings[i].DocID != postings[j].DocID {
+			return postings[i].DocID < postings[j].DocID
+		}
+		return postings[i].Offset < postings[j].Offset
+	})
+	out := postings[:0]
+	for _, p := range postings {
+		if len(out) == 0 || p != out[len(out)-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
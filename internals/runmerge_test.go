@@ -0,0 +1,111 @@
+package internals
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestRunMerge verifies that merging per-file indexes gives the same postings as indexing
+// all files together, and that a document present in several indexes is kept once.
+func TestRunMerge(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	os.WriteFile(first, []byte("the first document in the corpus"), 0644)
+	os.WriteFile(second, []byte("the second document in the corpus"), 0644)
+
+	a := filepath.Join(dir, "a.idx")
+	b := filepath.Join(dir, "b.idx")
+	both := filepath.Join(dir, "both.idx")
+	merged := filepath.Join(dir, "merged.idx")
+	for _, tc := range []struct {
+		inputs []string
+		output string
+	}{
+		{[]string{first}, a},
+		{[]string{first, second}, b},
+		{[]string{first, second}, both},
+	} {
+		if err := RunIndex(tc.inputs, tc.output, IndexOptions{ChunkSize: 16}); err != nil {
+			t.Fatalf("RunIndex(%v) failed: %v", tc.inputs, err)
+		}
+	}
+
+	if err := RunMerge(io.Discard, merged, []string{a, b}); err != nil {
+		t.Fatalf("RunMerge() failed: %v", err)
+	}
+
+	got, err := loadIndexData(merged)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	want, err := loadIndexData(both)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+
+	if len(got.Documents) != 2 || got.Documents[0].Path != first || got.Documents[1].Path != second {
+		t.Fatalf("Unexpected document table: %+v", got.Documents)
+	}
+	if !reflect.DeepEqual(sortedPostings(got.Index), sortedPostings(want.Index)) {
+		t.Errorf("Merged postings = %v, want %v", got.Index, want.Index)
+	}
+	if got.Tables == nil {
+		t.Error("Merged index has no permutation tables")
+	}
+}
+
+// TestRunMerge_Errors checks that mismatched chunk sizes, features or normalizations and unreadable
+// indexes are refused.
+func TestRunMerge_Errors(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	os.WriteFile(input, []byte("some text to index"), 0644)
+
+	small := filepath.Join(dir, "small.idx")
+	large := filepath.Join(dir, "large.idx")
+	if err := RunIndex([]string{input}, small, IndexOptions{ChunkSize: 16}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunIndex([]string{input}, large, IndexOptions{ChunkSize: 32}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	shingled := filepath.Join(dir, "shingled.idx")
+	if err := RunIndex([]string{input}, shingled, IndexOptions{ChunkSize: 16, Features: "words:1-2"}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	normalized := filepath.Join(dir, "normalized.idx")
+	if err := RunIndex([]string{input}, normalized, IndexOptions{ChunkSize: 16, Normalize: "fold"}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		inputs []string
+	}{
+		{"No inputs", nil},
+		{"Missing index", []string{small, filepath.Join(dir, "missing.idx")}},
+		{"Chunk size mismatch", []string{small, large}},
+		{"Features mismatch", []string{small, shingled}},
+		{"Normalization mismatch", []string{small, normalized}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RunMerge(io.Discard, filepath.Join(dir, "out.idx"), tt.inputs); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+// TestDedupPostings checks that postings are sorted and duplicates removed.
+func TestDedupPostings(t *testing.T) {
+	got := dedupPostings([]Posting{{1, 16, 16}, {0, 32, 16}, {1, 16, 16}, {0, 0, 16}, {0, 32, 16}})
+	want := []Posting{{0, 0, 16}, {0, 32, 16}, {1, 16, 16}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dedupPostings() = %v, want %v", got, want)
+	}
+}
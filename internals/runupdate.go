@@ -0,0 +1,227 @@
+package internals
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// updateAction describes what RunUpdate did with a single document.
+type updateAction int
+
+const (
+	docUnchanged updateAction = iota
+	docAdded
+	docAppended
+	docReindexed
+	docMissing
+)
+
+// RunUpdate brings an existing index up to date with its source files without rebuilding it from scratch.
+//
+// Parameters:
+//   - w: The writer a summary of the changes is printed to, such as os.Stdout.
+//   - indexFile: The path to the index file to update in place.
+//   - inputFiles: Additional files to add to the corpus, typically produced by ExpandInputs. The documents
+//     already listed in the index are always checked, so this may be empty.
+//
+// Returns:
+//   - error: An error if any step fails or the index is weighted by tf·idf, otherwise nil.
+//
+// The function performs the following steps:
+//  1. Decodes the existing index and rebuilds a FileIndex from its document table and postings. An
+//     index weighted by tf·idf is refused, since its document frequencies, and so the fingerprints
+//     of all its chunks, depend on every document; it must be rebuilt with RunIndex instead.
+//  2. For each document, compares the file's current size and modification time with the recorded ones.
+//     Unchanged files are skipped without being read.
+//  3. If a file has grown and its first Size bytes still match the recorded SHA-256 digest, only the chunks
+//     from the last complete chunk boundary onwards are removed and hashed again.
+//  4. Any other change causes every posting of that document to be removed and the file to be reindexed.
+//  5. Files that are not yet in the document table are validated and indexed as new documents.
+//  6. Rebuilds the permutation tables and, for MinHash indexes, the LSH bands, and atomically replaces the index file, keeping its layout.
+func RunUpdate(w io.Writer, indexFile string, inputFiles []string) error {
+	indexData, err := loadIndexData(indexFile)
+	if err != nil {
+		return err
+	}
+	if indexData.ChunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size in index: %d", indexData.ChunkSize)
+	}
+	if indexData.Header.Weighting == WeightingTFIDF {
+		return fmt.Errorf("cannot update an index weighted by %s, whose document frequencies cover the whole corpus; rebuild it with the index command", WeightingTFIDF)
+	}
+
+	fi := &FileIndex{
+		chunkSize:  indexData.ChunkSize,
+		chunking:   indexData.chunkSpec(),
+		features:   indexData.Header.featureSpec(),
+		hashing:    indexData.Header.hashSpec(),
+		bits:       indexData.Header.fingerprintBits(),
+		minHash:    indexData.Header.minHashSpec(),
+		signatures: indexData.MinHash.signatureMap(),
+		index:      &Index{m: indexData.Index},
+		numWorkers: runtime.NumCPU(),
+		documents:  indexData.Documents,
+	}
+	if fi.index.m == nil {
+		fi.index.m = make(map[Fingerprint][]Posting)
+	}
+
+	counts := make(map[updateAction]int)
+	known := make(map[string]bool)
+	for docID := range fi.documents {
+		known[fi.documents[docID].Path] = true
+		action, err := fi.updateDocument(docID)
+		if err != nil {
+			return fmt.Errorf("error updating %s: %v", fi.documents[docID].Path, err)
+		}
+		if action == docMissing {
+			fmt.Fprintf(os.Stderr, "Warning: %s no longer exists, keeping its postings\n", fi.documents[docID].Path)
+		}
+		counts[action]++
+	}
+
+	for _, inputFile := range inputFiles {
+		if known[inputFile] {
+			continue
+		}
+		known[inputFile] = true
+		if err := ValidateInputFile(inputFile); err != nil {
+			return fmt.Errorf("%s: %v", inputFile, err)
+		}
+		if err := fi.BuildIndex(context.Background(), inputFile); err != nil {
+			return fmt.Errorf("error building index: %v", err)
+		}
+		counts[docAdded]++
+	}
+
+	indexData.Documents = fi.documents
+	indexData.Index = fi.index.m
+	indexData.Tables, indexData.MinHash = newDefaultPermutationIndex(fi.index.m, fi.fingerprintBits()), nil
+	if fi.minHash.enabled() {
+		indexData.MinHash = NewMinHashIndex(fi.index.m, fi.signatures, fi.minHash.Perms, fi.minHash.Bands)
+	}
+	if err := saveIndexData(indexFile, indexData); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Added: %d, appended: %d, reindexed: %d, unchanged: %d, missing: %d\n",
+		counts[docAdded], counts[docAppended], counts[docReindexed], counts[docUnchanged], counts[docMissing])
+	return nil
+}
+
+// updateDocument brings the postings of a single document up to date with the file on disk.
+func (fi *FileIndex) updateDocument(docID int) (updateAction, error) {
+	doc := &fi.documents[docID]
+
+	info, err := os.Stat(doc.Path)
+	if os.IsNotExist(err) {
+		return docMissing, nil
+	}
+	if err != nil {
+		return docUnchanged, err
+	}
+	if doc.Digest != "" && info.Size() == doc.Size && info.ModTime().Equal(doc.ModTime) {
+		return docUnchanged, nil
+	}
+
+	file, err := os.Open(doc.Path)
+	if err != nil {
+		return docUnchanged, err
+	}
+	defer file.Close()
+
+	// Check whether the previously indexed content is an unchanged prefix of the file
+	digest := sha256.New()
+	appended := false
+	if doc.Digest != "" && info.Size() >= doc.Size {
+		if _, err := io.CopyN(digest, file, doc.Size); err != nil {
+			return docUnchanged, err
+		}
+		appended = hex.EncodeToString(digest.Sum(nil)) == doc.Digest
+	}
+
+	action := docReindexed
+	start := int64(0)
+	if appended {
+		if info.Size() == doc.Size {
+			// Only the modification time changed
+			doc.ModTime = info.ModTime()
+			return docUnchanged, nil
+		}
+		// Finish the digest over the appended bytes, then rehash from the start of the last
+		// chunk, which may have been a short final chunk when the file was first indexed.
+		// Chunk boundaries only depend on the content from the start of a chunk onwards,
+		// so the chunks before it stay valid with either chunking.
+		if _, err := io.Copy(digest, file); err != nil {
+			return docUnchanged, err
+		}
+		action = docAppended
+		start = fi.lastChunkStart(docID, doc.Size)
+		fi.removePostings(docID, start)
+		if _, err := fi.indexFrom(context.Background(), file, docID, start, nil); err != nil {
+			return docUnchanged, err
+		}
+	} else {
+		digest.Reset()
+		fi.removePostings(docID, 0)
+		if _, err := fi.indexFrom(context.Background(), file, docID, 0, digest); err != nil {
+			return docUnchanged, err
+		}
+	}
+
+	doc.Size = info.Size()
+	doc.ModTime = info.ModTime()
+	doc.Digest = hex.EncodeToString(digest.Sum(nil))
+	return action, nil
+}
+
+// removePostings deletes every posting of document docID at or after byte offset from, together
+// with its MinHash signature, dropping hashes that are left without postings.
+func (fi *FileIndex) removePostings(docID int, from int64) {
+	for hash, postings := range fi.index.m {
+		kept := postings[:0]
+		for _, p := range postings {
+			if p.DocID != docID || p.Offset < from {
+				kept = append(kept, p)
+			} else {
+				delete(fi.signatures, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(fi.index.m, hash)
+		} else {
+			fi.index.m[hash] = kept
+		}
+	}
+}
+
+// lastChunkStart returns the offset of the last indexed chunk of a document of the given size.
+// Fixed chunks start at multiples of the chunk size, and overlapping ones at multiples of the
+// stride, where the first window cut short by the end of the document is the one to redo;
+// otherwise the postings are searched.
+func (fi *FileIndex) lastChunkStart(docID int, size int64) int64 {
+	spec := fi.chunkSpec()
+	if spec.overlapping() {
+		if size <= int64(spec.Size) {
+			return 0
+		}
+		return ((size-int64(spec.Size))/int64(spec.Stride) + 1) * int64(spec.Stride)
+	}
+	if spec.Method == ChunkFixed {
+		return size / int64(fi.chunkSize) * int64(fi.chunkSize)
+	}
+	last := int64(0)
+	for _, postings := range fi.index.m {
+		for _, p := range postings {
+			if p.DocID == docID && p.Offset > last {
+				last = p.Offset
+			}
+		}
+	}
+	return last
+}
@@ -0,0 +1,105 @@
+package internals
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sortedPostings returns a copy of index with the postings of every hash sorted, so that
+// indexes built in different orders can be compared.
+func sortedPostings(index map[Fingerprint][]Posting) map[Fingerprint][]Posting {
+	out := make(map[Fingerprint][]Posting, len(index))
+	for hash, postings := range index {
+		cp := append([]Posting(nil), postings...)
+		sort.Slice(cp, func(i, j int) bool {
+			if cp[i].DocID != cp[j].DocID {
+				return cp[i].DocID < cp[j].DocID
+			}
+			return cp[i].Offset < cp[j].Offset
+		})
+		out[hash] = cp
+	}
+	return out
+}
+
+// TestRunUpdate verifies that an updated index matches one rebuilt from scratch after files
+// are appended to, rewritten, and added.
+func TestRunUpdate(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log.txt")
+	notesFile := filepath.Join(dir, "notes.txt")
+	newFile := filepath.Join(dir, "new.txt")
+	indexFile := filepath.Join(dir, "corpus.idx")
+	freshFile := filepath.Join(dir, "fresh.idx")
+
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	write(logFile, strings.Repeat("first entry of the log ", 5))
+	write(notesFile, "some notes about the project")
+	write(newFile, "a document added later")
+
+	if err := RunIndex([]string{logFile, notesFile}, indexFile, IndexOptions{ChunkSize: 16}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+
+	// Append to one file and rewrite the other; bump mtimes so the change is always noticed
+	future := time.Now().Add(time.Minute)
+	write(logFile, strings.Repeat("first entry of the log ", 5)+"second entry appended")
+	write(notesFile, "completely different notes")
+	os.Chtimes(logFile, future, future)
+	os.Chtimes(notesFile, future, future)
+
+	if err := RunUpdate(io.Discard, indexFile, []string{newFile}); err != nil {
+		t.Fatalf("RunUpdate() failed: %v", err)
+	}
+	if err := RunIndex([]string{logFile, notesFile, newFile}, freshFile, IndexOptions{ChunkSize: 16}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+
+	updated, err := loadIndexData(indexFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+	fresh, err := loadIndexData(freshFile)
+	if err != nil {
+		t.Fatalf("loadIndexData() failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(sortedPostings(updated.Index), sortedPostings(fresh.Index)) {
+		t.Errorf("Updated index differs from a fresh build:\n got %v\nwant %v", updated.Index, fresh.Index)
+	}
+	for i := range fresh.Documents {
+		if updated.Documents[i].Path != fresh.Documents[i].Path ||
+			updated.Documents[i].Size != fresh.Documents[i].Size ||
+			updated.Documents[i].Digest != fresh.Documents[i].Digest {
+			t.Errorf("Document %d = %+v, want %+v", i, updated.Documents[i], fresh.Documents[i])
+		}
+	}
+}
+
+// TestRunUpdate_Errors checks that invalid indexes and inputs are reported.
+func TestRunUpdate_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if err := RunUpdate(io.Discard, filepath.Join(dir, "missing.idx"), nil); err == nil {
+		t.Error("Expected error for missing index file, got nil")
+	}
+
+	input := filepath.Join(dir, "input.txt")
+	indexFile := filepath.Join(dir, "corpus.idx")
+	os.WriteFile(input, []byte("indexed content"), 0644)
+	if err := RunIndex([]string{input}, indexFile, IndexOptions{ChunkSize: 16}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunUpdate(io.Discard, indexFile, []string{filepath.Join(dir, "data.csv")}); err == nil {
+		t.Error("Expected error for invalid input file, got nil")
+	}
+}
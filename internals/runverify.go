@@ -0,0 +1,101 @@
+package internals
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+)
+
+// chunkRef is an indexed chunk of a single document, identified by its offset, length and SimHash.
+type chunkRef struct {
+	offset  int64
+	length  int
+	simhash Fingerprint
+}
+
+// RunVerify re-reads every indexed chunk from its source file and checks that it still hashes to
+// the SimHash it is indexed under.
+//
+// Parameters:
+//   - indexFile: The path to the index file to verify.
+//
+// Returns:
+//   - error: An error if the index cannot be read, or if any document is missing or any chunk no
+//     longer matches, so that the command exits with a failure status.
+//
+// The function performs the following steps:
+//  1. Decodes the index and groups its postings by document, ordered by offset.
+//  2. For each document, reports whether it still matches the size, modification time and digest
+//     recorded at build time.
+//  3. Reads each chunk at its recorded offset, recomputes its SimHash with the features, hash and weights
+//     the index was built with, and prints every offset whose hash differs from the indexed one. For
+//     MinHash indexes, the signature of the chunk is recomputed and compared as well.
+//  4. Prints a summary of the number of chunks checked and mismatched.
+func RunVerify(indexFile string) error {
+	indexData, err := loadIndexData(indexFile)
+	if err != nil {
+		return err
+	}
+
+	chunks := make([][]chunkRef, len(indexData.Documents))
+	total := 0
+	for hash, postings := range indexData.Index {
+		for _, p := range postings {
+			if p.DocID < 0 || p.DocID >= len(chunks) {
+				return fmt.Errorf("posting refers to unknown document %d", p.DocID)
+			}
+			chunks[p.DocID] = append(chunks[p.DocID], chunkRef{p.Offset, p.Length, hash})
+			total++
+		}
+	}
+
+	docs := &documentSet{docs: indexData.Documents, files: make(map[int]*os.File)}
+	defer docs.Close()
+	features, idf, width := indexData.Header.featureSpec(), indexData.Header.idfTable(), indexData.Header.fingerprintBits()
+	h := indexData.Header.hashSpec().new()
+	perms := indexData.Header.minHashSpec().Perms
+	signatures := indexData.MinHash.signatureMap()
+
+	mismatched, missing := 0, 0
+	for docID, doc := range indexData.Documents {
+		if _, err := os.Stat(doc.Path); os.IsNotExist(err) {
+			fmt.Printf("Document: %s: missing\n", doc.Path)
+			mismatched += len(chunks[docID])
+			missing++
+			continue
+		}
+		changed, err := documentChanged(doc)
+		if err != nil {
+			return fmt.Errorf("error checking original file %s: %v", doc.Path, err)
+		}
+		if changed {
+			fmt.Printf("Document: %s: changed since it was indexed\n", doc.Path)
+		} else {
+			fmt.Printf("Document: %s: ok\n", doc.Path)
+		}
+
+		refs := chunks[docID]
+		sort.Slice(refs, func(i, j int) bool { return refs[i].offset < refs[j].offset })
+		for _, ref := range refs {
+			posting := Posting{DocID: docID, Offset: ref.offset, Length: ref.length}
+			chunk, err := docs.readChunk(posting, indexData.ChunkSize)
+			if err != nil {
+				return err
+			}
+			if now := features.fingerprint(chunk, h, idf, width); now != ref.simhash {
+				fmt.Printf("Mismatch: %s byte offset %d: indexed %x, now %x\n", doc.Path, ref.offset, ref.simhash, now)
+				mismatched++
+			} else if perms > 0 && !slices.Equal(features.minHash(chunk, h, perms), signatures[posting]) {
+				fmt.Printf("Mismatch: %s byte offset %d: MinHash signature differs\n", doc.Path, ref.offset)
+				mismatched++
+			}
+		}
+	}
+
+	fmt.Printf("Checked %d chunks in %d documents: %d mismatched\n", total, len(indexData.Documents), mismatched)
+	if mismatched > 0 || missing > 0 {
+		return fmt.Errorf("index does not match its source files")
+	}
+	return nil
+}
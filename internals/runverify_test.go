@@ -0,0 +1,82 @@
+package internals
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunVerify checks that verification passes for a fresh index and fails once the source changes.
+func TestRunVerify(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	indexFile := filepath.Join(dir, "input.idx")
+	content := strings.Repeat("a sentence that will be indexed ", 4)
+	os.WriteFile(input, []byte(content), 0644)
+
+	if err := RunIndex([]string{input}, indexFile, IndexOptions{ChunkSize: 32}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunVerify(indexFile); err != nil {
+		t.Errorf("RunVerify() on a fresh index failed: %v", err)
+	}
+
+	// Same size, different words, so only re-hashing the chunks can spot the change
+	os.WriteFile(input, []byte(strings.Replace(content, "sentence", "paragraph", 1)[:len(content)]), 0644)
+	if err := RunVerify(indexFile); err == nil {
+		t.Error("Expected RunVerify() to fail after the source changed, got nil")
+	}
+
+	os.Remove(input)
+	if err := RunVerify(indexFile); err == nil {
+		t.Error("Expected RunVerify() to fail for a missing source, got nil")
+	}
+}
+
+// TestOpenDocuments_Stale verifies each stale policy against a modified document.
+func TestOpenDocuments_Stale(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	os.WriteFile(input, []byte("original content"), 0644)
+
+	fi := NewFileIndex(16, 1)
+	if err := fi.BuildIndex(context.Background(), input); err != nil {
+		t.Fatalf("BuildIndex() failed: %v", err)
+	}
+	docs := fi.documents
+
+	if _, err := openDocuments(docs, StaleFail); err != nil {
+		t.Errorf("openDocuments() on an unchanged document failed: %v", err)
+	}
+
+	// Touching the file without changing it is not a change
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(input, later, later)
+	if _, err := openDocuments(docs, StaleFail); err != nil {
+		t.Errorf("openDocuments() after touching the document failed: %v", err)
+	}
+
+	os.WriteFile(input, []byte("modified content"), 0644)
+	os.Chtimes(input, later.Add(time.Minute), later.Add(time.Minute))
+
+	tests := []struct {
+		stale   string
+		wantErr bool
+	}{
+		{StaleWarn, false},
+		{StaleIgnore, false},
+		{StaleFail, true},
+		{"sometimes", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stale, func(t *testing.T) {
+			_, err := openDocuments(docs, tt.stale)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("openDocuments(%q) error = %v, wantErr %v", tt.stale, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package internals
 
 import (
 	"hash"
-	"strings"
 )
 
 // SimHash is a technique for quickly estimating how similar two sets are.
@@ -14,35 +13,61 @@ import (
 // Returns:
 // - A 64-bit unsigned integer representing the SimHash of the input data.
 func computeSimHash(data []byte, h hash.Hash64) uint64 {
-	var simhash uint64
-	var sums [64]int
-	text := string(data)
-	words := strings.Fields(text)
-
-	counts := make(map[string]int)
-	for _, word := range words {
-		counts[word]++
-	}
+	return defaultFeatures.simHash(data, h)
+} // computeSimHash generates a SimHash for the given data using a reusable hash.Hash64.
+
+// simHash computes the 64-bit SimHash of data from the features the spec extracts from it, each
+// voting with the number of times it occurs.
+//
+// Parameters:
+// - data: The input data as a byte slice.
+// - h: A hash.Hash64 instance used to compute the hash of each feature.
+//
+// Returns:
+// - A 64-bit unsigned integer representing the SimHash of the input data.
+func (f featureSpec) simHash(data []byte, h hash.Hash64) uint64 {
+	return f.fingerprint(data, h, nil, FingerprintBits64)[0]
+}
+
+// fingerprint computes the SimHash of data with the given width from the features the spec
+// extracts from it, each voting with its tf·idf weight from idf, or with its count when idf is nil.
+// Every feature votes on the first 64 bits with its hash, and on the others with words derived
+// from the hash by featureWord.
+//
+// Parameters:
+// - data: The input data as a byte slice.
+// - h: A hash.Hash64 instance used to compute the hash of each feature.
+// - idf: The document frequencies of the corpus, or nil to weight features by their counts.
+// - width: The width of the fingerprint in bits, FingerprintBits64, FingerprintBits128 or FingerprintBits256.
+//
+// Returns:
+// - A Fingerprint holding the SimHash of the input data, whose words past width are zero.
+func (f featureSpec) fingerprint(data []byte, h hash.Hash64, idf *idfTable, width int) Fingerprint {
+	var simhash Fingerprint
+	var sums [FingerprintBits256]int64
+	words := width / 64
 
-	for word, cnt := range counts {
-		h.Reset()
-		h.Write([]byte(word))
-		hash := h.Sum64()
+	for feature, cnt := range f.counts(data) {
+		hash := featureHash(h, feature)
+		vote := idf.vote(hash, cnt)
 
-		for i := 0; i < 64; i++ {
-			if (hash & (1 << i)) != 0 {
-				sums[i] += cnt
-			} else {
-				sums[i] -= cnt
+		for w := 0; w < words; w++ {
+			word, wsums := featureWord(hash, w), sums[w*64:w*64+64]
+			for i := 0; i < 64; i++ {
+				if (word & (1 << i)) != 0 {
+					wsums[i] += vote
+				} else {
+					wsums[i] -= vote
+				}
 			}
 		}
 	}
 
-	for i := 0; i < 64; i++ {
+	for i := 0; i < width; i++ {
 		if sums[i] >= 0 {
-			simhash |= 1 << i
+			simhash[i/64] |= 1 << (i % 64)
 		}
 	}
 
 	return simhash
-} // computeSimHash generates a SimHash for the given data using a reusable hash.Hash64.
+}
@@ -0,0 +1,382 @@
+package internals
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// The sorted layout stores the index body as flat arrays that can be memory-mapped and
+// binary-searched without decoding the whole index. All integers are little-endian.
+//
+//	size      content
+//	4         length of the metadata block, uint32
+//	variable  gob-encoded sortedMeta: the document table and chunk size
+//	8         number of records, uint64
+//	variable  records sorted by SimHash: SimHash as 1, 2 or 4 uint64 words from the lowest bits
+//	          up, as wide as the fingerprints of the header, index of its first posting uint64
+//	8         number of postings, uint64
+//	16 each   postings: document ID uint32, chunk length uint32, byte offset int64
+//	4         number of permutation blocks, uint32, 0 when the index has no permutation tables
+//	4 each    one table per block: the positions of the records ordered by the value of that
+//	          block, uint32, as in PermutationIndex.Tables
+//	8         number of document frequencies, uint64, 0 unless the index uses tf·idf weighting
+//	12 each   document frequencies sorted by feature hash: feature hash uint64, number of chunks
+//	          containing the feature uint32
+//
+// The postings of record i run from its first posting up to the first posting of record
+// i+1, or to the end of the postings area for the last record. With 64-bit fingerprints a
+// record is 16 bytes long, as in indexes written before widths were configurable. Indexes
+// written before the permutation tables were stored end after the postings, and are read as
+// having no tables; those written before the document frequencies were stored end after the
+// tables, and keep their frequencies in the header.
+const (
+	sortedPostingSize  = 16
+	sortedPositionSize = 4
+	sortedDocFreqSize  = 12
+)
+
+// sortedRecordSize returns the size of a record holding a fingerprint of the given width.
+func sortedRecordSize(width int) int {
+	return width/8 + 8
+}
+
+// sortedMeta is the part of a sorted-layout index that is not stored in fixed-width arrays.
+type sortedMeta struct {
+	Documents []Document
+	ChunkSize int
+}
+
+// sortedIndex is a read-only view of the body of a sorted-layout index.
+type sortedIndex struct {
+	meta       sortedMeta
+	width      int
+	recordSize int
+	records    []byte
+	postings   []byte
+	count      int
+	blocks     int
+	tables     []byte
+	docFreqs   []byte
+}
+
+// encodeSorted writes the body of indexData, whose fingerprints are width bits wide, in the sorted
+// layout, together with the document frequencies of the header, which may be nil.
+func encodeSorted(w io.Writer, indexData IndexData, width int, docFreqs map[uint64]int) error {
+	var meta bytes.Buffer
+	if err := gob.NewEncoder(&meta).Encode(sortedMeta{indexData.Documents, indexData.ChunkSize}); err != nil {
+		return err
+	}
+	if meta.Len() > math.MaxUint32 {
+		return fmt.Errorf("document table of %d bytes is too large for the sorted layout", meta.Len())
+	}
+
+	hashes := make([]Fingerprint, 0, len(indexData.Index))
+	for hash, postings := range indexData.Index {
+		hashes = append(hashes, hash)
+		for _, p := range postings {
+			if p.DocID < 0 || p.DocID > math.MaxUint32 || p.Length < 0 || p.Length > math.MaxUint32 || p.Offset < 0 {
+				return fmt.Errorf("posting %+v does not fit in the sorted layout", p)
+			}
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].less(hashes[j]) })
+
+	features := make([]uint64, 0, len(docFreqs))
+	for feature, df := range docFreqs {
+		if df < 0 || df > math.MaxUint32 {
+			return fmt.Errorf("document frequency %d does not fit in the sorted layout", df)
+		}
+		features = append(features, feature)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i] < features[j] })
+
+	// The tables hold positions into the sorted fingerprints, which are the records
+	tables := indexData.Tables
+	if tables != nil && (len(tables.Keys) != len(hashes) || tables.Bits != width || len(tables.Tables) != tables.Blocks) {
+		return fmt.Errorf("permutation tables do not match the index")
+	}
+
+	sw := &sortedWriter{w: w, buf: make([]byte, 0, sortedRecordSize(FingerprintBits256))}
+	sw.uint32(uint32(meta.Len()))
+	sw.write(meta.Bytes())
+
+	sw.uint64(uint64(len(hashes)))
+	first := uint64(0)
+	for _, hash := range hashes {
+		sw.write(binary.LittleEndian.AppendUint64(appendFingerprint(sw.buf[:0], hash, width), first))
+		first += uint64(len(indexData.Index[hash]))
+	}
+
+	sw.uint64(first)
+	var posting [sortedPostingSize]byte
+	for _, hash := range hashes {
+		for _, p := range indexData.Index[hash] {
+			binary.LittleEndian.PutUint32(posting[0:], uint32(p.DocID))
+			binary.LittleEndian.PutUint32(posting[4:], uint32(p.Length))
+			binary.LittleEndian.PutUint64(posting[8:], uint64(p.Offset))
+			sw.write(posting[:])
+		}
+	}
+
+	if tables == nil {
+		sw.uint32(0)
+	} else {
+		sw.uint32(uint32(tables.Blocks))
+		for _, table := range tables.Tables {
+			for _, position := range table {
+				sw.uint32(position)
+			}
+		}
+	}
+
+	sw.uint64(uint64(len(features)))
+	for _, feature := range features {
+		sw.write(binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint64(sw.buf[:0], feature), uint32(docFreqs[feature])))
+	}
+	return sw.err
+}
+
+// sortedWriter writes the fields of a sorted-layout body, keeping the first error so that it is
+// reported once the body is complete and no later field is written after a failed one.
+type sortedWriter struct {
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// write writes b unless an earlier write failed.
+func (sw *sortedWriter) write(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+// uint32 writes v as a little-endian uint32.
+func (sw *sortedWriter) uint32(v uint32) {
+	sw.write(binary.LittleEndian.AppendUint32(sw.buf[:0], v))
+}
+
+// uint64 writes v as a little-endian uint64.
+func (sw *sortedWriter) uint64(v uint64) {
+	sw.write(binary.LittleEndian.AppendUint64(sw.buf[:0], v))
+}
+
+// parseSorted interprets body as a sorted-layout index body holding fingerprints of the given
+// width. The returned view refers to body directly, so body must stay valid (for example, mapped)
+// while the view is in use.
+func parseSorted(body []byte, width int) (*sortedIndex, error) {
+	errCorrupt := fmt.Errorf("error decoding index data: sorted index is truncated or corrupt")
+
+	if len(body) < 4 {
+		return nil, errCorrupt
+	}
+	metaLen := int(binary.LittleEndian.Uint32(body))
+	body = body[4:]
+	if len(body) < metaLen+8 {
+		return nil, errCorrupt
+	}
+	s := sortedIndex{width: width, recordSize: sortedRecordSize(width)}
+	if err := gob.NewDecoder(bytes.NewReader(body[:metaLen])).Decode(&s.meta); err != nil {
+		return nil, fmt.Errorf("error decoding index data: %v", err)
+	}
+	body = body[metaLen:]
+
+	count := binary.LittleEndian.Uint64(body)
+	body = body[8:]
+	if count > uint64(len(body)/s.recordSize) {
+		return nil, errCorrupt
+	}
+	s.count = int(count)
+	s.records = body[:s.count*s.recordSize]
+	body = body[s.count*s.recordSize:]
+
+	if len(body) < 8 {
+		return nil, errCorrupt
+	}
+	postingCount := binary.LittleEndian.Uint64(body)
+	body = body[8:]
+	if postingCount > uint64(len(body)/sortedPostingSize) {
+		return nil, errCorrupt
+	}
+	s.postings = body[:postingCount*sortedPostingSize]
+	body = body[postingCount*sortedPostingSize:]
+
+	if len(body) == 0 {
+		return &s, nil
+	}
+	if len(body) < 4 {
+		return nil, errCorrupt
+	}
+	blocks := binary.LittleEndian.Uint32(body)
+	body = body[4:]
+	if blocks != 0 && (blocks < uint32(width/64) || blocks > uint32(width)) {
+		return nil, errCorrupt
+	}
+	tablesSize := uint64(blocks) * uint64(s.count) * sortedPositionSize
+	if uint64(len(body)) < tablesSize {
+		return nil, errCorrupt
+	}
+	s.blocks = int(blocks)
+	s.tables = body[:tablesSize]
+	body = body[tablesSize:]
+
+	if len(body) == 0 {
+		return &s, nil
+	}
+	if len(body) < 8 {
+		return nil, errCorrupt
+	}
+	docFreqCount := binary.LittleEndian.Uint64(body)
+	body = body[8:]
+	if docFreqCount != uint64(len(body)/sortedDocFreqSize) || len(body)%sortedDocFreqSize != 0 {
+		return nil, errCorrupt
+	}
+	if docFreqCount > 0 {
+		s.docFreqs = body
+	}
+	return &s, nil
+}
+
+// hash returns the SimHash of record i.
+func (s *sortedIndex) hash(i int) Fingerprint {
+	return readFingerprint(s.records[i*s.recordSize:], s.width)
+}
+
+// postingsAt decodes the postings of record i.
+func (s *sortedIndex) postingsAt(i int) []Posting {
+	start := binary.LittleEndian.Uint64(s.records[(i+1)*s.recordSize-8:])
+	end := uint64(len(s.postings) / sortedPostingSize)
+	if i+1 < s.count {
+		end = binary.LittleEndian.Uint64(s.records[(i+2)*s.recordSize-8:])
+	}
+	if start > end || end > uint64(len(s.postings)/sortedPostingSize) {
+		return nil
+	}
+
+	postings := make([]Posting, 0, end-start)
+	for j := start; j < end; j++ {
+		p := s.postings[j*sortedPostingSize:]
+		postings = append(postings, Posting{
+			DocID:  int(binary.LittleEndian.Uint32(p)),
+			Length: int(binary.LittleEndian.Uint32(p[4:])),
+			Offset: int64(binary.LittleEndian.Uint64(p[8:])),
+		})
+	}
+	return postings
+}
+
+// lookup binary-searches the records for hash and returns its postings.
+func (s *sortedIndex) lookup(hash Fingerprint) ([]Posting, bool) {
+	i := sort.Search(s.count, func(i int) bool { return !s.hash(i).less(hash) })
+	if i == s.count || s.hash(i) != hash {
+		return nil, false
+	}
+	return s.postingsAt(i), true
+}
+
+// position returns entry j of permutation table t, the position of a record. Positions past the
+// last record, which only a corrupt file holds, are clamped to it.
+func (s *sortedIndex) position(t, j int) int {
+	i := int(binary.LittleEndian.Uint32(s.tables[(t*s.count+j)*sortedPositionSize:]))
+	return min(i, s.count-1)
+}
+
+// nearby finds the records with hashes within maxDistance of simHash. When the permutation tables
+// support maxDistance, each table is binary-searched for the records sharing the query's block, as
+// in PermutationIndex.nearbyHashes; otherwise every record is scanned. Only the postings of
+// matching records are decoded.
+func (s *sortedIndex) nearby(simHash Fingerprint, maxDistance, topK int) []fuzzyMatch {
+	var matches []fuzzyMatch
+	match := func(i int) {
+		hash := s.hash(i)
+		distance := simHash.distance(hash)
+		if distance > maxDistance {
+			return
+		}
+		for _, posting := range s.postingsAt(i) {
+			matches = append(matches, fuzzyMatch{hash, distance, posting})
+		}
+	}
+
+	if s.blocks == 0 || maxDistance >= s.blocks {
+		for i := 0; i < s.count; i++ {
+			match(i)
+		}
+		return sortMatches(matches, topK)
+	}
+
+	seen := make(map[int]bool)
+	for t := 0; t < s.blocks; t++ {
+		start, width := permutationBlock(t, s.width, s.blocks)
+		prefix := simHash.bitsAt(start, width)
+		lo := sort.Search(s.count, func(j int) bool { return s.hash(s.position(t, j)).bitsAt(start, width) >= prefix })
+		for j := lo; j < s.count; j++ {
+			i := s.position(t, j)
+			if s.hash(i).bitsAt(start, width) != prefix {
+				break
+			}
+			if !seen[i] {
+				seen[i] = true
+				match(i)
+			}
+		}
+	}
+	return sortMatches(matches, topK)
+}
+
+// sortedDocFreq binary-searches the document frequency area of a sorted-layout index for the
+// number of chunks containing the feature with the given hash, 0 if none does.
+func sortedDocFreq(docFreqs []byte, featureHash uint64) int {
+	n := len(docFreqs) / sortedDocFreqSize
+	i := sort.Search(n, func(i int) bool { return binary.LittleEndian.Uint64(docFreqs[i*sortedDocFreqSize:]) >= featureHash })
+	if i == n || binary.LittleEndian.Uint64(docFreqs[i*sortedDocFreqSize:]) != featureHash {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(docFreqs[i*sortedDocFreqSize+8:]))
+}
+
+// docFreqMap materializes the document frequencies, as stored in the header of the gob layout.
+func (s *sortedIndex) docFreqMap() map[uint64]int {
+	docFreqs := make(map[uint64]int, len(s.docFreqs)/sortedDocFreqSize)
+	for i := 0; i < len(s.docFreqs); i += sortedDocFreqSize {
+		docFreqs[binary.LittleEndian.Uint64(s.docFreqs[i:])] = int(binary.LittleEndian.Uint32(s.docFreqs[i+8:]))
+	}
+	return docFreqs
+}
+
+// permutationIndex materializes the permutation tables, or returns nil when the index has none.
+func (s *sortedIndex) permutationIndex() *PermutationIndex {
+	if s.blocks == 0 {
+		return nil
+	}
+	p := &PermutationIndex{
+		Blocks: s.blocks,
+		Bits:   s.width,
+		Keys:   make([]Fingerprint, s.count),
+		Tables: make([][]uint32, s.blocks),
+	}
+	for i := range p.Keys {
+		p.Keys[i] = s.hash(i)
+	}
+	for t := range p.Tables {
+		p.Tables[t] = make([]uint32, s.count)
+		for j := range p.Tables[t] {
+			p.Tables[t][j] = uint32(s.position(t, j))
+		}
+	}
+	return p
+}
+
+// toMap materializes every record into a map, as used by commands that rewrite the index.
+func (s *sortedIndex) toMap() map[Fingerprint][]Posting {
+	index := make(map[Fingerprint][]Posting, s.count)
+	for i := 0; i < s.count; i++ {
+		index[s.hash(i)] = s.postingsAt(i)
+	}
+	return index
+}
@@ -0,0 +1,283 @@
+package internals
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildLayouts indexes the same input in both layouts and returns the two index paths.
+func buildLayouts(t testing.TB, content string, chunkSize int) (gobFile, sortedFile string) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create input file: %v", err)
+	}
+	gobFile = filepath.Join(dir, "gob.idx")
+	sortedFile = filepath.Join(dir, "sorted.idx")
+	if err := RunIndex([]string{input}, gobFile, IndexOptions{ChunkSize: chunkSize}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunIndex([]string{input}, sortedFile, IndexOptions{ChunkSize: chunkSize, Layout: layoutSorted}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	return gobFile, sortedFile
+}
+
+// TestSortedIndex_MatchesGob verifies that a memory-mapped sorted index answers lookups and
+// fuzzy queries exactly like the gob layout built from the same input.
+func TestSortedIndex_MatchesGob(t *testing.T) {
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 40) +
+		strings.Repeat("pack my box with five dozen liquor jugs. ", 40)
+	gobFile, sortedFile := buildLayouts(t, content, 32)
+
+	gobIndex, err := openIndex(gobFile)
+	if err != nil {
+		t.Fatalf("openIndex(gob) failed: %v", err)
+	}
+	defer gobIndex.Close()
+	sortedIndex, err := openIndex(sortedFile)
+	if err != nil {
+		t.Fatalf("openIndex(sorted) failed: %v", err)
+	}
+	defer sortedIndex.Close()
+
+	if sortedIndex.sorted == nil {
+		t.Fatal("Sorted index was not opened in place")
+	}
+	if sortedIndex.sorted.blocks != defaultPermutationBlocks {
+		t.Errorf("Sorted index holds %d permutation tables, want %d", sortedIndex.sorted.blocks, defaultPermutationBlocks)
+	}
+	if sortedIndex.ChunkSize != 32 || !reflect.DeepEqual(sortedIndex.Documents, gobIndex.Documents) {
+		t.Errorf("Sorted index metadata = %d %+v, want %d %+v", sortedIndex.ChunkSize, sortedIndex.Documents, 32, gobIndex.Documents)
+	}
+
+	for hash := range gobIndex.Index {
+		want, _ := gobIndex.lookup(hash)
+		got, ok := sortedIndex.lookup(hash)
+		if !ok || !reflect.DeepEqual(sortedPostings(map[Fingerprint][]Posting{{}: got}), sortedPostings(map[Fingerprint][]Posting{{}: want})) {
+			t.Errorf("lookup(%x) = %v, want %v", hash, got, want)
+		}
+		for _, d := range []int{0, 3, 10} {
+			if got, want := sortedIndex.nearby(hash, d, 0), gobIndex.nearby(hash, d, 0); !reflect.DeepEqual(got, want) {
+				t.Errorf("nearby(%x, %d) = %v, want %v", hash, d, got, want)
+			}
+		}
+	}
+	if _, ok := sortedIndex.lookup(Fingerprint{}); ok {
+		t.Error("lookup(0) found a hash that was never indexed")
+	}
+
+	// Commands that rewrite the index see the same postings in both layouts
+	loaded, err := loadIndexData(sortedFile)
+	if err != nil {
+		t.Fatalf("loadIndexData(sorted) failed: %v", err)
+	}
+	if !reflect.DeepEqual(sortedPostings(loaded.Index), sortedPostings(gobIndex.Index)) {
+		t.Errorf("loadIndexData(sorted) postings differ from the gob layout")
+	}
+	if !reflect.DeepEqual(loaded.Tables, gobIndex.Tables) {
+		t.Errorf("loadIndexData(sorted) permutation tables differ from the gob layout")
+	}
+}
+
+// TestSortedIndex_WithoutTables verifies that sorted indexes written before the permutation
+// tables were stored are still read, and answer fuzzy queries by scanning the records.
+func TestSortedIndex_WithoutTables(t *testing.T) {
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 20)
+	gobFile, sortedFile := buildLayouts(t, content, 32)
+	gobIndex, err := openIndex(gobFile)
+	if err != nil {
+		t.Fatalf("openIndex(gob) failed: %v", err)
+	}
+	defer gobIndex.Close()
+
+	// Cut the file after the postings, where older indexes ended, dropping the tables and the empty
+	// document frequency area
+	raw, err := os.ReadFile(sortedFile)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	tablesSize := 4 + defaultPermutationBlocks*len(gobIndex.Index)*sortedPositionSize + 8
+	older := filepath.Join(t.TempDir(), "older.idx")
+	os.WriteFile(older, raw[:len(raw)-tablesSize], 0644)
+
+	index, err := openIndex(older)
+	if err != nil {
+		t.Fatalf("openIndex() of an index without tables failed: %v", err)
+	}
+	defer index.Close()
+	if index.sorted.blocks != 0 {
+		t.Errorf("Index without tables reports %d blocks", index.sorted.blocks)
+	}
+	for hash := range gobIndex.Index {
+		if got, want := index.nearby(hash, 3, 0), gobIndex.nearby(hash, 3, 0); !reflect.DeepEqual(got, want) {
+			t.Errorf("nearby(%x, 3) = %v, want %v", hash, got, want)
+		}
+	}
+	if loaded, err := loadIndexData(older); err != nil || loaded.Tables != nil {
+		t.Errorf("loadIndexData() = %v tables, %v, want none", loaded.Tables, err)
+	}
+}
+
+// TestParseSorted_Corrupt checks that truncated bodies are rejected rather than read out of bounds.
+func TestParseSorted_Corrupt(t *testing.T) {
+	_, sortedFile := buildLayouts(t, "some text to index in the sorted layout", 8)
+	raw, err := os.ReadFile(sortedFile)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	truncated := filepath.Join(t.TempDir(), "truncated.idx")
+	os.WriteFile(truncated, raw[:len(raw)-5], 0644)
+
+	if _, err := openIndex(truncated); err == nil {
+		t.Error("Expected error for truncated sorted index, got nil")
+	}
+	if _, err := parseSorted(nil, FingerprintBits64); err == nil {
+		t.Error("Expected error for empty body, got nil")
+	}
+}
+
+func benchmarkOpenLookup(b *testing.B, sorted bool) {
+	var content strings.Builder
+	for i := 0; content.Len() < 4<<20; i++ {
+		content.WriteString(strings.Repeat("word", i%7+1))
+		content.WriteString(" ")
+		content.WriteString(strings.Repeat("text", i%13+1))
+		content.WriteString(" ")
+	}
+	gobFile, sortedFile := buildLayouts(b, content.String(), 64)
+	indexFile := gobFile
+	if sorted {
+		indexFile = sortedFile
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index, err := openIndex(indexFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		index.lookup(Fingerprint{uint64(i)})
+		index.Close()
+	}
+}
+
+// BenchmarkOpenLookup_Gob measures opening a gob-layout index and answering one lookup.
+func BenchmarkOpenLookup_Gob(b *testing.B) { benchmarkOpenLookup(b, false) }
+
+// BenchmarkOpenLookup_Sorted measures opening a sorted-layout index and answering one lookup.
+func BenchmarkOpenLookup_Sorted(b *testing.B) { benchmarkOpenLookup(b, true) }
+
+// failingWriter accepts limit bytes and fails every write after them.
+type failingWriter struct {
+	limit int
+}
+
+// Write accepts p if it fits in the remaining limit.
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, errors.New("disk full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+// TestEncodeSorted_Errors checks that failed writes and postings that do not fit in the fixed-width
+// fields are reported rather than producing a corrupt body.
+func TestEncodeSorted_Errors(t *testing.T) {
+	index := map[Fingerprint][]Posting{{0x1}: {{DocID: 0, Offset: 0, Length: 8}}, {0x2}: {{DocID: 0, Offset: 8, Length: 8}}}
+	indexData := IndexData{Documents: []Document{{Path: "a.txt"}}, ChunkSize: 8, Index: index, Tables: newDefaultPermutationIndex(index, FingerprintBits64)}
+
+	var buf bytes.Buffer
+	if err := encodeSorted(&buf, indexData, FingerprintBits64, nil); err != nil {
+		t.Fatalf("encodeSorted() failed: %v", err)
+	}
+	for limit := 0; limit < buf.Len(); limit += 7 {
+		if err := encodeSorted(&failingWriter{limit}, indexData, FingerprintBits64, nil); err == nil {
+			t.Errorf("Expected encodeSorted() to report a write failing after %d bytes, got nil", limit)
+		}
+	}
+
+	for _, p := range []Posting{{DocID: -1, Length: 8}, {DocID: 0, Offset: -8, Length: 8}, {DocID: 0, Length: math.MaxUint32 + 1}} {
+		bad := IndexData{Documents: indexData.Documents, ChunkSize: 8, Index: map[Fingerprint][]Posting{{0x1}: {p}}}
+		if err := encodeSorted(io.Discard, bad, FingerprintBits64, nil); err == nil {
+			t.Errorf("Expected encodeSorted() to reject posting %+v, got nil", p)
+		}
+	}
+}
+
+// TestSortedIndex_DocFreqs verifies that a sorted-layout index keeps the document frequencies of
+// tf·idf weighting in its body rather than its header, and that query text and loading still use
+// them.
+func TestSortedIndex_DocFreqs(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []string{filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")}
+	text := "the slow red fox sleeps under the tree"
+	os.WriteFile(inputs[0], []byte("the quick brown fox jumps over the lazy dog"), 0644)
+	os.WriteFile(inputs[1], []byte(text), 0644)
+
+	gobFile, sortedFile := filepath.Join(dir, "gob.idx"), filepath.Join(dir, "sorted.idx")
+	for _, layout := range []string{layoutGob, layoutSorted} {
+		opts := IndexOptions{ChunkSize: 4096, Weighting: WeightingTFIDF, Layout: layout}
+		if err := RunIndex(inputs, filepath.Join(dir, layout+".idx"), opts); err != nil {
+			t.Fatalf("RunIndex() failed: %v", err)
+		}
+	}
+
+	header, err := readIndexHeader(sortedFile)
+	if err != nil {
+		t.Fatalf("readIndexHeader() failed: %v", err)
+	}
+	if header.DocFreqs != nil || header.DocCount != 2 {
+		t.Errorf("Sorted header holds %d chunks and document frequencies %v, want 2 and none", header.DocCount, header.DocFreqs)
+	}
+
+	gobData, err := loadIndexData(gobFile)
+	if err != nil {
+		t.Fatalf("loadIndexData(gob) failed: %v", err)
+	}
+	sortedData, err := loadIndexData(sortedFile)
+	if err != nil {
+		t.Fatalf("loadIndexData(sorted) failed: %v", err)
+	}
+	if len(gobData.Header.DocFreqs) == 0 || !reflect.DeepEqual(sortedData.Header.DocFreqs, gobData.Header.DocFreqs) {
+		t.Errorf("loadIndexData(sorted) document frequencies = %v, want %v", sortedData.Header.DocFreqs, gobData.Header.DocFreqs)
+	}
+
+	index, err := openIndex(sortedFile)
+	if err != nil {
+		t.Fatalf("openIndex(sorted) failed: %v", err)
+	}
+	defer index.Close()
+	idf := index.idfTable()
+	for feature, want := range gobData.Header.DocFreqs {
+		if got := idf.docFreq(feature); got != want {
+			t.Errorf("docFreq(%x) = %d, want %d", feature, got, want)
+		}
+	}
+	if got := idf.docFreq(0); got != 0 {
+		t.Errorf("docFreq() of a feature the corpus lacks = %d, want 0", got)
+	}
+
+	gobQuery, err := ResolveQuery(gobFile, "", text, "")
+	if err != nil {
+		t.Fatalf("ResolveQuery(gob) failed: %v", err)
+	}
+	sortedQuery, err := ResolveQuery(sortedFile, "", text, "")
+	if err != nil {
+		t.Fatalf("ResolveQuery(sorted) failed: %v", err)
+	}
+	if sortedQuery != gobQuery {
+		t.Errorf("ResolveQuery(sorted) = %s, want %s as for the gob layout", sortedQuery, gobQuery)
+	}
+	if results, err := Lookup(sortedFile, sortedQuery, QueryOptions{}); err != nil || len(results) != 1 || results[0].File != inputs[1] {
+		t.Errorf("Lookup(sorted) = %+v, %v, want %s", results, err, inputs[1])
+	}
+}
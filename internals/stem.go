@@ -0,0 +1,261 @@
+package internals
+
+// StemPorter is the stemmer that reduces English words to their stems with the Porter algorithm.
+const StemPorter = "porter"
+
+// porterStemmer holds the state of the Porter stemming algorithm for one word. b[:k+1] is the
+// word being stemmed, and j marks the end of the stem left when a suffix is removed.
+//
+// This is a direct translation of Martin Porter's reference implementation in C, including its
+// departures from the published algorithm ("bli" rather than "abli" in step 2, and the extra
+// "logi" rule), so that stems match those of other widely used Porter stemmers.
+type porterStemmer struct {
+	b    []byte
+	k, j int
+}
+
+// porterStem returns the stem of an English word, such as "connect" for "connections" and
+// "happi" for "happy". Words of up to two letters, and words containing anything other than the
+// lowercase letters a to z, are returned unchanged.
+//
+// Parameters:
+//   - word: The lowercase word to stem.
+//
+// Returns:
+//   - string: The stem of the word.
+func porterStem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	p := &porterStemmer{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.step2()
+		p.step3()
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// cons reports whether b[i] is a consonant. A y is a consonant unless it follows a consonant.
+func (p *porterStemmer) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !p.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of vowel-consonant sequences in b[:j+1]: with [C] an optional run of
+// consonants and [V] an optional run of vowels, every stem has the form [C](VC){m}[V].
+func (p *porterStemmer) m() int {
+	n, i := 0, 0
+	for ; i <= p.j && p.cons(i); i++ {
+	}
+	for i <= p.j {
+		for ; i <= p.j && !p.cons(i); i++ {
+		}
+		if i > p.j {
+			break
+		}
+		n++
+		for ; i <= p.j && p.cons(i); i++ {
+		}
+	}
+	return n
+}
+
+// vowelInStem reports whether b[:j+1] contains a vowel.
+func (p *porterStemmer) vowelInStem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleC reports whether b[i-1:i+1] is a double consonant.
+func (p *porterStemmer) doubleC(i int) bool {
+	return i >= 1 && p.b[i] == p.b[i-1] && p.cons(i)
+}
+
+// cvc reports whether b[i-2:i+1] is consonant-vowel-consonant and the last consonant is not w, x
+// or y. This is used to restore an e at the end of short words, as in hop(e), cav(e) and lov(e).
+func (p *porterStemmer) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	switch p.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether b[:k+1] ends with s, and if so sets j to the end of the stem before it.
+func (p *porterStemmer) ends(s string) bool {
+	if len(s) > p.k+1 || string(p.b[p.k+1-len(s):p.k+1]) != s {
+		return false
+	}
+	p.j = p.k - len(s)
+	return true
+}
+
+// setTo replaces the suffix after b[:j+1] with s.
+func (p *porterStemmer) setTo(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = p.j + len(s)
+}
+
+// r replaces the suffix after b[:j+1] with s if the stem has at least one vowel-consonant sequence.
+func (p *porterStemmer) r(s string) {
+	if p.m() > 0 {
+		p.setTo(s)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing, as in caresses → caress, ponies → poni,
+// agreed → agree, plastered → plaster, motoring → motor and hopping → hop.
+func (p *porterStemmer) step1ab() {
+	if p.b[p.k] == 's' {
+		switch {
+		case p.ends("sses"):
+			p.k -= 2
+		case p.ends("ies"):
+			p.setTo("i")
+		case p.b[p.k-1] != 's':
+			p.k--
+		}
+	}
+	if p.ends("eed") {
+		if p.m() > 0 {
+			p.k--
+		}
+	} else if (p.ends("ed") || p.ends("ing")) && p.vowelInStem() {
+		p.k = p.j
+		switch {
+		case p.ends("at"):
+			p.setTo("ate")
+		case p.ends("bl"):
+			p.setTo("ble")
+		case p.ends("iz"):
+			p.setTo("ize")
+		case p.doubleC(p.k):
+			if c := p.b[p.k]; c != 'l' && c != 's' && c != 'z' {
+				p.k--
+			}
+		default:
+			p.j = p.k
+			if p.m() == 1 && p.cvc(p.k) {
+				p.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a final y into i when there is another vowel in the stem, as in happy → happi.
+func (p *porterStemmer) step1c() {
+	if p.ends("y") && p.vowelInStem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+// replaceSuffix replaces the first suffix of rules, a list of suffixes each followed by its
+// replacement, that b[:k+1] ends with, if the stem before it has at least one vowel-consonant
+// sequence.
+func (p *porterStemmer) replaceSuffix(rules []string) {
+	for i := 0; i+1 < len(rules); i += 2 {
+		if p.ends(rules[i]) {
+			p.r(rules[i+1])
+			return
+		}
+	}
+}
+
+// step2Rules maps double suffixes to single ones, keyed by their second-to-last letter.
+var step2Rules = map[byte][]string{
+	'a': {"ational", "ate", "tional", "tion"},
+	'c': {"enci", "ence", "anci", "ance"},
+	'e': {"izer", "ize"},
+	'l': {"bli", "ble", "alli", "al", "entli", "ent", "eli", "e", "ousli", "ous"},
+	'o': {"ization", "ize", "ation", "ate", "ator", "ate"},
+	's': {"alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous"},
+	't': {"aliti", "al", "iviti", "ive", "biliti", "ble"},
+	'g': {"logi", "log"},
+}
+
+// step3Rules maps -ic-, -full, -ness and similar suffixes to shorter ones, keyed by their last letter.
+var step3Rules = map[byte][]string{
+	'e': {"icate", "ic", "ative", "", "alize", "al"},
+	'i': {"iciti", "ic"},
+	'l': {"ical", "ic", "ful", ""},
+	's': {"ness", ""},
+}
+
+// step4Suffixes lists the suffixes step4 removes, keyed by their second-to-last letter.
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step2 maps double suffixes to single ones, as in relational → relate and digitizer → digitize.
+func (p *porterStemmer) step2() {
+	p.replaceSuffix(step2Rules[p.b[p.k-1]])
+}
+
+// step3 handles -ic-, -full, -ness and similar suffixes, as in triplicate → triplic and
+// goodness → good.
+func (p *porterStemmer) step3() {
+	p.replaceSuffix(step3Rules[p.b[p.k]])
+}
+
+// step4 removes -ant, -ence and similar suffixes from stems with at least two vowel-consonant
+// sequences, as in adjustable → adjust and adoption → adopt.
+func (p *porterStemmer) step4() {
+	for _, suffix := range step4Suffixes[p.b[p.k-1]] {
+		if !p.ends(suffix) {
+			continue
+		}
+		if suffix == "ion" && (p.j < 0 || p.b[p.j] != 's' && p.b[p.j] != 't') {
+			continue
+		}
+		if p.m() > 1 {
+			p.k = p.j
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and turns -ll into -l in stems with enough vowel-consonant
+// sequences, as in probate → probat and controll → control.
+func (p *porterStemmer) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		if a := p.m(); a > 1 || a == 1 && !p.cvc(p.k-1) {
+			p.k--
+		}
+	}
+	if p.b[p.k] == 'l' && p.doubleC(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
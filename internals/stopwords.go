@@ -0,0 +1,233 @@
+package internals
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// stopWordLists holds the built-in stop-word lists, by the language names the index command
+// accepts for them. The words are lowercase, as they are compared with lowercased words.
+var stopWordLists = map[string]string{
+	"english": `a about above after again against all am an and any are as at be because been
+		before being below between both but by can could did do does doing down during each few
+		for from further had has have having he her here hers herself him himself his how i if in
+		into is it its itself just me more most my myself no nor not now of off on once only or
+		other our ours ourselves out over own same she should so some such than that the their
+		theirs them themselves then there these they this those through to too under until up
+		very was we were what when where which while who whom why will with would you your yours
+		yourself yourselves`,
+	"french": `a ai au aux avec avez avons c ce ces cet cette comme d dans de des du elle elles en
+		est et été était eux il ils j je l la le les leur leurs lui m ma mais me même mes moi mon
+		n ne nos notre nous on ont ou par pas plus pour qu que qui s sa se ses son sont sur t ta
+		te tes toi ton tu un une vos votre vous y à`,
+	"german": `aber alle als also am an auch auf aus bei bin bis bist da damit dann das dass dem
+		den der des die dich dir doch du ein eine einem einen einer eines er es für hat hatte ich
+		ihr im in ist ja kann kein keine man mein mich mir mit nach nicht noch nur oder ohne sein
+		sich sie sind so um und uns unter vom von vor war was wie wir wird zu zum zur über`,
+	"spanish": `a al algo como con de del el ella ellos en entre era es esta este esto está fue
+		ha hay la las le les lo los me mi muy más no nos o para pero por que qué se ser sin sobre
+		son su sus también te tu un una uno y ya yo`,
+	"italian": `a ad al alla alle anche che chi ci come con da dal dalla degli dei del della
+		delle di e gli ha i il in io la le lei lo loro lui ma mi ne nel nella non o per quando se
+		si sono su sua suo tra tu un una uno è più`,
+	"portuguese": `a ao aos as com como da das de do dos e ela ele em entre era eu foi mais mas
+		me meu na nas no nos não o os ou para pela pelo por que se sem seu sua são também te um
+		uma você é`,
+	"dutch": `aan al als bij dan dat de die dit door een en er had heb heeft het hij hoe ik in is
+		je kan maar me met mij naar niet nog nu of om ook op te tot uit van voor was wat we wel
+		zal ze zich zij zijn`,
+}
+
+// stopWordLanguages returns the languages with a built-in stop-word list, in alphabetical order.
+func stopWordLanguages() []string {
+	languages := make([]string, 0, len(stopWordLists))
+	for language := range stopWordLists {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// wordFilter describes the filters applied to the words of a chunk before features are built
+// from them: the comma-separated languages whose stop words are removed, a custom list of stop
+// words, one per line, and the stemmer that reduces the remaining words to their stems. The zero
+// value keeps every word as it is, as in indexes built before word filters were configurable.
+type wordFilter struct {
+	StopWords       string
+	CustomStopWords string
+	Stemmer         string
+}
+
+// parseWordFilter builds a word filter from the settings of the index command.
+//
+// Parameters:
+//   - stopWords: A comma-separated list of languages whose built-in stop words are removed, or
+//     "none" or empty for none.
+//   - stopWordsFile: The path to a file of custom stop words, separated by whitespace, where
+//     lines starting with # are comments; no custom stop words when empty.
+//   - stemmer: StemPorter to stem English words, or "none" or empty for no stemming.
+//
+// Returns:
+//   - wordFilter: The parsed filter, with the languages sorted and the custom stop words
+//     normalized, sorted and deduplicated, so that equal settings give equal filters.
+//   - error: An error if a language or the stemmer is unknown, or the stop-word file cannot be read.
+func parseWordFilter(stopWords, stopWordsFile, stemmer string) (wordFilter, error) {
+	var w wordFilter
+	if stopWords != "" && stopWords != "none" {
+		var languages []string
+		for _, language := range strings.Split(stopWords, ",") {
+			language = strings.ToLower(strings.TrimSpace(language))
+			if _, ok := stopWordLists[language]; !ok {
+				return w, fmt.Errorf("unknown stop-word language %q, must be one of %s",
+					language, strings.Join(stopWordLanguages(), ", "))
+			}
+			languages = append(languages, language)
+		}
+		w.StopWords = strings.Join(uniqueSorted(languages), ",")
+	}
+	if stopWordsFile != "" {
+		words, err := readStopWordFile(stopWordsFile)
+		if err != nil {
+			return w, err
+		}
+		w.CustomStopWords = strings.Join(words, "\n")
+	}
+	if stemmer != "none" {
+		w.Stemmer = stemmer
+	}
+	return w, w.validate()
+}
+
+// readStopWordFile reads a custom stop-word list.
+//
+// Parameters:
+//   - path: The path to the file, with stop words separated by whitespace and lines starting
+//     with # ignored.
+//
+// Returns:
+//   - []string: The stop words, normalized like the words they are compared with, sorted and
+//     deduplicated.
+//   - error: An error if the file cannot be read.
+func readStopWordFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening stop-word file: %v", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			if key := wordKey(word); key != "" {
+				words = append(words, key)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading stop-word file: %v", err)
+	}
+	return uniqueSorted(words), nil
+}
+
+// uniqueSorted sorts words and removes duplicates, in place.
+func uniqueSorted(words []string) []string {
+	sort.Strings(words)
+	out := words[:0]
+	for i, word := range words {
+		if i == 0 || word != words[i-1] {
+			out = append(out, word)
+		}
+	}
+	return out
+}
+
+// validate returns an error if the filter names an unknown language or stemmer.
+func (w wordFilter) validate() error {
+	if w.StopWords != "" {
+		for _, language := range strings.Split(w.StopWords, ",") {
+			if _, ok := stopWordLists[language]; !ok {
+				return fmt.Errorf("unsupported stop-word language %q", language)
+			}
+		}
+	}
+	if w.Stemmer != "" && w.Stemmer != StemPorter {
+		return fmt.Errorf("unsupported stemmer %q, must be %q or none", w.Stemmer, StemPorter)
+	}
+	return nil
+}
+
+// String describes the filter for error messages; it is empty when the filter keeps every word.
+func (w wordFilter) String() string {
+	var parts []string
+	if w.StopWords != "" {
+		parts = append(parts, "stopwords:"+w.StopWords)
+	}
+	if w.CustomStopWords != "" {
+		parts = append(parts, fmt.Sprintf("%d custom stop words", strings.Count(w.CustomStopWords, "\n")+1))
+	}
+	if w.Stemmer != "" {
+		parts = append(parts, "stem:"+w.Stemmer)
+	}
+	return strings.Join(parts, "+")
+}
+
+// stopSets caches the stop-word set of each filter, as it is needed for every chunk.
+var stopSets sync.Map // wordFilter -> map[string]bool
+
+// stopSet returns the set of words the filter removes.
+func (w wordFilter) stopSet() map[string]bool {
+	if set, ok := stopSets.Load(w); ok {
+		return set.(map[string]bool)
+	}
+	set := make(map[string]bool)
+	if w.StopWords != "" {
+		for _, language := range strings.Split(w.StopWords, ",") {
+			for _, word := range strings.Fields(stopWordLists[language]) {
+				set[word] = true
+			}
+		}
+	}
+	for _, word := range strings.Fields(w.CustomStopWords) {
+		set[word] = true
+	}
+	stopSets.Store(w, set)
+	return set
+}
+
+// wordKey returns the form of word that is compared with stop words and stemmed: lowercase,
+// without leading and trailing punctuation.
+func wordKey(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, unicode.IsPunct))
+}
+
+// apply removes the stop words from words and stems the remaining ones, reusing the slice.
+// Stemmed words are replaced by the stem of their lowercase form without surrounding
+// punctuation; other words are kept as they are.
+func (w wordFilter) apply(words []string) []string {
+	if w == (wordFilter{}) {
+		return words
+	}
+	stop := w.stopSet()
+	out := words[:0]
+	for _, word := range words {
+		key := wordKey(word)
+		if stop[key] {
+			continue
+		}
+		if w.Stemmer == StemPorter && key != "" {
+			word = porterStem(key)
+		}
+		out = append(out, word)
+	}
+	return out
+}
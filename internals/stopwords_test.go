@@ -0,0 +1,129 @@
+package internals
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestParseWordFilter checks the accepted stop-word and stemmer settings and the canonical form
+// of the languages and custom stop words.
+func TestParseWordFilter(t *testing.T) {
+	dir := t.TempDir()
+	stopFile := filepath.Join(dir, "stop.txt")
+	os.WriteFile(stopFile, []byte("# project words\nFoo bar,\n  foo baz\n"), 0644)
+
+	tests := []struct {
+		name      string
+		stopWords string
+		stopFile  string
+		stemmer   string
+		want      wordFilter
+		wantErr   bool
+	}{
+		{"None", "none", "", "none", wordFilter{}, false},
+		{"Empty", "", "", "", wordFilter{}, false},
+		{"Languages", "German, english,german", "", "", wordFilter{StopWords: "english,german"}, false},
+		{"Custom", "", stopFile, "", wordFilter{CustomStopWords: "bar\nbaz\nfoo"}, false},
+		{"Stemmer", "english", "", StemPorter, wordFilter{StopWords: "english", Stemmer: StemPorter}, false},
+		{"Unknown language", "klingon", "", "", wordFilter{}, true},
+		{"Unknown stemmer", "", "", "snowball", wordFilter{}, true},
+		{"Missing file", "", filepath.Join(dir, "missing.txt"), "", wordFilter{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWordFilter(tt.stopWords, tt.stopFile, tt.stemmer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWordFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseWordFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWordFilter_Apply checks that stop words are removed regardless of case and punctuation and
+// that the remaining words are stemmed.
+func TestWordFilter_Apply(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter wordFilter
+		words  []string
+		want   []string
+	}{
+		{"No filter", wordFilter{}, []string{"The", "cat"}, []string{"The", "cat"}},
+		{"English", wordFilter{StopWords: "english"}, []string{"The", "cat", "sat", "on", "the", "mat."}, []string{"cat", "sat", "mat."}},
+		{"German", wordFilter{StopWords: "german"}, []string{"Der", "Hund", "und", "die", "Katze"}, []string{"Hund", "Katze"}},
+		{"Custom", wordFilter{CustomStopWords: "lorem\nipsum"}, []string{"Lorem", "ipsum", "dolor"}, []string{"dolor"}},
+		{"Stemmed", wordFilter{StopWords: "english", Stemmer: StemPorter}, []string{"The", "Connections", "are", "connected,", "42", "—"}, []string{"connect", "connect", "42", "—"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.apply(tt.words); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWordFilter_SimHash verifies that sentences that only share their stop words are told apart
+// better with stop words removed, and that stemming makes inflected forms hash alike.
+func TestWordFilter_SimHash(t *testing.T) {
+	h := fnv.New64a()
+	a := []byte("the cat and the dog of the house and the garden")
+	b := []byte("the car and the road of the town and the bridge")
+	plain := defaultFeatures
+	filtered := featureSpec{tokenizerFields, 1, 1, 0, wordFilter{StopWords: "english"}}
+	if hammingdistance(plain.simHash(a, h), plain.simHash(b, h)) >= hammingdistance(filtered.simHash(a, h), filtered.simHash(b, h)) {
+		t.Error("Expected removing stop words to move unrelated chunks apart")
+	}
+
+	stemmed := featureSpec{tokenizerFields, 1, 1, 0, wordFilter{Stemmer: StemPorter}}
+	if stemmed.simHash([]byte("connected nations"), h) != stemmed.simHash([]byte("Connecting nation"), h) {
+		t.Error("Expected stemmed inflections to hash alike")
+	}
+}
+
+// TestRunIndex_StopWords verifies that the custom stop words are recorded in the index, so that
+// verification and query text filter words the same way without the stop-word file.
+func TestRunIndex_StopWords(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	indexFile := filepath.Join(dir, "input.idx")
+	stopFile := filepath.Join(dir, "stop.txt")
+	os.WriteFile(input, []byte("The indexer connects the chunks, lorem."), 0644)
+	os.WriteFile(stopFile, []byte("lorem\n"), 0644)
+
+	opts := IndexOptions{ChunkSize: 4096, StopWords: "english", StopWordsFile: stopFile, Stemmer: StemPorter}
+	if err := RunIndex([]string{input}, indexFile, opts); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	os.Remove(stopFile)
+
+	header, err := readIndexHeader(indexFile)
+	if err != nil {
+		t.Fatalf("readIndexHeader() failed: %v", err)
+	}
+	if !reflect.DeepEqual(header.CustomStopWords, []string{"lorem"}) {
+		t.Errorf("Index records custom stop words %q", header.CustomStopWords)
+	}
+	if err := RunVerify(indexFile); err != nil {
+		t.Errorf("RunVerify() without the stop-word file failed: %v", err)
+	}
+
+	query, err := ResolveQuery(indexFile, "", "indexers connecting a chunk", "")
+	if err != nil {
+		t.Fatalf("ResolveQuery() failed: %v", err)
+	}
+	if _, err := Lookup(indexFile, query, QueryOptions{}); err != nil {
+		t.Errorf("Lookup() of the inflected query failed: %v", err)
+	}
+
+	opts.StopWordsFile = stopFile
+	if err := RunIndex([]string{input}, indexFile, opts); err == nil {
+		t.Error("Expected RunIndex() to fail on a missing stop-word file, got nil")
+	}
+}
@@ -0,0 +1,148 @@
+package internals
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+)
+
+// The ways the features of a chunk can be weighted when they vote on the bits of its SimHash,
+// by the names the index command accepts for them.
+const (
+	WeightingTF    = weightingTermFreq
+	WeightingTFIDF = "tfidf"
+)
+
+// idfScale is the fixed-point scale of tf·idf votes. Votes are summed as integers so that the
+// fingerprint does not depend on the order in which features are visited.
+const idfScale = 1 << 20
+
+// parseWeighting validates a weighting name, mapping the empty string to WeightingTF.
+func parseWeighting(weighting string) (string, error) {
+	switch weighting {
+	case "", WeightingTF:
+		return WeightingTF, nil
+	case WeightingTFIDF:
+		return WeightingTFIDF, nil
+	}
+	return "", fmt.Errorf("unknown weighting %q, must be %q or %q", weighting, WeightingTF, WeightingTFIDF)
+}
+
+// idfTable holds the statistics of a corpus that tf·idf weighting needs: the number of chunks,
+// and for the hash of every feature the number of chunks that contain it.
+// The frequencies are either held in docFreqs, or, for a memory-mapped sorted-layout index, in
+// sortedFreqs as the entries of its document frequency area.
+type idfTable struct {
+	chunks      int
+	docFreqs    map[uint64]int
+	sortedFreqs []byte
+}
+
+// newIDFTable returns an empty table.
+func newIDFTable() *idfTable {
+	return &idfTable{docFreqs: make(map[uint64]int)}
+}
+
+// weight returns the smoothed inverse document frequency ln((1+N)/(1+df)) + 1 of the feature with
+// the given hash, where N is the number of chunks and df the number containing the feature. A
+// feature found in every chunk weighs 1, and rarer features weigh more. Features the corpus does
+// not contain, such as words that only occur in query text, weigh the most.
+func (t *idfTable) weight(featureHash uint64) float64 {
+	return math.Log(float64(1+t.chunks)/float64(1+t.docFreq(featureHash))) + 1
+}
+
+// docFreq returns the number of chunks containing the feature with the given hash.
+func (t *idfTable) docFreq(featureHash uint64) int {
+	if t.sortedFreqs == nil {
+		return t.docFreqs[featureHash]
+	}
+	return sortedDocFreq(t.sortedFreqs, featureHash)
+}
+
+// vote returns the weight with which a feature occurring count times in a chunk votes on the bits
+// of its SimHash: count itself without a table, and tf·idf in fixed point with one.
+func (t *idfTable) vote(featureHash uint64, count int) int64 {
+	if t == nil {
+		return int64(count)
+	}
+	return int64(math.Round(float64(count) * t.weight(featureHash) * idfScale))
+}
+
+// addChunks counts the chunks read from chunks, and every distinct feature in each of them.
+func (t *idfTable) addChunks(ctx context.Context, chunks chunker, features featureSpec, h hash.Hash64) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk, _, err := chunks.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t.chunks++
+		for feature := range features.counts(chunk) {
+			t.docFreqs[featureHash(h, feature)]++
+		}
+	}
+}
+
+// documentFrequencies makes a first pass over sources, cutting them into chunks exactly as the
+// build does, and returns the number of chunks each feature occurs in.
+//
+// Parameters:
+//   - ctx: Stops the pass when it is cancelled.
+//   - sources: The documents that are about to be indexed.
+//
+// Returns:
+//   - *idfTable: The document frequencies of the corpus.
+//   - error: An error if a source cannot be read, or ctx.Err() if ctx is cancelled.
+func (fi *FileIndex) documentFrequencies(ctx context.Context, sources []Source) (*idfTable, error) {
+	t := newIDFTable()
+	features := fi.featureSpec()
+	h := fi.hashing.new()
+	for _, src := range sources {
+		if src.Reader != nil {
+			if err := t.addChunks(ctx, fi.chunkSpec().newChunker(io.NewSectionReader(src.Reader, 0, src.Size)), features, h); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		file, err := os.Open(src.Path)
+		if err != nil {
+			return nil, err
+		}
+		err = t.addChunks(ctx, fi.chunkSpec().newChunker(file), features, h)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// idfTable returns the document frequencies recorded in the header of an index built with
+// tf·idf weighting, and nil for an index whose features are weighted by their counts alone.
+func (h IndexHeader) idfTable() *idfTable {
+	if h.Weighting != WeightingTFIDF {
+		return nil
+	}
+	t := &idfTable{chunks: h.DocCount, docFreqs: h.DocFreqs}
+	if t.docFreqs == nil {
+		t.docFreqs = make(map[uint64]int)
+	}
+	return t
+}
+
+// setWeighting records in the header how features were weighted, together with the document
+// frequencies when t is not nil.
+func (h *IndexHeader) setWeighting(t *idfTable) {
+	h.Weighting, h.DocCount, h.DocFreqs = WeightingTF, 0, nil
+	if t != nil {
+		h.Weighting, h.DocCount, h.DocFreqs = WeightingTFIDF, t.chunks, t.docFreqs
+	}
+}
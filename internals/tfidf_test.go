@@ -0,0 +1,156 @@
+package internals
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseWeighting checks the accepted weightings and the default.
+func TestParseWeighting(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", WeightingTF, false},
+		{"tf", WeightingTF, false},
+		{"tfidf", WeightingTFIDF, false},
+		{"bm25", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseWeighting(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWeighting(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseWeighting(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIDFTable_Weight checks that features found in every chunk weigh 1 and rarer ones more.
+func TestIDFTable_Weight(t *testing.T) {
+	table := &idfTable{chunks: 9, docFreqs: map[uint64]int{1: 9, 2: 4}}
+	tests := []struct {
+		name string
+		hash uint64
+		want float64
+	}{
+		{"Every chunk", 1, 1},
+		{"Some chunks", 2, math.Log(2) + 1},
+		{"No chunk", 3, math.Log(10) + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := table.weight(tt.hash); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("weight(%d) = %v, want %v", tt.hash, got, tt.want)
+			}
+		})
+	}
+	if got := (*idfTable)(nil).vote(2, 3); got != 3 {
+		t.Errorf("vote() without a table = %d, want the count 3", got)
+	}
+}
+
+// TestWeightedSimHash verifies that a word common to the whole corpus no longer outvotes the
+// words that tell two chunks apart, and that without a table the hash is the plain SimHash.
+func TestWeightedSimHash(t *testing.T) {
+	h := fnv.New64a()
+	a, b := []byte("the the the the cat"), []byte("the the the the dog")
+	if defaultFeatures.fingerprint(a, h, nil, FingerprintBits64)[0] != defaultFeatures.simHash(a, h) {
+		t.Error("Expected fingerprint() without a table to match simHash()")
+	}
+	if defaultFeatures.simHash(a, h) != defaultFeatures.simHash(b, h) {
+		t.Error("Expected the repeated word to decide every bit with tf weighting")
+	}
+
+	idf := &idfTable{chunks: 100, docFreqs: map[uint64]int{featureHash(h, "the"): 100, featureHash(h, "cat"): 1, featureHash(h, "dog"): 1}}
+	if defaultFeatures.fingerprint(a, h, idf, FingerprintBits64) == defaultFeatures.fingerprint(b, h, idf, FingerprintBits64) {
+		t.Error("Expected tf·idf weighting to tell the chunks apart by their rare words")
+	}
+}
+
+// TestBuildIndexData_TFIDF verifies that the first pass counts every chunk of every source, and
+// that the counts are stored in the header.
+func TestBuildIndexData_TFIDF(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	os.WriteFile(input, []byte("alpha beta gamma alpha epsilon"), 0644)
+	sources := []Source{{Path: input}, {Path: "memory", Reader: strings.NewReader("gamma gamma delta"), Size: 17}}
+
+	data, err := BuildIndexData(context.Background(), sources, IndexOptions{ChunkSize: 17, Chunking: ChunkWords, Weighting: WeightingTFIDF}, 2)
+	if err != nil {
+		t.Fatalf("BuildIndexData() failed: %v", err)
+	}
+	h := fnv.New64a()
+	if data.Header.Weighting != WeightingTFIDF || data.Header.DocCount != 3 {
+		t.Fatalf("Header records weighting %q over %d chunks, want tfidf over 3", data.Header.Weighting, data.Header.DocCount)
+	}
+	for word, want := range map[string]int{"alpha": 2, "beta": 1, "gamma": 2, "delta": 1, "epsilon": 1} {
+		if got := data.Header.DocFreqs[featureHash(h, word)]; got != want {
+			t.Errorf("Document frequency of %q = %d, want %d", word, got, want)
+		}
+	}
+}
+
+// TestRunIndex_TFIDF verifies that query text uses the stored document frequencies, and that
+// updates and merges, which would change them, are refused and leave them unchanged.
+func TestRunIndex_TFIDF(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	text := "the quick brown fox jumps over the lazy dog"
+	os.WriteFile(first, []byte(text), 0644)
+	os.WriteFile(second, []byte("the slow red fox sleeps under the tree"), 0644)
+
+	both := filepath.Join(dir, "both.idx")
+	if err := RunIndex([]string{first, second}, both, IndexOptions{ChunkSize: 4096, Weighting: WeightingTFIDF}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+
+	header, err := readIndexHeader(both)
+	if err != nil {
+		t.Fatalf("readIndexHeader() failed: %v", err)
+	}
+	query, err := ResolveQuery(both, "", text, "")
+	if err != nil {
+		t.Fatalf("ResolveQuery() failed: %v", err)
+	}
+	want := defaultFeatures.fingerprint([]byte(text), fnv.New64a(), header.idfTable(), FingerprintBits64)
+	if query != want.String() {
+		t.Errorf("ResolveQuery() = %s, want %x", query, want)
+	}
+	if _, err := Lookup(both, query, QueryOptions{}); err != nil {
+		t.Errorf("Lookup() of the query text failed: %v", err)
+	}
+
+	// New documents would change the document frequencies, so updates and merges are refused
+	third := filepath.Join(dir, "third.txt")
+	os.WriteFile(third, []byte("a quick red fox"), 0644)
+	if err := RunUpdate(io.Discard, both, []string{third}); err == nil {
+		t.Error("Expected RunUpdate() to refuse a tf-idf index, got nil")
+	}
+	updated, err := readIndexHeader(both)
+	if err != nil {
+		t.Fatalf("readIndexHeader() failed: %v", err)
+	}
+	if updated.DocCount != header.DocCount || !reflect.DeepEqual(updated.DocFreqs, header.DocFreqs) {
+		t.Errorf("Refused update changed the document frequencies to %d chunks, %v", updated.DocCount, updated.DocFreqs)
+	}
+	only := filepath.Join(dir, "only.idx")
+	if err := RunIndex([]string{third}, only, IndexOptions{ChunkSize: 4096, Weighting: WeightingTFIDF}); err != nil {
+		t.Fatalf("RunIndex() failed: %v", err)
+	}
+	if err := RunMerge(io.Discard, filepath.Join(dir, "merged.idx"), []string{both, only}); err == nil {
+		t.Error("Expected RunMerge() to refuse tf-idf indexes, got nil")
+	}
+}
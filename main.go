@@ -1,13 +1,34 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 	"textindexer/internals"
+	"textindexer/pkg/textindex"
 )
 
+// inputList collects the values of a flag that may be given more than once, such as -i for the index command.
+type inputList []string
+
+func (l *inputList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *inputList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// isTerminal reports whether f is attached to a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 // main is the entry point of the application. It parses command-line arguments
 // and executes the appropriate command based on the provided options.
 //
@@ -17,19 +38,89 @@ import (
 //
 //	-c index  : Indexes the input file and generates an output index file.
 //	  Options:
-//	    -i string : Input file path (required)
-//	    -s int    : Chunk size in bytes (default: 4096)
+//	    -i string : Input file, glob pattern or directory (required, may be repeated)
+//	    -s int    : Chunk size in bytes, the average size with -chunking cdc and the largest size
+//	                with the text-aligned chunkings (default: 4096)
+//	    -chunking string : How to cut files into chunks, "fixed", "cdc", or aligned to text with
+//	                "words", "sentences", "paragraphs" or "lines" (default: fixed)
+//	    -min int  : Minimum content-defined chunk size in bytes (default: a quarter of -s)
+//	    -max int  : Maximum content-defined chunk size in bytes (default: four times -s)
+//	    -lines int : Lines per chunk with -chunking lines (default: 1)
+//	    -stride int : Start a chunk every stride bytes, so that fixed chunks of -s bytes overlap
+//	                when it is smaller than -s (default: -s)
+//	    -features string : SimHash features, word n-grams ("words", "words:1-3") or character
+//	                k-grams ("chars", "chars:3-5") (default: words:1)
+//	    -normalize string : Comma-separated normalization steps applied before features are
+//	                extracted, from "nfkc", "fold", "punct" and "digits", or "all"
+//	                (default: none)
+//	    -stopwords string : Comma-separated languages whose stop words are removed before features
+//	                are built: english, french, german, spanish, italian, portuguese, dutch
+//	                (default: none)
+//	    -stopfile string : File of custom stop words to remove, separated by whitespace
+//	    -stem string : Stemmer the remaining words are reduced with, "porter" or "none"
+//	                (default: none)
+//	    -hash string : Feature hash function: fnv1a, xxhash64, murmur3 or siphash (default: fnv1a)
+//	    -hashkey string : SipHash key as 32 hexadecimal digits (default: a random key)
+//	    -bits int : Width of the SimHash fingerprints, 64, 128 or 256 (default: 64)
+//	    -weighting string : How features vote on the bits of a SimHash, "tf" by their counts or
+//	                "tfidf" by tf·idf over the corpus, which reads the inputs twice (default: tf)
+//	    -similarity string : "simhash", or "minhash" to also store a MinHash signature of every chunk
+//	                for fuzzy search by Jaccard similarity, which requires -layout gob (default: simhash)
+//	    -perms int : Length of each MinHash signature (default: 128)
+//	    -bands int : Number of LSH bands each signature is split into, dividing -perms
+//	                (default: bands of 4 rows)
+//	    -o string : Output index file path (required)
+//	    -layout string : On-disk layout, "gob" or "sorted" (default: gob)
+//	    -dump string   : Human-readable dump path, empty to disable (default: simhash.txt)
+//	    -sort string   : Dump order, "hash" or "offset" (default: hash)
+//	    -format string : Dump format: text, json, ndjson or csv (default: text)
+//	    -progress bool : Draw a progress bar on stderr when it is a terminal (default: true)
+//	  Interrupting the command with Ctrl-C stops the build without writing any output.
+//
+//	-c update : Updates an existing index with new, appended or changed input files.
+//	  Options:
+//	    -o string : Index file path (required)
+//	    -i string : Additional input file, glob pattern or directory (optional, may be repeated)
+//
+//	-c merge  : Merges several index files into one.
+//	  Usage: textindex -c merge -o out.idx a.idx b.idx ...
+//	  Options:
 //	    -o string : Output index file path (required)
 //
 //	-c lookup : Looks up a SimHash value in the specified index file.
 //	  Options:
-//	    -i string : Index file path (required)
-//	    -h string : SimHash value to lookup (required)
+//	    -i string  : Index file path (required)
+//	    -h string  : SimHash value to lookup
+//	    -q string  : Text whose SimHash to lookup
+//	    -qf string : File whose contents' SimHash to lookup
+//	    -hash string : Feature hash the -h SimHash was computed with; indexes built with another
+//	                hash are rejected (default: not checked)
+//	    -stale string : Action when a source file changed since indexing: warn, fail or ignore (default: warn)
+//	    -format string : Output format: text, json, ndjson or csv (default: text)
+//	  Exactly one of -h, -q or -qf is required.
 //
 //	-c fuzzy  : Performs a fuzzy search for a SimHash value in the specified index file.
 //	  Options:
+//	    -i string  : Index file path (required)
+//	    -h string  : SimHash value for fuzzy search
+//	    -q string  : Text whose SimHash to search for
+//	    -qf string : File whose contents' SimHash to search for
+//	    -hash string : Feature hash the -h SimHash was computed with; indexes built with another
+//	                hash are rejected (default: not checked)
+//	    -d int     : Maximum Hamming distance to report, up to the fingerprint width of the index
+//	                (default: 1)
+//	    -jaccard float : Search the MinHash signatures instead, reporting chunks whose estimated
+//	                Jaccard similarity is at least this value, between 0 and 1; -h then names an
+//	                indexed chunk whose signature is used; cannot be combined with -d
+//	                (default: 0, search by SimHash)
+//	    -k int     : Maximum number of matches to report, 0 for all (default: 0)
+//	    -stale string : Action when a source file changed since indexing: warn, fail or ignore (default: warn)
+//	    -format string : Output format: text, json, ndjson or csv (default: text)
+//	  Exactly one of -h, -q or -qf is required.
+//
+//	-c verify : Re-hashes every indexed chunk and reports offsets that no longer match.
+//	  Options:
 //	    -i string : Index file path (required)
-//	    -h string : SimHash value for fuzzy search (required)
 //
 // If an unknown command or invalid options are provided, the program will print an error message and exit.
 func main() {
@@ -50,12 +141,35 @@ func main() {
 	switch command {
 	case "index":
 		indexFlags := flag.NewFlagSet("index", flag.ExitOnError)
-		inputFile := indexFlags.String("i", "", "Input file path (required)")
-		chunkSize := indexFlags.Int("s", 4096, "Chunk size in bytes")
+		var inputs inputList
+		indexFlags.Var(&inputs, "i", "Input file, glob pattern or directory (required, may be repeated)")
+		chunkSize := indexFlags.Int("s", 4096, "Chunk size in bytes (average size for cdc, largest size for aligned chunking)")
+		chunking := indexFlags.String("chunking", internals.ChunkFixed, "Chunking: fixed, cdc (content-defined), words, sentences, paragraphs or lines")
+		minChunk := indexFlags.Int("min", 0, "Minimum content-defined chunk size in bytes (default: s/4)")
+		maxChunk := indexFlags.Int("max", 0, "Maximum content-defined chunk size in bytes (default: 4*s)")
+		lines := indexFlags.Int("lines", 1, "Lines per chunk for lines chunking")
+		features := indexFlags.String("features", "words:1", "SimHash features: words[:N[-M]] (word n-grams) or chars[:K[-L]] (character k-grams)")
+		normalize := indexFlags.String("normalize", internals.NormalizeNone, "Text normalization before feature extraction: comma-separated nfkc, fold, punct, digits, or all/none")
+		stopWords := indexFlags.String("stopwords", "none", "Comma-separated languages whose stop words are removed: english, french, german, spanish, italian, portuguese, dutch")
+		stopWordsFile := indexFlags.String("stopfile", "", "File of custom stop words to remove")
+		stemmer := indexFlags.String("stem", "none", "Stemmer for the remaining words: porter or none")
+		hashName := indexFlags.String("hash", internals.HashFNV1a, "Feature hash function: fnv1a, xxhash64, murmur3 or siphash")
+		hashKey := indexFlags.String("hashkey", "", "SipHash key as 32 hexadecimal digits (default: random)")
+		fingerprintBits := indexFlags.Int("bits", internals.FingerprintBits64, "Width of the SimHash fingerprints: 64, 128 or 256")
+		weighting := indexFlags.String("weighting", internals.WeightingTF, "Feature weighting: tf (counts) or tfidf (counts times inverse document frequency)")
+		similarity := indexFlags.String("similarity", internals.SimilaritySimHash, "Similarity: simhash, or minhash to also store MinHash signatures for Jaccard search")
+		minHashPerms := indexFlags.Int("perms", 0, "Length of each MinHash signature (default: 128)")
+		minHashBands := indexFlags.Int("bands", 0, "LSH bands per MinHash signature (default: bands of 4 rows)")
+		stride := indexFlags.Int("stride", 0, "Bytes between the starts of overlapping fixed chunks (default: s, no overlap)")
 		outputFile := indexFlags.String("o", "", "Output index file path (required)")
+		layout := indexFlags.String("layout", "gob", "On-disk layout: gob or sorted")
+		dumpFile := indexFlags.String("dump", "simhash.txt", "Human-readable dump path (empty to disable)")
+		dumpOrder := indexFlags.String("sort", internals.DumpByHash, "Dump order: hash or offset")
+		dumpFormat := indexFlags.String("format", internals.FormatText, "Dump format: text, json, ndjson or csv")
+		showProgress := indexFlags.Bool("progress", true, "Draw a progress bar on stderr when it is a terminal")
 		indexFlags.Parse(args)
 
-		if *inputFile == "" || *outputFile == "" {
+		if len(inputs) == 0 || *outputFile == "" {
 			fmt.Println("Error: -i and -o are required for index command")
 			os.Exit(1)
 		}
@@ -70,7 +184,100 @@ func main() {
 			os.Exit(1)
 		}
 
-		if err := internals.RunIndex(*inputFile, *chunkSize, *outputFile); err != nil {
+		inputFiles, err := internals.ExpandInputs(inputs)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		opts := internals.IndexOptions{
+			ChunkSize:       *chunkSize,
+			Chunking:        *chunking,
+			MinChunkSize:    *minChunk,
+			MaxChunkSize:    *maxChunk,
+			LinesPerChunk:   *lines,
+			Stride:          *stride,
+			Features:        *features,
+			Normalize:       *normalize,
+			StopWords:       *stopWords,
+			StopWordsFile:   *stopWordsFile,
+			Stemmer:         *stemmer,
+			Hash:            *hashName,
+			HashKey:         *hashKey,
+			FingerprintBits: *fingerprintBits,
+			Weighting:       *weighting,
+			Similarity:      *similarity,
+			MinHashPerms:    *minHashPerms,
+			MinHashBands:    *minHashBands,
+			Layout:          *layout,
+			DumpFile:        *dumpFile,
+			DumpOrder:       *dumpOrder,
+			DumpFormat:      *dumpFormat,
+		}
+		if *showProgress && isTerminal(os.Stderr) {
+			opts.Progress = internals.NewProgressBar(os.Stderr)
+		}
+
+		// Cancel the build cleanly on Ctrl-C instead of leaving a partial index behind
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = internals.RunIndexContext(ctx, inputFiles, *outputFile, opts)
+		stop()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "update":
+		updateFlags := flag.NewFlagSet("update", flag.ExitOnError)
+		var inputs inputList
+		updateFlags.Var(&inputs, "i", "Additional input file, glob pattern or directory (may be repeated)")
+		indexFile := updateFlags.String("o", "", "Index file path (required)")
+		updateFlags.Parse(args)
+
+		if *indexFile == "" {
+			fmt.Println("Error: -o is required for update command")
+			os.Exit(1)
+		}
+
+		if !strings.HasSuffix(*indexFile, ".idx") {
+			fmt.Println("Error: please provide an index file (with .idx extension)")
+			os.Exit(1)
+		}
+
+		var inputFiles []string
+		if len(inputs) > 0 {
+			var err error
+			inputFiles, err = textindex.ExpandInputs(inputs)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := textindex.UpdateFile(os.Stdout, *indexFile, inputFiles); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "merge":
+		mergeFlags := flag.NewFlagSet("merge", flag.ExitOnError)
+		outputFile := mergeFlags.String("o", "", "Output index file path (required)")
+		mergeFlags.Parse(args)
+		indexFiles := mergeFlags.Args()
+
+		if *outputFile == "" || len(indexFiles) == 0 {
+			fmt.Println("Error: -o and at least one input index are required for merge command")
+			os.Exit(1)
+		}
+
+		for _, path := range append([]string{*outputFile}, indexFiles...) {
+			if !strings.HasSuffix(path, ".idx") {
+				fmt.Println("Error: please provide index files (with .idx extension)")
+				os.Exit(1)
+			}
+		}
+
+		if err := textindex.MergeFiles(os.Stdout, *outputFile, indexFiles); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -79,10 +286,15 @@ func main() {
 		lookupFlags := flag.NewFlagSet("lookup", flag.ExitOnError)
 		indexFile := lookupFlags.String("i", "", "Index file path")
 		simHashStr := lookupFlags.String("h", "", "SimHash value to lookup")
+		queryText := lookupFlags.String("q", "", "Text to hash and lookup")
+		queryFile := lookupFlags.String("qf", "", "File whose contents to hash and lookup")
+		hashName := lookupFlags.String("hash", "", "Feature hash the -h SimHash was computed with (default: not checked)")
+		stale := lookupFlags.String("stale", textindex.StaleWarn, "Action when a source file changed: warn, fail or ignore")
+		format := lookupFlags.String("format", textindex.FormatText, "Output format: text, json, ndjson or csv")
 		lookupFlags.Parse(args)
 
-		if *indexFile == "" || *simHashStr == "" {
-			fmt.Println("Error: -i and -h are required for lookup command")
+		if *indexFile == "" {
+			fmt.Println("Error: -i is required for lookup command")
 			os.Exit(1)
 		}
 
@@ -91,7 +303,13 @@ func main() {
 			os.Exit(1)
 		}
 
-		if err := internals.RunLookup(*indexFile, *simHashStr); err != nil {
+		query, err := textindex.ResolveQuery(*indexFile, *simHashStr, *queryText, *queryFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := textindex.LookupFile(os.Stdout, *indexFile, query, textindex.QueryOptions{Hash: *hashName, Stale: *stale, Format: *format}); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -101,10 +319,64 @@ func main() {
 		fuzzyFlags := flag.NewFlagSet("fuzzy", flag.ExitOnError)
 		indexFile := fuzzyFlags.String("i", "", "Index file path")
 		simHashStr := fuzzyFlags.String("h", "", "SimHash value for fuzzy search")
+		queryText := fuzzyFlags.String("q", "", "Text to hash and search for")
+		queryFile := fuzzyFlags.String("qf", "", "File whose contents to hash and search for")
+		hashName := fuzzyFlags.String("hash", "", "Feature hash the -h SimHash was computed with (default: not checked)")
+		maxDistance := fuzzyFlags.Int("d", 1, "Maximum Hamming distance")
+		minJaccard := fuzzyFlags.Float64("jaccard", 0, "Minimum estimated Jaccard similarity, to search MinHash signatures instead (0 to search by SimHash)")
+		topK := fuzzyFlags.Int("k", 0, "Maximum number of matches to report (0 for all)")
+		stale := fuzzyFlags.String("stale", textindex.StaleWarn, "Action when a source file changed: warn, fail or ignore")
+		format := fuzzyFlags.String("format", textindex.FormatText, "Output format: text, json, ndjson or csv")
 		fuzzyFlags.Parse(args)
 
-		if *indexFile == "" || *simHashStr == "" {
-			fmt.Println("Error: -i and -h are required for fuzzy command")
+		if *indexFile == "" {
+			fmt.Println("Error: -i is required for fuzzy command")
+			os.Exit(1)
+		}
+
+		if !strings.HasSuffix(*indexFile, ".idx") {
+			fmt.Println("Error: please input an index file")
+			os.Exit(1)
+		}
+
+		queryOpts := textindex.QueryOptions{Hash: *hashName, Stale: *stale, Format: *format}
+		if *minJaccard != 0 {
+			distanceSet := false
+			fuzzyFlags.Visit(func(f *flag.Flag) {
+				if f.Name == "d" {
+					distanceSet = true
+				}
+			})
+			if distanceSet {
+				fmt.Println("Error: -d cannot be combined with -jaccard")
+				os.Exit(1)
+			}
+
+			if err := textindex.SimilarFile(os.Stdout, *indexFile, *simHashStr, *queryText, *queryFile, *minJaccard, *topK, queryOpts); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		query, err := textindex.ResolveQuery(*indexFile, *simHashStr, *queryText, *queryFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := textindex.NearFile(os.Stdout, *indexFile, query, *maxDistance, *topK, queryOpts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "verify":
+		verifyFlags := flag.NewFlagSet("verify", flag.ExitOnError)
+		indexFile := verifyFlags.String("i", "", "Index file path")
+		verifyFlags.Parse(args)
+
+		if *indexFile == "" {
+			fmt.Println("Error: -i is required for verify command")
 			os.Exit(1)
 		}
 
@@ -113,13 +385,13 @@ func main() {
 			os.Exit(1)
 		}
 
-		if err := internals.RunFuzzy(*indexFile, *simHashStr); err != nil {
+		if err := internals.RunVerify(*indexFile); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 
 	default:
-		fmt.Println("Invalid command. Use 'index' or 'lookup'.")
+		fmt.Println("Invalid command. Use 'index', 'update', 'merge', 'lookup', 'fuzzy' or 'verify'.")
 		os.Exit(1)
 
 	}
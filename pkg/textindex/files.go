@@ -0,0 +1,80 @@
+package textindex
+
+import (
+	"io"
+
+	"textindexer/internals"
+)
+
+// The ways LookupFile, NearFile and SimilarFile can react to a document that changed after it was
+// indexed.
+const (
+	StaleWarn   = internals.StaleWarn
+	StaleFail   = internals.StaleFail
+	StaleIgnore = internals.StaleIgnore
+)
+
+// The formats LookupFile, NearFile and SimilarFile can write their results in.
+const (
+	FormatText   = internals.FormatText
+	FormatJSON   = internals.FormatJSON
+	FormatNDJSON = internals.FormatNDJSON
+	FormatCSV    = internals.FormatCSV
+)
+
+// QueryOptions configures LookupFile, NearFile and SimilarFile.
+//   - Hash: the feature hash a queried SimHash was computed with, such as HashXXHash64; when set,
+//     indexes built with another hash are rejected. Not checked when empty.
+//   - Stale: StaleWarn (the default when empty), StaleFail or StaleIgnore.
+//   - Format: FormatText (the default when empty), FormatJSON, FormatNDJSON or FormatCSV.
+type QueryOptions = internals.QueryOptions
+
+// ExpandInputs resolves files, glob patterns and directories into the list of files they name, the
+// way the index and update commands do.
+func ExpandInputs(inputs []string) ([]string, error) {
+	return internals.ExpandInputs(inputs)
+}
+
+// UpdateFile brings the index file at indexFile up to date with its documents in place, adding
+// the files at paths to it, and writes a summary of the changes to w. It refuses an index weighted
+// with WeightingTFIDF, which must be rebuilt instead.
+func UpdateFile(w io.Writer, indexFile string, paths []string) error {
+	return internals.RunUpdate(w, indexFile, paths)
+}
+
+// MergeFiles merges the index files at indexFiles into outputFile, in the layout of the first one,
+// and writes a summary of the merged index to w. It refuses indexes weighted with WeightingTFIDF,
+// whose documents must be indexed together instead.
+func MergeFiles(w io.Writer, outputFile string, indexFiles []string) error {
+	return internals.RunMerge(w, outputFile, indexFiles)
+}
+
+// ResolveQuery returns the hexadecimal SimHash LookupFile and NearFile search indexFile for.
+// Exactly one of hash, text and file must be set: hash is returned unchanged, and text or the
+// contents of the file at file are hashed the way the chunks of indexFile were.
+func ResolveQuery(indexFile, hash, text, file string) (string, error) {
+	return internals.ResolveQuery(indexFile, hash, text, file)
+}
+
+// LookupFile writes every chunk of the index file at indexFile indexed under the hexadecimal
+// SimHash query to w, with a phrase read from its document.
+func LookupFile(w io.Writer, indexFile, query string, opts QueryOptions) error {
+	return internals.RunLookup(w, indexFile, query, opts)
+}
+
+// NearFile writes to w the chunks of the index file at indexFile whose fingerprint is within
+// maxDist bits of the hexadecimal SimHash query, closest first. It writes at most topK chunks,
+// or every chunk when topK is 0, and rejects a maxDist that is negative or wider than the
+// fingerprints of the index.
+func NearFile(w io.Writer, indexFile, query string, maxDist, topK int, opts QueryOptions) error {
+	return internals.RunFuzzy(w, indexFile, query, maxDist, topK, opts)
+}
+
+// SimilarFile writes to w the chunks of the index file at indexFile, built for SimilarityMinHash,
+// whose estimated Jaccard similarity to the query is at least minJaccard, most similar first.
+// Exactly one of hash, text and file must be set: hash names an indexed chunk whose signature is
+// used, and text or the contents of the file at file are split into features the way the index
+// was built. It writes at most topK chunks, or every chunk when topK is 0.
+func SimilarFile(w io.Writer, indexFile, hash, text, file string, minJaccard float64, topK int, opts QueryOptions) error {
+	return internals.RunFuzzyJaccard(w, indexFile, hash, text, file, minJaccard, topK, opts)
+}
@@ -0,0 +1,369 @@
+// Package textindex builds and queries SimHash indexes of text documents.
+//
+// An Indexer splits each document into chunks and fingerprints every chunk with
+// SimHash. The resulting Index answers exact lookups and near-duplicate searches by Hamming
+// distance, or by estimated Jaccard similarity when it is built for SimilarityMinHash, and can
+// be saved to and loaded from the same file format the textindex command uses:
+//
+//	ix := textindex.NewIndexer(textindex.Options{ChunkSize: 4096})
+//	index, err := ix.Build(ctx, "a.txt", "b.txt")
+//	if err != nil {
+//		return err
+//	}
+//	near, err := index.Near(index.Hash(query), 3)
+//	if err != nil {
+//		return err
+//	}
+//	for _, hit := range near {
+//		fmt.Println(hit.File, hit.Offset, hit.Distance)
+//	}
+package textindex
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+
+	"textindexer/internals"
+)
+
+// DefaultChunkSize is the chunk size used when Options.ChunkSize is 0.
+const DefaultChunkSize = 4096
+
+// The ways an Indexer can cut documents into chunks.
+const (
+	ChunkFixed      = internals.ChunkFixed
+	ChunkCDC        = internals.ChunkCDC
+	ChunkWords      = internals.ChunkWords
+	ChunkSentences  = internals.ChunkSentences
+	ChunkParagraphs = internals.ChunkParagraphs
+	ChunkLines      = internals.ChunkLines
+)
+
+// The text normalization steps an Indexer can apply before extracting features.
+const (
+	NormalizeNFKC   = internals.NormalizeNFKC
+	NormalizeFold   = internals.NormalizeFold
+	NormalizePunct  = internals.NormalizePunct
+	NormalizeDigits = internals.NormalizeDigits
+	NormalizeAll    = internals.NormalizeAll
+)
+
+// StemPorter is the Options.Stemmer that reduces English words to their stems.
+const StemPorter = internals.StemPorter
+
+// The functions an Indexer can hash features with.
+const (
+	HashFNV1a    = internals.HashFNV1a
+	HashXXHash64 = internals.HashXXHash64
+	HashMurmur3  = internals.HashMurmur3
+	HashSipHash  = internals.HashSipHash
+)
+
+// The widths of the fingerprints an Indexer can compute.
+const (
+	FingerprintBits64  = internals.FingerprintBits64
+	FingerprintBits128 = internals.FingerprintBits128
+	FingerprintBits256 = internals.FingerprintBits256
+)
+
+// The ways an Indexer can weight the features of a chunk.
+const (
+	WeightingTF    = internals.WeightingTF
+	WeightingTFIDF = internals.WeightingTFIDF
+)
+
+// The similarity measures an Indexer can build an Index for, and the default length of a MinHash
+// signature.
+const (
+	SimilaritySimHash   = internals.SimilaritySimHash
+	SimilarityMinHash   = internals.SimilarityMinHash
+	DefaultMinHashPerms = internals.DefaultMinHashPerms
+)
+
+// The on-disk layouts an Index can be saved in.
+const (
+	LayoutGob    = "gob"
+	LayoutSorted = "sorted"
+)
+
+// Fingerprint is a SimHash of 64, 128 or 256 bits, as computed by Index.Hash. Bit i is bit i%64 of
+// word i/64, and the words past the width of the index are zero, so the SimHash of a 64-bit index
+// is the first word. Fingerprints print as hexadecimal numbers with the %x, %s and %v verbs.
+type Fingerprint = internals.Fingerprint
+
+// Progress describes how far a build has got: the document being indexed, the bytes hashed out
+// of the total, the chunks hashed, the average rate in chunks per second, and the estimated time
+// remaining.
+type Progress = internals.Progress
+
+// Options configures an Indexer.
+//   - ChunkSize: the size of each chunk in bytes, the average size for ChunkCDC, or the largest size
+//     for the text-aligned chunkings; DefaultChunkSize when 0.
+//   - Chunking: ChunkFixed (the default when empty) cuts every ChunkSize bytes; ChunkCDC cuts at
+//     content-defined boundaries, so that edits only change the chunks around them; ChunkWords,
+//     ChunkSentences, ChunkParagraphs and ChunkLines cut at the last word end, sentence end, blank
+//     line or line end that fits, so that no word or UTF-8 character is split between chunks.
+//   - MinChunkSize, MaxChunkSize: the bounds on a ChunkCDC chunk; a quarter and four times
+//     ChunkSize when 0.
+//   - LinesPerChunk: the number of lines in a ChunkLines chunk; 1 when 0.
+//   - Stride: for ChunkFixed, the distance between the starts of consecutive chunks. When it is
+//     smaller than ChunkSize the chunks overlap, so that a passage straddling a chunk boundary is
+//     still covered by one chunk, and Lookup and Near report overlapping hits as one region;
+//     ChunkSize when 0.
+//   - Features: the SimHash features of each chunk, word n-grams such as "words:1-3" or character
+//     k-grams such as "chars:3"; single words ("words:1") when empty. Shingles of several words
+//     make fingerprints sensitive to word order, and character k-grams work on text without spaces.
+//   - Normalize: the comma-separated normalization steps applied to the text before features are
+//     extracted, from NormalizeNFKC, NormalizeFold, NormalizePunct and NormalizeDigits, or
+//     NormalizeAll; none when empty. Index.Hash normalizes query text the same way.
+//   - StopWords: the comma-separated languages, such as "english,german", whose built-in stop words
+//     are removed before features are built, so that very common words do not dominate the
+//     fingerprints; none when empty.
+//   - StopWordsFile: the path to a file of custom stop words, separated by whitespace, to remove
+//     as well; none when empty. The words are recorded in the index, so the file is only read once.
+//   - Stemmer: StemPorter to reduce the remaining words to their English stems; none when empty.
+//   - Hash: the function features are hashed with, HashFNV1a (the default when empty), HashXXHash64,
+//     HashMurmur3 or the keyed HashSipHash.
+//   - HashKey: the SipHash key as 32 hexadecimal digits; a random key when empty. The key is
+//     stored in the index, so Index.Hash hashes query text with it.
+//   - FingerprintBits: the width of the fingerprints, FingerprintBits64 (the default when 0),
+//     FingerprintBits128 or FingerprintBits256. Wider fingerprints tell unrelated chunks of large
+//     corpora apart more reliably, and make the index larger.
+//   - Weighting: WeightingTF (the default when empty) to let each feature vote with its count, or
+//     WeightingTFIDF to weight it by tf·idf, so that features common across the corpus count less.
+//     With WeightingTFIDF the sources are read twice, and Index.Hash weights query text with the
+//     document frequencies stored in the index.
+//   - Similarity: SimilaritySimHash (the default when empty), or SimilarityMinHash to also store a
+//     MinHash signature of the set of features of every chunk, so that Similar can search by
+//     estimated Jaccard similarity. MinHash signatures require LayoutGob.
+//   - MinHashPerms: the length of each MinHash signature; DefaultMinHashPerms when 0. Longer
+//     signatures estimate the Jaccard similarity more precisely.
+//   - MinHashBands: the number of LSH bands each signature is split into to find candidates, which
+//     must divide MinHashPerms; bands of 4 rows when 0. More bands find less similar chunks.
+//   - Layout: the layout Save writes, LayoutGob (the default when empty) or LayoutSorted.
+//   - Workers: the number of goroutines hashing chunks in parallel; the number of CPUs when 0.
+//   - Progress: called periodically, from one goroutine at a time, while a build runs, and once when it
+//     ends; may be nil.
+type Options struct {
+	ChunkSize       int
+	Chunking        string
+	MinChunkSize    int
+	MaxChunkSize    int
+	LinesPerChunk   int
+	Stride          int
+	Features        string
+	Normalize       string
+	StopWords       string
+	StopWordsFile   string
+	Stemmer         string
+	Hash            string
+	HashKey         string
+	FingerprintBits int
+	Weighting       string
+	Similarity      string
+	MinHashPerms    int
+	MinHashBands    int
+	Layout          string
+	Workers         int
+	Progress        func(Progress)
+}
+
+// Source is a document read from memory or any other io.ReaderAt rather than from a file.
+// Name is recorded as the document's path in the Index.
+type Source struct {
+	Name   string
+	Reader io.ReaderAt
+	Size   int64
+}
+
+// Hit is a chunk, or a region of overlapping chunks, returned by Lookup, Near or Similar.
+// It contains the following fields:
+//   - File: the path or name of the document the chunk came from.
+//   - SimHash: the fingerprint the chunk is indexed under; for a region, that of its closest chunk.
+//   - Offset: the byte offset of the chunk or region within File.
+//   - Length: the length of the chunk or region in bytes.
+//   - Distance: the Hamming distance between SimHash and the query; 0 for Lookup and Similar.
+//   - Mismatches: for Similar, the number of MinHash signature values that differ from the query's;
+//     0 for Lookup and Near.
+//   - Jaccard: for Similar, the Jaccard similarity between the features of the chunk and those of the
+//     query, estimated from their MinHash signatures; 0 for Lookup and Near.
+type Hit struct {
+	File       string
+	SimHash    Fingerprint
+	Offset     int64
+	Length     int
+	Distance   int
+	Mismatches int
+	Jaccard    float64
+}
+
+// Indexer builds indexes with a fixed set of options. It is safe for concurrent use.
+type Indexer struct {
+	opts Options
+}
+
+// Index is a built or loaded SimHash index. It is safe for concurrent queries.
+type Index struct {
+	data internals.IndexData
+}
+
+// NewIndexer returns an Indexer that builds indexes with the given options.
+func NewIndexer(opts Options) *Indexer {
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	return &Indexer{opts: opts}
+}
+
+// Build indexes the files at paths, in order, into a single Index.
+//
+// Parameters:
+//   - ctx: Stops the build when it is cancelled, shutting down its workers and returning ctx.Err().
+//   - paths: The files to index.
+//
+// Returns:
+//   - *Index: The built index.
+//   - error: An error if the options are invalid, ctx is cancelled, or a file cannot be read.
+func (ix *Indexer) Build(ctx context.Context, paths ...string) (*Index, error) {
+	sources := make([]internals.Source, len(paths))
+	for i, path := range paths {
+		sources[i] = internals.Source{Path: path}
+	}
+	return ix.build(ctx, sources)
+}
+
+// BuildFrom indexes documents read from arbitrary readers, in order, into a single Index.
+//
+// Parameters:
+//   - ctx: Stops the build when it is cancelled, shutting down its workers and returning ctx.Err().
+//   - sources: The documents to index.
+//
+// Returns:
+//   - *Index: The built index.
+//   - error: An error if the options are invalid, ctx is cancelled, or a source cannot be read.
+func (ix *Indexer) BuildFrom(ctx context.Context, sources ...Source) (*Index, error) {
+	converted := make([]internals.Source, len(sources))
+	for i, src := range sources {
+		converted[i] = internals.Source{Path: src.Name, Reader: src.Reader, Size: src.Size}
+	}
+	return ix.build(ctx, converted)
+}
+
+func (ix *Indexer) build(ctx context.Context, sources []internals.Source) (*Index, error) {
+	opts := internals.IndexOptions{
+		ChunkSize:       ix.opts.ChunkSize,
+		Chunking:        ix.opts.Chunking,
+		MinChunkSize:    ix.opts.MinChunkSize,
+		MaxChunkSize:    ix.opts.MaxChunkSize,
+		LinesPerChunk:   ix.opts.LinesPerChunk,
+		Stride:          ix.opts.Stride,
+		Features:        ix.opts.Features,
+		Normalize:       ix.opts.Normalize,
+		StopWords:       ix.opts.StopWords,
+		StopWordsFile:   ix.opts.StopWordsFile,
+		Stemmer:         ix.opts.Stemmer,
+		Hash:            ix.opts.Hash,
+		HashKey:         ix.opts.HashKey,
+		FingerprintBits: ix.opts.FingerprintBits,
+		Weighting:       ix.opts.Weighting,
+		Similarity:      ix.opts.Similarity,
+		MinHashPerms:    ix.opts.MinHashPerms,
+		MinHashBands:    ix.opts.MinHashBands,
+		Layout:          ix.opts.Layout,
+		Progress:        ix.opts.Progress,
+	}
+	data, err := internals.BuildIndexData(ctx, sources, opts, ix.opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{data: data}, nil
+}
+
+// Load decodes an index written by Save or by the textindex command, in any supported format
+// version and layout.
+func Load(r io.Reader) (*Index, error) {
+	data, err := internals.ReadIndexData(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{data: data}, nil
+}
+
+// Save writes the index to w in the layout it was built with.
+func (idx *Index) Save(w io.Writer) error {
+	return internals.WriteIndexData(w, idx.data)
+}
+
+// Lookup returns every chunk indexed under hash, ordered by document and offset.
+func (idx *Index) Lookup(hash Fingerprint) []Hit {
+	return hits(idx.data.Lookup(hash))
+}
+
+// Near returns every chunk whose fingerprint is within maxDist bits of hash, closest first.
+// Ties are broken by SimHash, then document, then offset, so results are stable. It returns an
+// error when maxDist is negative or wider than the fingerprints of the index.
+func (idx *Index) Near(hash Fingerprint, maxDist int) ([]Hit, error) {
+	if width := idx.FingerprintBits(); maxDist < 0 || maxDist > width {
+		return nil, fmt.Errorf("invalid distance: %d, must be between 0 and %d", maxDist, width)
+	}
+	return hits(idx.data.Near(hash, maxDist, 0)), nil
+}
+
+// Similar returns every chunk of an index built for SimilarityMinHash whose estimated Jaccard
+// similarity to text is at least minJaccard, most similar first. The text is normalized, filtered
+// and split into features the way the index was built. Candidates are found by their LSH bands, so
+// chunks much less similar than about (1/b)^(1/r), for b bands of r rows, are rarely found. It
+// finds nothing in an index built for SimHash alone.
+func (idx *Index) Similar(text []byte, minJaccard float64) []Hit {
+	return hits(idx.data.Similar(text, minJaccard, 0))
+}
+
+// Documents returns the paths or names of the indexed documents, in the order they were added.
+func (idx *Index) Documents() []string {
+	paths := make([]string, len(idx.data.Documents))
+	for i, doc := range idx.data.Documents {
+		paths[i] = doc.Path
+	}
+	return paths
+}
+
+// ChunkSize returns the size in bytes of the chunks the index was built with.
+func (idx *Index) ChunkSize() int {
+	return idx.data.ChunkSize
+}
+
+// FingerprintBits returns the width in bits of the fingerprints the index was built with.
+func (idx *Index) FingerprintBits() int {
+	if idx.data.Header.FingerprintBits == 0 {
+		return FingerprintBits64
+	}
+	return idx.data.Header.FingerprintBits
+}
+
+// Hash returns the SimHash of text, computed from the features and with the fingerprint width the
+// index was built with, so it can be passed to its Lookup or Near.
+func (idx *Index) Hash(text []byte) Fingerprint {
+	return idx.data.TextSimHash(text)
+}
+
+// hits converts query results into Hits.
+func hits(results []internals.Result) []Hit {
+	out := make([]Hit, len(results))
+	for i, r := range results {
+		out[i] = Hit{
+			File:       r.File,
+			SimHash:    r.SimHash,
+			Offset:     r.Offset,
+			Length:     r.Length,
+			Distance:   r.Distance,
+			Mismatches: r.Mismatches,
+			Jaccard:    r.Jaccard,
+		}
+	}
+	return out
+}
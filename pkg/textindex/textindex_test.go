@@ -0,0 +1,212 @@
+package textindex
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestIndex_BuildQuerySaveLoad builds an index from a file and an in-memory document, queries it,
+// and checks that saving and loading it preserves the answers in both layouts.
+func TestIndex_BuildQuerySaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	fileText := "the quick brown fox jumps over the lazy dog and runs far away"
+	os.WriteFile(path, []byte(fileText), 0644)
+	memText := strings.Repeat("pack my box with five dozen liquor jugs ", 3)
+
+	for _, layout := range []string{LayoutGob, LayoutSorted} {
+		t.Run(layout, func(t *testing.T) {
+			ix := NewIndexer(Options{ChunkSize: 16, Chunking: ChunkFixed, Layout: layout})
+			index, err := ix.Build(context.Background(), path)
+			if err != nil {
+				t.Fatalf("Build() failed: %v", err)
+			}
+			if got := index.Documents(); len(got) != 1 || got[0] != path {
+				t.Errorf("Documents() = %v, want [%s]", got, path)
+			}
+
+			mem, err := ix.BuildFrom(context.Background(), Source{Name: "mem", Reader: strings.NewReader(memText), Size: int64(len(memText))})
+			if err != nil {
+				t.Fatalf("BuildFrom() failed: %v", err)
+			}
+
+			// The first chunk of the file is found by the hash of its text
+			hash := index.Hash([]byte(fileText[:16]))
+			hits := index.Lookup(hash)
+			if len(hits) == 0 || hits[0] != (Hit{File: path, SimHash: hash, Offset: 0, Length: 16}) {
+				t.Fatalf("Lookup() = %+v, want a hit at offset 0", hits)
+			}
+			flipped := hash
+			flipped[0] ^= 1
+			near, err := index.Near(flipped, 1)
+			if err != nil {
+				t.Fatalf("Near() failed: %v", err)
+			}
+			if len(near) == 0 || near[0].Distance != 1 || near[0].Offset != 0 {
+				t.Errorf("Near() = %+v, want the first chunk at distance 1", near)
+			}
+
+			// The last chunk is shorter than the chunk size
+			last := len(memText) / 16 * 16
+			lastHits := mem.Lookup(mem.Hash([]byte(memText[last:])))
+			if len(lastHits) == 0 || lastHits[len(lastHits)-1].Length != len(memText)-last {
+				t.Errorf("Lookup() of the last chunk = %+v, want length %d", lastHits, len(memText)-last)
+			}
+
+			var buf bytes.Buffer
+			if err := index.Save(&buf); err != nil {
+				t.Fatalf("Save() failed: %v", err)
+			}
+			loaded, err := Load(&buf)
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if loaded.ChunkSize() != 16 {
+				t.Errorf("ChunkSize() = %d, want 16", loaded.ChunkSize())
+			}
+			want, _ := index.Near(hash, 64)
+			if got, _ := loaded.Near(hash, 64); !reflect.DeepEqual(got, want) {
+				t.Errorf("Near() after Load() = %+v, want %+v", got, want)
+			}
+			for _, d := range []int{-1, 65} {
+				if _, err := loaded.Near(hash, d); err == nil {
+					t.Errorf("Expected Near() to reject distance %d, got nil", d)
+				}
+			}
+		})
+	}
+}
+
+// TestIndex_Similar builds an index with MinHash signatures and searches it by Jaccard similarity,
+// before and after a save and load.
+func TestIndex_Similar(t *testing.T) {
+	docs := []string{
+		"the quick brown fox jumps over the lazy dog near the quiet river bank today",
+		"pack my box with five dozen liquor jugs and carry them all the way home",
+	}
+	text := strings.Join(docs, "\n\n")
+	ix := NewIndexer(Options{ChunkSize: 80, Chunking: ChunkParagraphs, Similarity: SimilarityMinHash, MinHashPerms: 64})
+	index, err := ix.BuildFrom(context.Background(), Source{Name: "mem", Reader: strings.NewReader(text), Size: int64(len(text))})
+	if err != nil {
+		t.Fatalf("BuildFrom() failed: %v", err)
+	}
+
+	hits := index.Similar([]byte("pack my box with five dozen liquor jugs and carry them all the way"), 0.7)
+	if len(hits) != 1 || hits[0].Offset != int64(len(docs[0])+2) || hits[0].Jaccard < 0.7 {
+		t.Fatalf("Similar() = %+v, want the second paragraph", hits)
+	}
+
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if got := loaded.Similar([]byte(docs[1]), 0.7); !reflect.DeepEqual(got, index.Similar([]byte(docs[1]), 0.7)) || len(got) != 1 || got[0].Jaccard != 1 || got[0].Mismatches != 0 || got[0].Distance != 0 {
+		t.Errorf("Similar() after Load() = %+v, want the second paragraph with similarity 1", got)
+	}
+
+	plain, _ := NewIndexer(Options{}).BuildFrom(context.Background(), Source{Name: "mem", Reader: strings.NewReader(text), Size: int64(len(text))})
+	if got := plain.Similar([]byte(docs[1]), 0.5); len(got) != 0 {
+		t.Errorf("Similar() without MinHash signatures = %+v, want no hits", got)
+	}
+}
+
+// TestIndexer_Errors checks invalid options, missing files and cancelled contexts.
+func TestIndexer_Errors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewIndexer(Options{ChunkSize: -1}).Build(ctx); err == nil {
+		t.Error("Expected error for a negative chunk size, got nil")
+	}
+	if _, err := NewIndexer(Options{Layout: "btree"}).Build(ctx); err == nil {
+		t.Error("Expected error for an unknown layout, got nil")
+	}
+	if _, err := NewIndexer(Options{Similarity: SimilarityMinHash, Layout: LayoutSorted}).Build(ctx); err == nil {
+		t.Error("Expected error for MinHash signatures in the sorted layout, got nil")
+	}
+	if _, err := NewIndexer(Options{}).Build(ctx, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected error for a missing file, got nil")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	src := Source{Name: "mem", Reader: strings.NewReader("text"), Size: 4}
+	if _, err := NewIndexer(Options{}).BuildFrom(cancelled, src); err == nil {
+		t.Error("Expected error for a cancelled context, got nil")
+	}
+	if _, err := Load(strings.NewReader("not an index")); err == nil {
+		t.Error("Expected error loading garbage, got nil")
+	}
+}
+
+// TestFiles queries, updates and merges index files through the file-level functions the
+// textindex command is built on.
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	text := "the quick brown fox jumps over the lazy dog and runs far away"
+	os.WriteFile(path, []byte(text), 0644)
+	index, err := NewIndexer(Options{ChunkSize: 16}).Build(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+	indexFile := filepath.Join(dir, "a.idx")
+	file, _ := os.Create(indexFile)
+	if err := index.Save(file); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	file.Close()
+
+	query, err := ResolveQuery(indexFile, "", text[:16], "")
+	if err != nil {
+		t.Fatalf("ResolveQuery() failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := LookupFile(&out, indexFile, query, QueryOptions{Format: FormatCSV}); err != nil {
+		t.Fatalf("LookupFile() failed: %v", err)
+	}
+	if !strings.Contains(out.String(), path+","+query+",0,16,") {
+		t.Errorf("LookupFile() wrote %q, want the first chunk", out.String())
+	}
+	out.Reset()
+	if err := NearFile(&out, indexFile, query, 3, 1, QueryOptions{Format: FormatNDJSON}); err != nil {
+		t.Fatalf("NearFile() failed: %v", err)
+	}
+	if strings.Count(out.String(), "\n") != 1 || !strings.Contains(out.String(), `"distance":0`) {
+		t.Errorf("NearFile() wrote %q, want the first chunk only", out.String())
+	}
+	if err := NearFile(&out, indexFile, query, 65, 0, QueryOptions{}); err == nil {
+		t.Error("Expected NearFile() to reject a distance wider than the fingerprints, got nil")
+	}
+
+	other := filepath.Join(dir, "b.txt")
+	os.WriteFile(other, []byte("pack my box with five dozen liquor jugs"), 0644)
+	out.Reset()
+	if err := UpdateFile(&out, indexFile, []string{other}); err != nil {
+		t.Fatalf("UpdateFile() failed: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Added: 1,") {
+		t.Errorf("UpdateFile() wrote %q, want one added document", out.String())
+	}
+	merged := filepath.Join(dir, "merged.idx")
+	if err := MergeFiles(io.Discard, merged, []string{indexFile}); err != nil {
+		t.Fatalf("MergeFiles() failed: %v", err)
+	}
+	file, _ = os.Open(merged)
+	defer file.Close()
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if got := loaded.Documents(); !reflect.DeepEqual(got, []string{path, other}) {
+		t.Errorf("Documents() of the merged index = %v, want [%s %s]", got, path, other)
+	}
+}